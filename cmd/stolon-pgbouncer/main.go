@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	stdlog "log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -19,26 +21,34 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"google.golang.org/grpc"
 
+	"github.com/gocardless/stolon-pgbouncer/pkg/consul"
 	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
 	pkgfailover "github.com/gocardless/stolon-pgbouncer/pkg/failover"
+	"github.com/gocardless/stolon-pgbouncer/pkg/fleet"
+	pkgkubernetes "github.com/gocardless/stolon-pgbouncer/pkg/kubernetes"
 	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
 	"github.com/gocardless/stolon-pgbouncer/pkg/stolon"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
 
 	"github.com/alecthomas/kingpin"
 	tlshelpers "github.com/cloudflare/cfssl/helpers"
 	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/mvcc/mvccpb"
 	kitlog "github.com/go-kit/kit/log"
 	level "github.com/go-kit/kit/log/level"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/hashicorp/consul/api"
+	"github.com/jackc/pgx"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var logger kitlog.Logger
@@ -58,14 +68,53 @@ var (
 	superviseWatchRetryInterval         = supervise.Flag("watch-retry-interval", "Interval to retry constructing an etcd watcher").Default("5s").Duration()
 	supervisePgBouncerTimeout           = supervise.Flag("pgbouncer-timeout", "Timeout for PgBouncer operations").Default("5s").Duration()
 	supervisePgBouncerRetryTimeout      = supervise.Flag("pgbouncer-retry-timeout", "Retry failed PgBouncer operations at this interval").Default("5s").Duration()
+	supervisePgBouncerRetryMaxInterval  = supervise.Flag("pgbouncer-retry-max-interval", "Limit for the exponentially increasing interval between retries of failed PgBouncer operations").Default("30s").Duration()
+	supervisePgBouncerMaxAttempts       = supervise.Flag("pgbouncer-max-attempts", "Failed attempts in a row after which we report supervise as stuck retrying, 0 to disable").Default("0").Int()
+	superviseWatchRetryMaxInterval      = supervise.Flag("watch-retry-max-interval", "Limit for the exponentially increasing interval between retries of a failing etcd watcher").Default("1m").Duration()
+	superviseRetryJitter                = supervise.Flag("retry-jitter", "Fraction of each retry interval to randomise, spreading out retries across proxies").Default("0.2").Float64()
 	childProcessTerminationGracePeriod  = supervise.Flag("termination-grace-period", "Pause before rejecting new PgBouncer connections (on shutdown)").Default("15s").Duration()
 	childProcessTerminationPollInterval = supervise.Flag("termination-poll-interval", "Poll PgBouncer for outstanding connections at this rate").Default("10s").Duration()
+	superviseUpgradeMode                = supervise.Flag("upgrade-mode", "How to replace PgBouncer on upgrade (takeover uses -R, reuseport requires so_reuseport)").Default("takeover").Enum("takeover", "reuseport")
+	superviseUpgradeTimeout             = supervise.Flag("upgrade-timeout", "Timeout for a new PgBouncer process to replace the old on upgrade").Default("30s").Duration()
+	superviseUpgradeReadyDelay          = supervise.Flag("upgrade-ready-delay", "Time a new PgBouncer must run before the old is interrupted, in reuseport mode").Default("5s").Duration()
+	superviseUpgradeToken               = supervise.Flag("upgrade-token", "Authentication token for the HTTP upgrade endpoint, which is disabled if empty").Default("").Envar("STBOUNCER_UPGRADE_TOKEN").String()
+	superviseRestartMax                 = supervise.Flag("restart-max", "Restart PgBouncer at most this many times within the restart window, 0 to disable restarts").Default("5").Int()
+	superviseRestartWindow              = supervise.Flag("restart-window", "Window over which to apply the maximum PgBouncer restarts").Default("10m").Duration()
+	superviseRestartBackoff             = supervise.Flag("restart-backoff", "Initial backoff before restarting a crashed PgBouncer").Default("1s").Duration()
+	superviseRestartMaxBackoff          = supervise.Flag("restart-max-backoff", "Maximum backoff before restarting a crashed PgBouncer").Default("30s").Duration()
+	superviseAdopt                      = supervise.Flag("adopt", "Adopt an already running PgBouncer on start, and leave PgBouncer running on shutdown (adopted PgBouncer logs are not captured, Linux only)").Default("false").Bool()
+	superviseAuthFile                   = supervise.Flag("auth-file", "Path to a PgBouncer auth_file that supervise should manage (disabled if empty)").Default("").String()
+	superviseAuthSourceDir              = supervise.Flag("auth-source-dir", "Render auth file from a directory of secret files, named by user and containing the password").Default("").String()
+	superviseAuthSourceKey              = supervise.Flag("auth-source-key", "Render auth file from a store key containing a JSON object of user to password").Default("").String()
+	superviseAuthPollInterval           = supervise.Flag("auth-poll-interval", "Interval at which to check the auth source for changes").Default("30s").Duration()
+	superviseConfirmPrimary             = supervise.Flag("confirm-primary", "Confirm the new master is not in recovery before routing to it").Default("false").Bool()
+	superviseConfirmPrimaryConnstring   = supervise.Flag("confirm-primary-connstring", "Connection string (excluding host and port) used to confirm the master").Default("user=stolon dbname=postgres sslmode=disable").Envar("STBOUNCER_CONFIRM_PRIMARY_CONNSTRING").String()
+	superviseConfirmPrimaryPort         = supervise.Flag("confirm-primary-port", "Port used to confirm the master, such as the keeper PgBouncer (defaults to the Postgres port)").Default("").String()
+	superviseConfirmPrimaryTimeout      = supervise.Flag("confirm-primary-timeout", "Timeout for confirming the master").Default("2s").Duration()
+	superviseConfirmPrimaryOverride     = supervise.Flag("confirm-primary-override-timeout", "Route to an unconfirmed master after failing to confirm it for this long, 0 to never override").Default("0").Duration()
+	superviseFenceTimeout               = supervise.Flag("fence-timeout", "Fence PgBouncer after this long without a confirmed master, 0 to disable fencing").Default("0").Duration()
+	superviseFenceMode                  = supervise.Flag("fence-mode", "How to fence PgBouncer (pause buffers queries, disable rejects new connections)").Default("pause").Enum("pause", "disable")
+	superviseDebounceWindow             = supervise.Flag("debounce-window", "Time a new master must remain unchanged and healthy before we reload PgBouncer, 0 to disable").Default("0").Duration()
+	superviseReconnectMode              = supervise.Flag("reconnect-mode", "How to move server connections to a new master after reload (reconnect requires PgBouncer 1.15+, kill drops clients)").Default("none").Enum("none", "reconnect", "kill")
+	superviseRegistry                   = supervise.Flag("registry", "Publish a record of this proxy to the store, for use by status").Default("false").Bool()
+	superviseRegistryHostname           = supervise.Flag("registry-hostname", "Hostname to publish in the proxy record (defaults to the system hostname)").Default("").String()
+	superviseRegistryTTL                = supervise.Flag("registry-ttl", "Time after which the proxy record expires, should supervise stop refreshing it").Default("30s").Duration()
+	superviseRegistryInterval           = supervise.Flag("registry-interval", "Interval at which to refresh the proxy record").Default("10s").Duration()
+	superviseVerifyIgnoreDatabases      = supervise.Flag("verify-ignore-database", "Database to exclude when verifying PgBouncer targets the master after reload (repeatable)").Strings()
 
 	pauser                     = app.Command("pauser", "Serve the PgBouncer pause API")
 	pauserPgBouncerOptions     = newPgBouncerOptions(pauser)
 	pauserToken                = pauser.Flag("token", "Authentication token for pauser API").Default("").Envar("STBOUNCER_FAILOVER_TOKEN").String()
 	pauserBindAddress          = pauser.Flag("bind-address", "Listen address for the pauser API").Default(":8080").String()
 	pauserInitialResumeTimeout = pauser.Flag("initial-resume-timeout", "Timeout for initially resuming PgBouncer on start-up").Default("5s").Duration()
+	pauserCancelMode           = pauser.Flag("cancel-mode", "Signal Postgres backends that block a pause beyond the grace period (none, cancel, terminate)").Default("none").Enum("none", "cancel", "terminate")
+	pauserCancelGracePeriod    = pauser.Flag("cancel-grace-period", "Time a pause may be blocked before we signal blocking backends").Default("2s").Duration()
+	pauserCancelConnstring     = pauser.Flag("cancel-connstring", "Connection string for the local Postgres, used to signal backends (defaults to --postgres-connstring)").Default("").Envar("STBOUNCER_CANCEL_CONNSTRING").String()
+	pauserPostgresConnstring   = pauser.Flag("postgres-connstring", "Connection string for the local Postgres, used to report when transactions started (disabled if empty)").Default("").Envar("STBOUNCER_POSTGRES_CONNSTRING").String()
+	pauserCancelAllowUsers     = pauser.Flag("cancel-allow-user", "Only signal backends of these users (repeatable)").Strings()
+	pauserCancelDenyUsers      = pauser.Flag("cancel-deny-user", "Never signal backends of these users (repeatable)").Strings()
+	pauserCancelAllowApps      = pauser.Flag("cancel-allow-application", "Only signal backends with these application_names (repeatable)").Strings()
+	pauserCancelDenyApps       = pauser.Flag("cancel-deny-application", "Never signal backends with these application_names (repeatable)").Strings()
 
 	failover                   = app.Command("failover", "Run a zero-downtime failover of the Postgres primary")
 	failoverStolonOptions      = newStolonOptions(failover)
@@ -79,6 +128,11 @@ var (
 	failoverPauseExpiry        = failover.Flag("pause-expiry", "Time to wait before resuming PgBouncer after pause").Default("25s").Duration()
 	failoverResumeTimeout      = failover.Flag("resume-timeout", "Timeout for issuing PgBouncer resumes").Default("5s").Duration()
 	failoverStolonctlTimeout   = failover.Flag("stolonctl-timeout", "Timeout for executing stolonctl commands").Default("5s").Duration()
+	failoverPauseSafety        = failover.Flag("pause-safety-check", "Refuse to pause when session pool clients or long transactions would block it").Default("true").Bool()
+	failoverMaxTransactionAge  = failover.Flag("max-transaction-age", "Refuse to pause while transactions have been open for longer than this (measured from the last request for pausers without --postgres-connstring)").Default("1s").Duration()
+	failoverTolerateFailures   = failover.Flag("tolerate-failures", "Number of standby failures the cluster must survive before we failover").Default("1").Int()
+	failoverProxyTimeout       = failover.Flag("proxy-convergence-timeout", "Timeout for proxies to route to the new master before resuming, 0 to disable").Default("5s").Duration()
+	failoverMaxHeartbeatAge    = failover.Flag("max-keeper-heartbeat-age", "Refuse to failover if a keeper was last seen healthy this long before the latest clusterdata update, 0 to disable").Default("30s").Duration()
 
 	withLock                 = app.Command("with-lock", "Run command with failover lock. Exit status 1=error, 2=no-lock, 3=command-error")
 	withLockStolonOptions    = newStolonOptions(withLock)
@@ -91,6 +145,14 @@ var (
 	statusToken         = status.Flag("token", "Authentication token for pauser API").Default("").Envar("STBOUNCER_FAILOVER_TOKEN").String()
 	statusPauserPort    = status.Flag("pauser-port", "Port on which the pauser APIs are listening").Default("8080").String()
 	statusTimeout       = status.Flag("timeout", "Timeout for fetching the status").Default("5s").Duration()
+	statusTolerate      = status.Flag("tolerate-failures", "Number of standby failures the cluster should survive to be reported healthy").Default("1").Int()
+	statusOutput        = status.Flag("output", "Output format").Default("table").Enum("table", "json")
+
+	watch              = app.Command("watch", "Print changes to the cluster as they happen")
+	watchStolonOptions = newStolonOptions(watch)
+	watchPollInterval  = watch.Flag("poll-interval", "Store poll interval").Default("1m").Duration()
+	watchEvents        = watch.Flag("event", "Only print events of this type, repeat for several").Enums(eventTypes()...)
+	watchOutput        = watch.Flag("output", "Output format").Default("text").Enum("text", "json")
 )
 
 type stolonOptions struct {
@@ -106,17 +168,25 @@ type stolonOptions struct {
 	DialTimeout      time.Duration
 	KeepaliveTime    time.Duration
 	KeepaliveTimeout time.Duration
+	Kubeconfig       string
+	KubeContext      string
+	KubeNamespace    string
 }
 
 func (o *stolonOptions) ClusterdataKey() string {
 	return fmt.Sprintf("%s/%s/clusterdata", o.Prefix, o.ClusterName)
 }
 
+// ProxiesPrefix is where each supervise publishes its fleet.Proxy record
+func (o *stolonOptions) ProxiesPrefix() string {
+	return fmt.Sprintf("%s/%s/stolon-pgbouncer/proxies/", o.Prefix, o.ClusterName)
+}
+
 func newStolonOptions(cmd *kingpin.CmdClause) *stolonOptions {
 	opt := &stolonOptions{}
 
 	cmd.Flag("cluster-name", "Name of the stolon cluster").Default("").Envar("STOLONCTL_CLUSTER_NAME").StringVar(&opt.ClusterName)
-	cmd.Flag("store-backend", "Store backend provider").Default("etcdv3").Envar("STOLONCTL_STORE_BACKEND").StringVar(&opt.Backend)
+	cmd.Flag("store-backend", "Store backend provider (etcdv3, consul, kubernetes)").Default("etcdv3").Envar("STOLONCTL_STORE_BACKEND").StringVar(&opt.Backend)
 	cmd.Flag("store-prefix", "Store prefix").Default("stolon/cluster").Envar("STOLONCTL_STORE_PREFIX").StringVar(&opt.Prefix)
 	cmd.Flag("store-endpoints", "Comma delimited list of store endpoints").Envar("STOLONCTL_STORE_ENDPOINTS").Default("http://127.0.0.1:2379").StringVar(&opt.Endpoints)
 	cmd.Flag("store-timeout", "Timeout for store operations").Default("3s").DurationVar(&opt.Timeout)
@@ -127,12 +197,15 @@ func newStolonOptions(cmd *kingpin.CmdClause) *stolonOptions {
 	cmd.Flag("store-cert-file", "Certificate file for client identification to store").Envar("STOLONCTL_STORE_CERT_FILE").StringVar(&opt.CertFile)
 	cmd.Flag("store-key", "Private key file for client identification to the store").Envar("STOLONCTL_STORE_KEY").StringVar(&opt.KeyFile)
 	cmd.Flag("store-skip-tls-verify", "Skip store server certificate validation").Envar("STOLONCTL_STORE_SKIP_TLS_VERIFY").BoolVar(&opt.SkipTLSVerify)
+	cmd.Flag("kubeconfig", "Path to kubeconfig, when not running in-cluster (kubernetes backend)").Envar("STOLONCTL_KUBECONFIG").StringVar(&opt.Kubeconfig)
+	cmd.Flag("kube-context", "Name of the kubeconfig context to use (kubernetes backend)").Envar("STOLONCTL_KUBE_CONTEXT").StringVar(&opt.KubeContext)
+	cmd.Flag("kube-namespace", "Namespace of the stolon cluster, defaulting to that of the kubeconfig (kubernetes backend)").Envar("STOLONCTL_KUBE_NAMESPACE").StringVar(&opt.KubeNamespace)
 
 	return opt
 }
 
 type pgBouncerOptions struct {
-	User, Password, Database, SocketDir, Port, ConfigFile, ConfigTemplateFile string
+	User, Password, Database, SocketDir, Port, ConfigFile, ConfigTemplateFile, PidFile string
 }
 
 func newPgBouncerOptions(cmd *kingpin.CmdClause) *pgBouncerOptions {
@@ -145,6 +218,7 @@ func newPgBouncerOptions(cmd *kingpin.CmdClause) *pgBouncerOptions {
 	cmd.Flag("pgbouncer-port", "Directory in which the unix socket resides").Default("6432").StringVar(&opt.Port)
 	cmd.Flag("pgbouncer-config-file", "Path to PgBouncer config file").Default("/etc/pgbouncer/pgbouncer.ini").StringVar(&opt.ConfigFile)
 	cmd.Flag("pgbouncer-config-template-file", "Path to PgBouncer config template file").Default("/etc/pgbouncer/pgbouncer.ini.template").StringVar(&opt.ConfigTemplateFile)
+	cmd.Flag("pgbouncer-pid-file", "Path to PgBouncer pid file (defaults to pidfile from the config template)").Default("").StringVar(&opt.PidFile)
 
 	return opt
 }
@@ -195,12 +269,127 @@ var (
 		},
 		[]string{"keeper"},
 	)
+	targetMismatch = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stolon_pgbouncer_target_mismatch",
+			Help: "Number of managed databases that PgBouncer does not route to the master after our last reload",
+		},
+	)
+	primaryCheckFailuresTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_primary_check_failures_total",
+			Help: "Count of failures to confirm a new master is out of recovery before routing to it",
+		},
+	)
+	fenced = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stolon_pgbouncer_fenced",
+			Help: "Set to 1 when PgBouncer is fenced due to having no confirmed master",
+		},
+	)
+	fenceTransitionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_fence_transitions_total",
+			Help: "Count of transitions into and out of the fenced state, labelled by state",
+		},
+		[]string{"state"},
+	)
+	childRestartsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_child_restarts_total",
+			Help: "Count of restarts of the PgBouncer child after it exited unexpectedly",
+		},
+	)
+	childLogLinesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_child_log_lines_total",
+			Help: "Count of log lines emitted by the PgBouncer child, labelled by level",
+		},
+		[]string{"level"},
+	)
+	childEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_child_events_total",
+			Help: "Count of notable events logged by the PgBouncer child (login_failure, pooler_error, closing)",
+		},
+		[]string{"event", "reason"},
+	)
+	upgradesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_upgrades_total",
+			Help: "Count of online PgBouncer upgrades, labelled by result",
+		},
+		[]string{"result"},
+	)
+	authFileLastReloadSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stolon_pgbouncer_auth_file_last_reload_seconds",
+			Help: "Most recent PgBouncer reload in response to an auth file change since unix epoch in seconds",
+		},
+	)
 	storeCertificateExpirySeconds = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "stolon_store_certificate_expiry_seconds",
 			Help: "Time in unix epoch seconds at which the store certificate expires",
 		},
 	)
+	storeWatchEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_store_watch_events_total",
+			Help: "Count of changes received from store watches, labelled by stream",
+		},
+		[]string{"stream"},
+	)
+	storeWatchRestartsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_store_watch_restarts_total",
+			Help: "Count of store watch restarts, labelled by stream and reason (error, compacted, closed)",
+		},
+		[]string{"stream", "reason"},
+	)
+	storePollErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_store_poll_errors_total",
+			Help: "Count of failed store polls, labelled by stream",
+		},
+		[]string{"stream"},
+	)
+	storePollDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "stolon_pgbouncer_store_poll_duration_seconds",
+			Help: "Latency of store polls, labelled by stream",
+		},
+		[]string{"stream"},
+	)
+	foldAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_fold_attempts_total",
+			Help: "Count of attempts to apply a change from the store, labelled by fold",
+		},
+		[]string{"fold"},
+	)
+	foldErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_fold_errors_total",
+			Help: "Count of failed attempts to apply a change from the store, labelled by fold",
+		},
+		[]string{"fold"},
+	)
+	foldSuccessSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "stolon_pgbouncer_fold_success_seconds",
+			Help:    "Time taken to successfully apply a change from the store, including retries, labelled by fold",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+		[]string{"fold"},
+	)
+	foldRetriesExhaustedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stolon_pgbouncer_fold_retries_exhausted_total",
+			Help: "Count of times a fold failed --pgbouncer-max-attempts times in a row, labelled by fold",
+		},
+		[]string{"fold"},
+	)
 )
 
 func init() {
@@ -211,7 +400,24 @@ func init() {
 	prometheus.MustRegister(storeLastUpdateSeconds)
 	prometheus.MustRegister(lastKeeperSeconds)
 	prometheus.MustRegister(lastReloadSeconds)
+	prometheus.MustRegister(targetMismatch)
+	prometheus.MustRegister(primaryCheckFailuresTotal)
+	prometheus.MustRegister(fenced)
+	prometheus.MustRegister(fenceTransitionsTotal)
+	prometheus.MustRegister(childRestartsTotal)
+	prometheus.MustRegister(childLogLinesTotal)
+	prometheus.MustRegister(childEventsTotal)
+	prometheus.MustRegister(upgradesTotal)
+	prometheus.MustRegister(authFileLastReloadSeconds)
 	prometheus.MustRegister(storeCertificateExpirySeconds)
+	prometheus.MustRegister(storeWatchEventsTotal)
+	prometheus.MustRegister(storeWatchRestartsTotal)
+	prometheus.MustRegister(storePollErrorsTotal)
+	prometheus.MustRegister(storePollDurationSeconds)
+	prometheus.MustRegister(foldAttemptsTotal)
+	prometheus.MustRegister(foldErrorsTotal)
+	prometheus.MustRegister(foldSuccessSeconds)
+	prometheus.MustRegister(foldRetriesExhaustedTotal)
 }
 
 type exitError struct {
@@ -290,9 +496,61 @@ func mainError() error {
 			checks[keeperUID] = *check
 		}
 
-		fmt.Printf("\n")
-		for client, hc := range checks {
-			fmt.Printf("%s: %s", client, pkgfailover.HealthCheckToString(hc))
+		proxies, err := fleet.List(ctx, client, stopt.ProxiesPrefix())
+		if err != nil {
+			return err
+		}
+
+		report := clusterdata.HealthReport(*statusTolerate)
+		if *statusOutput == "json" {
+			return printStatusJSON(report, checks, proxies)
+		}
+
+		return printStatusTable(*clusterdata, report, checks, proxies)
+
+	case watch.FullCommand():
+		stopt := watchStolonOptions
+
+		client := mustStore(stopt)
+		initial := mustCompatibleClusterdata(ctx, client, stopt)
+
+		kvs, _ := store.NewStream(
+			logger,
+			client,
+			store.StreamOptions{
+				Ctx:                ctx,
+				Keys:               []string{stopt.ClusterdataKey()},
+				GetTimeout:         stopt.Timeout,
+				PollInterval:       *watchPollInterval,
+				WatchRetryInterval: time.Second,
+			},
+		)
+
+		kvs = streams.RevisionFilter(logger, kvs)
+		updates := stolon.ParseClusterdata(ctx, logger, kvs)
+
+		types := []stolon.EventType{}
+		for _, eventType := range *watchEvents {
+			types = append(types, stolon.EventType(eventType))
+		}
+
+		// Report only what changes from the cluster we found at startup
+		watcher := stolon.NewClusterdataWatcher(logger, initial)
+		events := watcher.Subscribe(types...)
+		go watcher.Run(ctx, updates)
+
+		encoder := json.NewEncoder(os.Stdout)
+		for event := range events {
+			if *watchOutput == "json" {
+				if err := encoder.Encode(event); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			fmt.Printf("%s revision=%d %s: %s\n",
+				time.Now().UTC().Format(time.RFC3339), event.Revision, event.Type, event)
 		}
 
 		return nil
@@ -341,6 +599,7 @@ func mainError() error {
 
 		stolonctl := stolon.Stolonctl{
 			ClusterName: stopt.ClusterName, Backend: stopt.Backend, Prefix: stopt.Prefix, Endpoints: stopt.Endpoints,
+			Kubeconfig: stopt.Kubeconfig, KubeContext: stopt.KubeContext, KubeNamespace: stopt.KubeNamespace,
 		}
 
 		// Once our initial context is finished, wait some time before cancelling our defer
@@ -359,13 +618,22 @@ func mainError() error {
 			PauseExpiry:        *failoverPauseExpiry,
 			ResumeTimeout:      *failoverResumeTimeout,
 			StolonctlTimeout:   *failoverStolonctlTimeout,
+
+			TolerateFailures:        *failoverTolerateFailures,
+			CheckPauseSafety:        *failoverPauseSafety,
+			MaxTransactionAge:       *failoverMaxTransactionAge,
+			ProxiesPrefix:           stopt.ProxiesPrefix(),
+			ProxyConvergenceTimeout: *failoverProxyTimeout,
+			MaxKeeperHeartbeatAge:   *failoverMaxHeartbeatAge,
 		}
 
 		failover := pkgfailover.NewFailover(logger, client, clients, stolonctl, opt)
 
 		var err error
 		if *failoverHealthCheckOnly {
-			err = failover.HealthCheckClients(ctx)
+			if err = failover.HealthCheckClients(ctx); err == nil {
+				err = failover.CheckPauseSafe(ctx)
+			}
 		} else {
 			err = failover.Run(ctx, deferCtx)
 		}
@@ -398,7 +666,41 @@ func mainError() error {
 			logger.Log("error", err, "msg", "failed to resume PgBouncer when starting up")
 		}
 
-		server := pkgfailover.NewServer(logger, bouncer)
+		serverOptions := pkgfailover.ServerOptions{
+			CancelPolicy: pkgfailover.CancelPolicy{
+				Mode:              pkgfailover.CancelMode(*pauserCancelMode),
+				AllowUsers:        *pauserCancelAllowUsers,
+				DenyUsers:         *pauserCancelDenyUsers,
+				AllowApplications: *pauserCancelAllowApps,
+				DenyApplications:  *pauserCancelDenyApps,
+			},
+			CancelGracePeriod: *pauserCancelGracePeriod,
+		}
+
+		if *pauserPostgresConnstring != "" {
+			cfg, err := pgx.ParseConnectionString(*pauserPostgresConnstring)
+			if err != nil {
+				kingpin.Fatalf("invalid --postgres-connstring: %v", err)
+			}
+
+			serverOptions.ConnConfig = &cfg
+		}
+
+		if serverOptions.CancelPolicy.Enabled() {
+			connstring := *pauserCancelConnstring
+			if connstring == "" {
+				connstring = *pauserPostgresConnstring
+			}
+
+			cfg, err := pgx.ParseConnectionString(connstring)
+			if err != nil {
+				kingpin.Fatalf("invalid --cancel-connstring: %v", err)
+			}
+
+			serverOptions.CancelPolicy.ConnConfig = cfg
+		}
+
+		server := pkgfailover.NewServer(logger, bouncer, serverOptions)
 		grpcServer := grpc.NewServer(
 			grpc.UnaryInterceptor(
 				grpc_middleware.ChainUnaryServer(
@@ -425,6 +727,8 @@ func mainError() error {
 		pgBouncer := mustPgBouncer(supervisePgBouncerOptions)
 		stopt := superviseStolonOptions
 
+		mustCompatibleClusterdata(ctx, client, stopt)
+
 		clusterIdentifier.WithLabelValues(stopt.ClusterName, "pgbouncer").Set(1)
 		storePollInterval.Set(float64(*supervisePollInterval / time.Second))
 
@@ -435,13 +739,138 @@ func mainError() error {
 		receivedKeeperHost := make(chan interface{})
 		signalReceivedKeeperHost := func() { once.Do(func() { close(receivedKeeperHost) }) }
 
+		// Publish a record of this proxy so status can show which proxies have converged on
+		// the current master. We update the record whenever we reload PgBouncer.
+		publishProxy := func(func(*fleet.Proxy)) {}
+		if *superviseRegistry {
+			var logger = kitlog.With(logger, "component", "fleet.publisher")
+
+			hostname := *superviseRegistryHostname
+			if hostname == "" {
+				var err error
+				if hostname, err = os.Hostname(); err != nil {
+					kingpin.Fatalf("failed to resolve hostname, use --registry-hostname: %v", err)
+				}
+			}
+
+			publisher := fleet.NewPublisher(
+				logger,
+				store.NewRegistration(ctx, client, stopt.ProxiesPrefix()+hostname, *superviseRegistryTTL),
+				fleet.Proxy{Hostname: hostname},
+				fleet.PublisherOptions{
+					Interval:    *superviseRegistryInterval,
+					Timeout:     stopt.Timeout,
+					HealthCheck: pgBouncer.Version,
+				},
+			)
+
+			publishProxy = publisher.Update
+
+			publisherCtx, publisherCancel := context.WithCancel(ctx)
+			g.Add(
+				func() error { return publisher.Run(publisherCtx) },
+				func(error) { publisherCancel() },
+			)
+		}
+
 		{
 			var logger = kitlog.With(logger, "component", "pgbouncer.child")
 
 			cmdCtx, cmdCancel := context.WithCancel(context.Background())
 
-			cmd := exec.CommandContext(cmdCtx, "pgbouncer", supervisePgBouncerOptions.ConfigFile)
-			cmd.Stderr = os.Stderr
+			var pidFile string
+			if *superviseAdopt {
+				pidFile = mustPidFile(supervisePgBouncerOptions, pgBouncer)
+			}
+
+			child := pgbouncer.NewChild(
+				logger,
+				pgbouncer.ChildOptions{
+					ConfigFile:     supervisePgBouncerOptions.ConfigFile,
+					Stderr:         pgbouncer.NewLogWriter(logger, observeChildLog),
+					UpgradeMode:    pgbouncer.UpgradeMode(*superviseUpgradeMode),
+					UpgradeTimeout: *superviseUpgradeTimeout,
+					ReadyDelay:     *superviseUpgradeReadyDelay,
+
+					MaxRestarts:       *superviseRestartMax,
+					RestartWindow:     *superviseRestartWindow,
+					RestartBackoff:    *superviseRestartBackoff,
+					MaxRestartBackoff: *superviseRestartMaxBackoff,
+					OnRestart:         func(error) { childRestartsTotal.Inc() },
+
+					Adopt:   *superviseAdopt,
+					PidFile: pidFile,
+					HealthCheck: func(ctx context.Context) error {
+						ctx, cancel := context.WithTimeout(ctx, *supervisePgBouncerTimeout)
+						defer cancel()
+
+						return pgBouncer.Connect(ctx)
+					},
+				},
+			)
+
+			// Online upgrades can be triggered either by sending SIGUSR2 to supervise, or by
+			// POSTing to the upgrade endpoint on our metrics listener. In both cases we replace
+			// the running PgBouncer with a new process, picking up whatever binary is now
+			// installed, without dropping client connections. As the metrics listener is
+			// otherwise read-only, the endpoint is only served when we have a token to
+			// authenticate requests.
+			upgrade := func() error {
+				upgradeCtx, upgradeCancel := context.WithTimeout(ctx, *superviseUpgradeTimeout)
+				defer upgradeCancel()
+
+				logger.Log("event", "upgrade", "mode", *superviseUpgradeMode, "msg", "replacing PgBouncer process")
+				if err := child.Upgrade(upgradeCtx); err != nil {
+					upgradesTotal.WithLabelValues("failure").Inc()
+					logger.Log("error", err, "msg", "failed to upgrade PgBouncer")
+					return err
+				}
+
+				upgradesTotal.WithLabelValues("success").Inc()
+				return nil
+			}
+
+			go func() {
+				sigc := make(chan os.Signal, 1)
+				signal.Notify(sigc, syscall.SIGUSR2)
+				defer signal.Stop(sigc)
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-sigc:
+						upgrade()
+					}
+				}
+			}()
+
+			if *superviseUpgradeToken != "" {
+				http.HandleFunc("/pgbouncer/upgrade", func(w http.ResponseWriter, r *http.Request) {
+					if r.Method != http.MethodPost {
+						http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+						return
+					}
+
+					authHeader := r.Header.Get("Authorization")
+					if authHeader == "" {
+						http.Error(w, "missing authorization header", http.StatusUnauthorized)
+						return
+					}
+
+					if subtle.ConstantTimeCompare([]byte(authHeader), []byte(*superviseUpgradeToken)) != 1 {
+						http.Error(w, "invalid access token", http.StatusUnauthorized)
+						return
+					}
+
+					if err := upgrade(); err != nil {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+
+					fmt.Fprintln(w, "upgraded")
+				})
+			}
 
 			// Termination handler for PgBouncer. Ensures we only quit PgBouncer once all
 			// connections have finished their work.
@@ -455,10 +884,18 @@ func mainError() error {
 						return nil
 					case <-receivedKeeperHost:
 						logger.Log("event", "starting_pgbouncer", "msg", "received keeper host, starting PgBouncer")
-						return cmd.Run()
+						return child.Run(cmdCtx)
 					}
 				},
 				func(error) {
+					// When adopting, we expect the next supervise to take over our PgBouncer. Rather
+					// than draining connections, we leave PgBouncer running untouched.
+					if *superviseAdopt {
+						logger.Log("event", "detach", "msg", "leaving PgBouncer running for the next supervise to adopt")
+						child.Detach()
+						return
+					}
+
 					// Whatever happens, once we exit this block we want to terminate the PgBouncer
 					// process.
 					defer cmdCancel()
@@ -513,28 +950,56 @@ func mainError() error {
 		{
 			var logger = kitlog.With(logger, "component", "pgbouncer.watch")
 
-			streamOptions := etcd.StreamOptions{
-				Ctx:                ctx,
-				GetTimeout:         stopt.Timeout,
-				PollInterval:       *supervisePollInterval,
-				WatchRetryInterval: *superviseWatchRetryInterval,
-				Keys: []string{
-					fmt.Sprintf("%s/%s/clusterdata", stopt.Prefix, stopt.ClusterName),
-				},
-			}
+			streamOptions := superviseStreamOptions(ctx, stopt, "clusterdata", *supervisePollInterval,
+				fmt.Sprintf("%s/%s/clusterdata", stopt.Prefix, stopt.ClusterName),
+			)
 
-			retryFoldOptions := streams.RetryFoldOptions{
-				Ctx:      ctx,
-				Interval: *supervisePgBouncerRetryTimeout,
-				Timeout:  *supervisePgBouncerTimeout,
-			}
+			retryFoldOptions := superviseRetryFoldOptions(ctx, logger, "clusterdata")
+
+			kvs, _ := store.NewStream(logger, client, streamOptions)
+
+			// If configured, fence PgBouncer whenever we go too long without a confirmed
+			// master, whether that's because clusterdata has no master or because we've lost
+			// contact with the store.
+			var fence *pgbouncer.Fence
+			if *superviseFenceTimeout > 0 {
+				if *superviseFenceTimeout <= *supervisePollInterval {
+					kingpin.Fatalf("--fence-timeout must exceed --poll-interval, or we'll fence between polls")
+				}
+
+				fence = pgbouncer.NewFence(
+					kitlog.With(logger, "component", "pgbouncer.fence"),
+					pgBouncer,
+					pgbouncer.FenceOptions{
+						Mode:             pgbouncer.FenceMode(*superviseFenceMode),
+						Timeout:          *superviseFenceTimeout,
+						Interval:         *supervisePgBouncerRetryTimeout,
+						OperationTimeout: *supervisePgBouncerTimeout,
+						OnTransition: func(isFenced bool) {
+							if isFenced {
+								fenced.Set(1)
+								fenceTransitionsTotal.WithLabelValues("fenced").Inc()
+							} else {
+								fenced.Set(0)
+								fenceTransitionsTotal.WithLabelValues("unfenced").Inc()
+							}
+						},
+					},
+				)
 
-			kvs, _ := etcd.NewStream(logger, client, streamOptions)
+				g.Add(
+					func() error { return fence.Run(ctx) },
+					func(error) { cancel() },
+				)
+			}
 
 			// Before we filter revisions, update our last seen metric so we can detect if etcd
 			// has become unresponsive.
-			kvs = streams.Tap(kvs, func(kv *mvccpb.KeyValue) {
+			kvs = streams.Tap(kvs, func(kv *store.KeyValue) {
 				storeLastUpdateSeconds.SetToCurrentTime()
+				if fence != nil {
+					fence.Contact()
+				}
 			})
 
 			// etcd provides events out-of-order, and potentially duplicated. We need to use the
@@ -542,40 +1007,84 @@ func mainError() error {
 			// duplicates.
 			kvs = streams.RevisionFilter(logger, kvs)
 
+			// Parse clusterdata once, so each of the following stages can use it directly
+			updates := stolon.ParseClusterdata(ctx, logger, kvs)
+
+			// During sentinel instability the master can flap several times in a few seconds.
+			// Debouncing on the master and its health ensures we only reload PgBouncer once
+			// it has settled, while still applying the first clusterdata at boot immediately.
+			if *superviseDebounceWindow > 0 {
+				updates = streams.Debounce(ctx, logger, updates, *superviseDebounceWindow, func(update stolon.ClusterdataUpdate) string {
+					master := update.Clusterdata.Master()
+					return fmt.Sprintf("%s healthy=%v", master.Status.ListenAddress, master.Status.Healthy)
+				})
+			}
+
 			// Track the last reloaded so we can only reload PgBouncer when necessary
 			var lastReloadedAddress string
 
+			var primaryCheck stolon.PrimaryCheck
+			if *superviseConfirmPrimary {
+				connConfig, err := pgx.ParseConnectionString(*superviseConfirmPrimaryConnstring)
+				if err != nil {
+					kingpin.Fatalf("failed to parse --confirm-primary-connstring: %v", err)
+				}
+
+				primaryCheck = stolon.PrimaryCheck{
+					ConnConfig: connConfig,
+					Port:       *superviseConfirmPrimaryPort,
+					Timeout:    *superviseConfirmPrimaryTimeout,
+				}
+			}
+
+			// Track when we first failed to confirm a candidate master, so we can override the
+			// check once the configured timeout has elapsed.
+			var unconfirmedAddress string
+			var unconfirmedSince time.Time
+
 			g.Add(
 				func() error {
 					return streams.RetryFold(
-						logger, kvs, retryFoldOptions,
-						func(ctx context.Context, kv *mvccpb.KeyValue) (err error) {
+						logger, updates, retryFoldOptions,
+						func(ctx context.Context, update stolon.ClusterdataUpdate) (err error) {
 							defer func() {
 								if err != nil {
 									logger.Log("error", err, "msg", "failed to respond to change in clusterdata")
 								}
 							}()
 
-							// It's possible for kv to be nil if our stream is being shutdown
-							if kv == nil {
-								logger.Log("event", "nil_kv", "msg", "nil kv value, channel is shutting down")
-								return nil
-							}
-
-							var clusterdata = &stolon.Clusterdata{}
-							if err := json.Unmarshal(kv.Value, clusterdata); err != nil {
-								return err
-							}
-
+							clusterdata := update.Clusterdata
 							master := clusterdata.Master()
 							masterAddress := master.Status.ListenAddress
 							if masterAddress == "" {
 								logger.Log("event", "clusterdata_no_master", "msg", "no master found, not reloading PgBouncer")
+								if fence != nil {
+									fence.Observe(false)
+								}
+
 								return nil
 							}
 
 							// Only try reloading PgBouncer if the host has really changed
 							if lastReloadedAddress == masterAddress {
+								if fence != nil {
+									fence.Observe(master.Status.Healthy)
+								}
+
+								return nil
+							}
+
+							// When debouncing, we wait for a new master to become healthy before
+							// routing to it, unless we've yet to route anywhere.
+							if *superviseDebounceWindow > 0 && !master.Status.Healthy && lastReloadedAddress != "" {
+								logger.Log("event", "master_unhealthy", "host", master,
+									"msg", "waiting for new master to become healthy before reloading PgBouncer")
+								// We still route to the old master, which stolon no longer considers
+								// primary, so store contact must not keep us unfenced.
+								if fence != nil {
+									fence.Observe(false)
+								}
+
 								return nil
 							}
 
@@ -584,6 +1093,32 @@ func mainError() error {
 							lastKeeperSeconds.Reset()
 							lastKeeperSeconds.WithLabelValues(master.Spec.KeeperUID).SetToCurrentTime()
 
+							// Until we've confirmed the new master is accepting writes, we leave
+							// PgBouncer pointing at the old.
+							if *superviseConfirmPrimary {
+								if err := primaryCheck.Confirm(ctx, master); err != nil {
+									primaryCheckFailuresTotal.Inc()
+									if unconfirmedAddress != masterAddress {
+										unconfirmedAddress, unconfirmedSince = masterAddress, time.Now()
+									}
+
+									if *superviseConfirmPrimaryOverride == 0 || time.Since(unconfirmedSince) < *superviseConfirmPrimaryOverride {
+										logger.Log("event", "confirm_primary_failed", "host", master,
+											"msg", "failed to confirm master, keeping previous target")
+										if fence != nil {
+											fence.Observe(false)
+										}
+
+										return err
+									}
+
+									logger.Log("event", "confirm_primary_override", "error", err,
+										"msg", "failed to confirm master for longer than override timeout, routing to it anyway")
+								}
+
+								unconfirmedAddress = ""
+							}
+
 							logger.Log("event", "generate_configuration", "host", master)
 							if err := pgBouncer.GenerateConfig(masterAddress); err != nil {
 								return err
@@ -600,6 +1135,31 @@ func mainError() error {
 								return err
 							}
 
+							// A successful RELOAD doesn't guarantee PgBouncer routes to our master, as it
+							// may not have parsed our config. We report any databases we rendered for
+							// the master that PgBouncer doesn't route there, but don't fail the reload:
+							// alerts on the gauge are a better signal than retrying forever.
+							mismatches, err := pgBouncer.VerifyTargets(ctx, masterAddress, *superviseVerifyIgnoreDatabases...)
+							if err != nil {
+								logger.Log("error", err, "msg", "failed to verify PgBouncer targets")
+							} else {
+								targetMismatch.Set(float64(len(mismatches)))
+								for _, mismatch := range mismatches {
+									logger.Log("event", "target_mismatch", "database", mismatch.Database,
+										"expected", mismatch.ExpectedHost+":"+mismatch.ExpectedPort,
+										"actual", mismatch.ActualHost+":"+mismatch.ActualPort)
+								}
+							}
+
+							// PgBouncer keeps server connections to the old master open until they're
+							// recycled. If configured, we force them over to the new master, skipping
+							// the first reload on boot as there's no old master to move from.
+							if lastReloadedAddress != "" && *superviseReconnectMode != "none" {
+								if err := reconnectDatabases(ctx, logger, pgBouncer, masterAddress); err != nil {
+									return err
+								}
+							}
+
 							// Mark what we've reloaded to, so we can avoid unnecessary PgBouncer
 							// reloads in response to the clusterdata (not the master) changing.
 							lastReloadedAddress = masterAddress
@@ -610,6 +1170,14 @@ func mainError() error {
 							lastReloadSeconds.Reset()
 							lastReloadSeconds.WithLabelValues(master.Spec.KeeperUID).SetToCurrentTime()
 
+							publishProxy(func(proxy *fleet.Proxy) {
+								proxy.KeeperUID, proxy.Host, proxy.LastReload = master.Spec.KeeperUID, masterAddress, time.Now()
+							})
+
+							if fence != nil {
+								fence.Observe(master.Status.Healthy)
+							}
+
 							return nil
 						},
 					)
@@ -618,6 +1186,107 @@ func mainError() error {
 			)
 		}
 
+		if *superviseAuthFile != "" {
+			var logger = kitlog.With(logger, "component", "pgbouncer.auth_file", "path", *superviseAuthFile)
+
+			if (*superviseAuthSourceDir == "") == (*superviseAuthSourceKey == "") {
+				kingpin.Fatalf("--auth-file requires exactly one of --auth-source-dir or --auth-source-key")
+			}
+
+			authFile := pgbouncer.AuthFile{Path: *superviseAuthFile}
+
+			// Track whether we've written changes that PgBouncer hasn't yet loaded. We may
+			// write the file before PgBouncer has started, or fail to reload, and we want to
+			// keep trying until PgBouncer picks up our change.
+			var pendingReload bool
+
+			updateAuthFile := func(ctx context.Context, users []pgbouncer.User) error {
+				changed, err := authFile.Write(users)
+				if err != nil {
+					return err
+				}
+
+				if changed {
+					logger.Log("event", "auth_file_changed", "users", len(users))
+					pendingReload = true
+				}
+
+				if !pendingReload {
+					return nil
+				}
+
+				logger.Log("event", "reload")
+				if err := pgBouncer.Reload(ctx); err != nil {
+					return err
+				}
+
+				pendingReload = false
+				authFileLastReloadSeconds.SetToCurrentTime()
+
+				return nil
+			}
+
+			if *superviseAuthSourceKey != "" {
+				kvs, _ := store.NewStream(
+					logger,
+					client,
+					superviseStreamOptions(ctx, stopt, "auth_file", *superviseAuthPollInterval, *superviseAuthSourceKey),
+				)
+
+				kvs = streams.RevisionFilter(logger, kvs)
+
+				retryFoldOptions := superviseRetryFoldOptions(ctx, logger, "auth_file")
+
+				g.Add(
+					func() error {
+						return streams.RetryFold(
+							logger, kvs, retryFoldOptions,
+							func(ctx context.Context, kv *store.KeyValue) (err error) {
+								defer func() {
+									if err != nil {
+										logger.Log("error", err, "msg", "failed to update auth file")
+									}
+								}()
+
+								users, err := pgbouncer.ParseUsersJSON(kv.Value)
+								if err != nil {
+									return err
+								}
+
+								return updateAuthFile(ctx, users)
+							},
+						)
+					},
+					func(error) { cancel() },
+				)
+			} else {
+				g.Add(
+					func() error {
+						for {
+							users, err := pgbouncer.LoadUsersFromDirectory(*superviseAuthSourceDir)
+							if err == nil {
+								updateCtx, updateCancel := context.WithTimeout(ctx, *supervisePgBouncerTimeout)
+								err = updateAuthFile(updateCtx, users)
+								updateCancel()
+							}
+
+							if err != nil {
+								logger.Log("error", err, "msg", "failed to update auth file")
+							}
+
+							select {
+							case <-ctx.Done():
+								return nil
+							case <-time.After(*superviseAuthPollInterval):
+								// check again
+							}
+						}
+					},
+					func(error) { cancel() },
+				)
+			}
+		}
+
 		return g.Run()
 	}
 
@@ -641,7 +1310,7 @@ func versionStanza() string {
 
 // mustClusterdata leverages the provided stolonOptions and etcd store to fetch
 // clusterdata.
-func mustClusterdata(ctx context.Context, client *clientv3.Client, stopt *stolonOptions) (*stolon.Clusterdata, string) {
+func mustClusterdata(ctx context.Context, client store.Store, stopt *stolonOptions) (*stolon.Clusterdata, string) {
 	key := stopt.ClusterdataKey()
 	clusterdata, err := stolon.GetClusterdata(ctx, client, key)
 	if err != nil {
@@ -651,6 +1320,90 @@ func mustClusterdata(ctx context.Context, client *clientv3.Client, stopt *stolon
 	return clusterdata, key
 }
 
+// mustCompatibleClusterdata exits if the current clusterdata is of a format we don't
+// understand. Unlike mustClusterdata, we tolerate clusterdata that is missing or can't be
+// fetched, as our callers wait for it to become available, returning nil in that case.
+func mustCompatibleClusterdata(ctx context.Context, client store.Store, stopt *stolonOptions) *stolon.Clusterdata {
+	ctx, cancel := context.WithTimeout(ctx, stopt.Timeout)
+	defer cancel()
+
+	clusterdata, err := stolon.GetClusterdata(ctx, client, stopt.ClusterdataKey())
+	var incompatibleErr *stolon.IncompatibleError
+	if errors.As(err, &incompatibleErr) {
+		kingpin.Fatalf("%s", err)
+	}
+
+	return clusterdata
+}
+
+// superviseStreamOptions configures a stream of the given keys, labelling its metrics
+// with the stream name
+func superviseStreamOptions(ctx context.Context, stopt *stolonOptions, stream string, pollInterval time.Duration, keys ...string) store.StreamOptions {
+	return store.StreamOptions{
+		Ctx:                   ctx,
+		Keys:                  keys,
+		GetTimeout:            stopt.Timeout,
+		PollInterval:          pollInterval,
+		WatchRetryInterval:    *superviseWatchRetryInterval,
+		WatchRetryMaxInterval: *superviseWatchRetryMaxInterval,
+		WatchRetryJitter:      *superviseRetryJitter,
+		OnPoll: func(elapsed time.Duration, err error) {
+			storePollDurationSeconds.WithLabelValues(stream).Observe(elapsed.Seconds())
+			if err != nil {
+				storePollErrorsTotal.WithLabelValues(stream).Inc()
+			}
+		},
+		OnWatchEvent:   func() { storeWatchEventsTotal.WithLabelValues(stream).Inc() },
+		OnWatchRestart: func(reason string) { storeWatchRestartsTotal.WithLabelValues(stream, reason).Inc() },
+	}
+}
+
+// superviseRetryFoldOptions configures a RetryFold, labelling its metrics with the fold
+// name. Should the fold exhaust --pgbouncer-max-attempts, we log an error and count it,
+// allowing alerts to fire on a supervise that is stuck retrying.
+func superviseRetryFoldOptions(ctx context.Context, logger kitlog.Logger, fold string) streams.RetryFoldOptions {
+	errs := make(chan error)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				level.Error(logger).Log("event", "retries_exhausted", "fold", fold, "error", err,
+					"msg", "still retrying, but supervise may be stuck")
+				foldRetriesExhaustedTotal.WithLabelValues(fold).Inc()
+			}
+		}
+	}()
+
+	return streams.RetryFoldOptions{
+		Ctx:         ctx,
+		Interval:    *supervisePgBouncerRetryTimeout,
+		MaxInterval: *supervisePgBouncerRetryMaxInterval,
+		Jitter:      *superviseRetryJitter,
+		Timeout:     *supervisePgBouncerTimeout,
+		MaxAttempts: *supervisePgBouncerMaxAttempts,
+		Errors:      errs,
+		OnAttempt: func(err error) {
+			foldAttemptsTotal.WithLabelValues(fold).Inc()
+			if err != nil {
+				foldErrorsTotal.WithLabelValues(fold).Inc()
+			}
+		},
+		OnSuccess: func(elapsed time.Duration) { foldSuccessSeconds.WithLabelValues(fold).Observe(elapsed.Seconds()) },
+	}
+}
+
+// eventTypes lists the clusterdata events that watch can filter on
+func eventTypes() []string {
+	types := []string{}
+	for _, eventType := range stolon.EventTypes {
+		types = append(types, string(eventType))
+	}
+
+	return types
+}
+
 // mustFailoverClient dials all the keepers in the clusterdata returning a map of keeper
 // UID to failover clients.
 func mustFailoverClients(clusterdata stolon.Clusterdata, port string) map[string]pkgfailover.FailoverClient {
@@ -682,26 +1435,227 @@ func mustPgBouncer(opt *pgBouncerOptions) *pgbouncer.PgBouncer {
 	}
 }
 
-func mustStore(opt *stolonOptions) *clientv3.Client {
-	if opt.Backend != "etcdv3" {
-		kingpin.Fatalf("unsupported store backend: %s", opt.Backend)
+// observeChildLog counts notable events from PgBouncer logs, enabling alerts on login
+// failures or errors reported to clients.
+func observeChildLog(entry pgbouncer.LogEntry) {
+	childLogLinesTotal.WithLabelValues(strings.ToLower(entry.Level)).Inc()
+
+	if entry.IsLoginFailure() {
+		childEventsTotal.WithLabelValues("login_failure", "").Inc()
 	}
 
-	client, err := clientv3.New(
-		clientv3.Config{
-			TLS:                  mustTLS(opt),
-			Endpoints:            strings.Split(opt.Endpoints, ","),
-			DialTimeout:          opt.DialTimeout,
-			DialKeepAliveTime:    opt.KeepaliveTime,
-			DialKeepAliveTimeout: opt.KeepaliveTimeout,
-		},
-	)
+	if reason, ok := entry.PoolerError(); ok {
+		childEventsTotal.WithLabelValues("pooler_error", reason).Inc()
+	}
+
+	if reason, ok := entry.ClosingReason(); ok {
+		childEventsTotal.WithLabelValues("closing", reason).Inc()
+	}
+}
+
+type statusComponent struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type statusPauser struct {
+	Status     string            `json:"status"`
+	Components []statusComponent `json:"components"`
+}
+
+func printStatusJSON(report stolon.HealthReport, checks map[string]pkgfailover.HealthCheckResponse, proxies []fleet.Proxy) error {
+	pausers := map[string]statusPauser{}
+	for keeperUID, check := range checks {
+		pauser := statusPauser{Status: check.Status.String(), Components: []statusComponent{}}
+		for _, component := range check.Components {
+			pauser.Components = append(pauser.Components, statusComponent{
+				Name: component.Name, Status: component.Status.String(), Error: component.Error,
+			})
+		}
+
+		pausers[keeperUID] = pauser
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(struct {
+		Health  stolon.HealthReport     `json:"health"`
+		Pausers map[string]statusPauser `json:"pausers"`
+		Proxies []fleet.Proxy           `json:"proxies"`
+	}{report, pausers, proxies})
+}
+
+func printStatusTable(clusterdata stolon.Clusterdata, report stolon.HealthReport, checks map[string]pkgfailover.HealthCheckResponse, proxies []fleet.Proxy) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	health := "HEALTHY"
+	if !report.Healthy() {
+		health = "UNHEALTHY"
+	}
+
+	fmt.Fprintf(w, "\nCluster (tolerating %d failures): %s\n", report.TolerateFailures, health)
+	fmt.Fprintln(w, "KEEPER\tADDRESS\tROLE\tHEALTHY")
+	for _, db := range report.DBs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", db.KeeperUID, db.ListenAddress, db.Role, db.Healthy)
+	}
+
+	// Explain why a failover would be refused
+	if len(report.Failures) > 0 {
+		fmt.Fprintln(w, "\nFAILURE\tREASON")
+		for _, failure := range report.Failures {
+			fmt.Fprintf(w, "%s\t%s\n", failure.Error, failure.Reason)
+		}
+	}
+
+	fmt.Fprintf(w, "\nPausers:\n")
+	for keeperUID, hc := range checks {
+		fmt.Fprintf(w, "%s: %s", keeperUID, pkgfailover.HealthCheckToString(hc))
+	}
+
+	// Flag any proxy that is unhealthy or still routing to an old master
+	master := clusterdata.Master()
+	fmt.Fprintf(w, "\nProxies (master %s):\n", master)
+	fmt.Fprintln(w, "HOSTNAME\tKEEPER\tHOST\tLAST RELOAD\tVERSION\tSTATUS")
+	for _, proxy := range proxies {
+		status := "ok"
+		if !proxy.Converged(master.Status.ListenAddress) {
+			status = "stale"
+		} else if !proxy.Healthy {
+			status = fmt.Sprintf("unhealthy: %s", proxy.Error)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", proxy.Hostname, proxy.KeeperUID, proxy.Host,
+			proxy.LastReload.Format(time.RFC3339), proxy.PgBouncerVersion, status)
+	}
+
+	return w.Flush()
+}
+
+// reconnectDatabases moves server connections for every database we manage that now
+// targets the given host, using the configured reconnect mode.
+func reconnectDatabases(ctx context.Context, logger kitlog.Logger, bouncer *pgbouncer.PgBouncer, host string) error {
+	configured, err := bouncer.ConfiguredDatabases()
+	if err != nil {
+		return err
+	}
+
+	databases := []string{}
+	for _, db := range configured {
+		if db.Host == host {
+			databases = append(databases, db.Name)
+		}
+	}
+
+	if len(databases) == 0 {
+		return nil
+	}
+
+	logger.Log("event", "reconnect", "mode", *superviseReconnectMode, "databases", strings.Join(databases, ","))
+	if *superviseReconnectMode == "reconnect" {
+		return bouncer.Reconnect(ctx, databases...)
+	}
 
+	// KILL leaves each database paused, so we must resume them for clients to reconnect
+	if err := bouncer.Kill(ctx, databases...); err != nil {
+		return err
+	}
+
+	return bouncer.Resume(ctx, databases...)
+}
+
+// mustPidFile returns the path to the PgBouncer pid file, preferring the explicit option
+// but falling back to the pidfile value from the config template. Adopting PgBouncer
+// depends on finding its pid, so we fail if neither provides one.
+func mustPidFile(opt *pgBouncerOptions, bouncer *pgbouncer.PgBouncer) string {
+	if opt.PidFile != "" {
+		return opt.PidFile
+	}
+
+	config, err := bouncer.Config()
 	if err != nil {
-		kingpin.Fatalf("failed to connect to etcd: %s", err)
+		kingpin.Fatalf("failed to parse PgBouncer config: %s", err)
+	}
+
+	if config["pidfile"] == "" {
+		kingpin.Fatalf("--adopt requires a pid file, either from --pgbouncer-pid-file or pidfile in the config template")
+	}
+
+	return config["pidfile"]
+}
+
+// mustStore connects to the stolon store backend, supporting etcdv3, consul and kubernetes
+func mustStore(opt *stolonOptions) store.Store {
+	switch opt.Backend {
+	case "etcdv3":
+		client, err := clientv3.New(
+			clientv3.Config{
+				TLS:                  mustTLS(opt),
+				Endpoints:            strings.Split(opt.Endpoints, ","),
+				DialTimeout:          opt.DialTimeout,
+				DialKeepAliveTime:    opt.KeepaliveTime,
+				DialKeepAliveTimeout: opt.KeepaliveTimeout,
+			},
+		)
+
+		if err != nil {
+			kingpin.Fatalf("failed to connect to etcd: %s", err)
+		}
+
+		return etcd.NewStore(client)
+	case "consul":
+		// The consul client speaks to a single agent, which is normally local
+		endpoint, err := url.Parse(strings.Split(opt.Endpoints, ",")[0])
+		if err != nil {
+			kingpin.Fatalf("failed to parse consul endpoint: %s", err)
+		}
+
+		cfg := api.DefaultConfig()
+		cfg.Address, cfg.Scheme = endpoint.Host, endpoint.Scheme
+		if tlsConfig := mustTLS(opt); tlsConfig != nil {
+			cfg.Transport.TLSClientConfig = tlsConfig
+		}
+
+		client, err := api.NewClient(cfg)
+		if err != nil {
+			kingpin.Fatalf("failed to connect to consul: %s", err)
+		}
+
+		return consul.NewStore(kitlog.With(logger, "component", "consul"), client)
+	case "kubernetes":
+		// Loading rules fall back to the in-cluster config when no kubeconfig is found
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: opt.Kubeconfig},
+			&clientcmd.ConfigOverrides{CurrentContext: opt.KubeContext},
+		)
+
+		namespace := opt.KubeNamespace
+		if namespace == "" {
+			var err error
+			if namespace, _, err = clientConfig.Namespace(); err != nil {
+				kingpin.Fatalf("failed to resolve kubernetes namespace: %s", err)
+			}
+		}
+
+		cfg, err := clientConfig.ClientConfig()
+		if err != nil {
+			kingpin.Fatalf("failed to load kubernetes config: %s", err)
+		}
+
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			kingpin.Fatalf("failed to create kubernetes client: %s", err)
+		}
+
+		return pkgkubernetes.NewStore(
+			kitlog.With(logger, "component", "kubernetes"), client, namespace, opt.Prefix, opt.ClusterName,
+		)
+	default:
+		kingpin.Fatalf("unsupported store backend: %s", opt.Backend)
 	}
 
-	return client
+	return nil
 }
 
 func mustTLS(opt *stolonOptions) *tls.Config {
@@ -11,8 +11,10 @@ import (
 
 	"github.com/coreos/etcd/clientv3"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
 	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
 	"github.com/gocardless/stolon-pgbouncer/pkg/stolon"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 	"github.com/jackc/pgx"
 
 	. "github.com/onsi/ginkgo"
@@ -35,7 +37,7 @@ var (
 func RunAcceptance(ctx context.Context, logger kitlog.Logger) {
 	Describe("stolon-pgbouncer", func() {
 		var (
-			client *clientv3.Client
+			client store.Store
 		)
 
 		BeforeEach(func() {
@@ -217,7 +219,7 @@ func RunAcceptance(ctx context.Context, logger kitlog.Logger) {
 	})
 }
 
-func expectPgBouncersPointToMaster(ctx context.Context, logger kitlog.Logger, client *clientv3.Client) {
+func expectPgBouncersPointToMaster(ctx context.Context, logger kitlog.Logger, client store.Store) {
 	logger.Log("msg", "expect all PgBouncers point at master")
 	masterAddress := mustClusterdata(ctx, client).Master().Status.ListenAddress
 	for host, port := range pgBouncerPorts {
@@ -288,7 +290,7 @@ func pgConnect(logger kitlog.Logger, port string) *pgx.Conn {
 }
 
 // mustClusterdata returns the stolon cluster data stored in the provided etcd client
-func mustClusterdata(ctx context.Context, client *clientv3.Client) *stolon.Clusterdata {
+func mustClusterdata(ctx context.Context, client store.Store) *stolon.Clusterdata {
 	var cd *stolon.Clusterdata
 
 	Eventually(
@@ -304,8 +306,8 @@ func mustClusterdata(ctx context.Context, client *clientv3.Client) *stolon.Clust
 	return cd
 }
 
-// mustStore returns an etcd client connection to our store
-func mustStore() *clientv3.Client {
+// mustStore returns a store backed by an etcd client connection
+func mustStore() store.Store {
 	var client *clientv3.Client
 
 	Eventually(
@@ -325,5 +327,5 @@ func mustStore() *clientv3.Client {
 		Succeed(), "connection to etcd could not be established",
 	)
 
-	return client
+	return etcd.NewStore(client)
 }
@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var seededRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
+var charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// RandomKey will create a key that can be used in each of our Consul tests, ensuring we
+// test against different keys for each test even if re-using the same Consul agent.
+func RandomKey() string {
+	keyBytes := make([]byte, 20)
+	for idx := range keyBytes {
+		keyBytes[idx] = charset[seededRandom.Intn(len(charset))]
+	}
+
+	return fmt.Sprintf("/%s", keyBytes)
+}
+
+// StartConsul spins up a Consul agent in dev mode, which runs a single in-memory server
+func StartConsul() (client *api.Client, cleanup func()) {
+	var proc *exec.Cmd
+	var workspace string
+
+	cleanup = func() {
+		if proc != nil {
+			proc.Process.Kill()
+		}
+		os.RemoveAll(workspace)
+	}
+
+	workspace, err := ioutil.TempDir("", "consul")
+	Expect(err).NotTo(HaveOccurred(), "could not create consul workspace")
+
+	ports := map[string]int{}
+	for _, name := range []string{"http", "serf-lan", "serf-wan", "server"} {
+		ports[name], err = nextAvailablePort()
+		Expect(err).NotTo(HaveOccurred(), "could not get available port")
+	}
+
+	proc = exec.Command(
+		"consul", "agent", "-dev",
+		"-bind", "127.0.0.1",
+		"-http-port", fmt.Sprintf("%d", ports["http"]),
+		"-serf-lan-port", fmt.Sprintf("%d", ports["serf-lan"]),
+		"-serf-wan-port", fmt.Sprintf("%d", ports["serf-wan"]),
+		"-server-port", fmt.Sprintf("%d", ports["server"]),
+		"-dns-port", "-1",
+		"-grpc-port", "-1",
+	)
+
+	proc.Dir = workspace
+
+	Expect(proc.Start()).To(Succeed(), "could not start consul")
+
+	client, err = api.NewClient(&api.Config{Address: fmt.Sprintf("127.0.0.1:%d", ports["http"])})
+	Expect(err).NotTo(HaveOccurred())
+
+	// The agent serves requests before it has elected itself leader, at which point KV
+	// operations fail
+	Eventually(
+		func() (string, error) { return client.Status().Leader() },
+		10*time.Second,
+	).ShouldNot(
+		BeEmpty(), "timed out waiting for consul to elect a leader",
+	)
+
+	return
+}
+
+func nextAvailablePort() (int, error) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find available port")
+	}
+
+	defer listen.Close()
+	return listen.Addr().(*net.TCPAddr).Port, nil
+}
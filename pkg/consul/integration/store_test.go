@@ -0,0 +1,251 @@
+package integration
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/consul"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		ctx    context.Context
+		cancel func()
+		key    string
+		s      *consul.Store
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		key = RandomKey()
+		s = consul.NewStore(kitlog.NewLogfmtLogger(GinkgoWriter), client)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	matchKv := func(key, value string) types.GomegaMatcher {
+		return PointTo(MatchFields(IgnoreExtras, Fields{"Key": Equal([]byte(key)), "Value": Equal([]byte(value))}))
+	}
+
+	// getPair reads the raw Consul pair, which carries the session holding it
+	getPair := func(key string) *api.KVPair {
+		pair, _, err := client.KV().Get(strings.TrimPrefix(key, "/"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		return pair
+	}
+
+	It("Restores the leading slash Consul strips from keys", func() {
+		Expect(s.Put(ctx, key+"/a", "one")).To(Succeed())
+		Expect(s.Put(ctx, key+"/b", "two")).To(Succeed())
+
+		Expect(s.Get(ctx, key+"/a")).To(matchKv(key+"/a", "one"))
+		Expect(s.List(ctx, key+"/")).To(ConsistOf(matchKv(key+"/a", "one"), matchKv(key+"/b", "two")))
+	})
+
+	Describe("CompareAndSwap", func() {
+		It("Creates keys only when they don't exist", func() {
+			Expect(s.CompareAndSwap(ctx, key, nil, "initial")).To(Succeed())
+			Expect(s.CompareAndSwap(ctx, key, nil, "initial")).To(Equal(store.ErrCompareFailed))
+		})
+
+		It("Swaps only if the key is unchanged since read", func() {
+			Expect(s.Put(ctx, key, "initial")).To(Succeed())
+			initial, err := s.Get(ctx, key)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(s.CompareAndSwap(ctx, key, initial, "swapped")).To(Succeed())
+			Expect(s.CompareAndSwap(ctx, key, initial, "stale")).To(Equal(store.ErrCompareFailed))
+			Expect(s.Get(ctx, key)).To(matchKv(key, "swapped"))
+		})
+	})
+
+	Describe("Watch", func() {
+		var (
+			opt store.WatchOptions
+			out <-chan store.WatchResponse
+		)
+
+		BeforeEach(func() {
+			opt = store.WatchOptions{}
+			Expect(s.Put(ctx, key, "initial")).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			out = s.Watch(ctx, key, opt)
+		})
+
+		receiveKvs := func() []*store.KeyValue {
+			var resp store.WatchResponse
+			Eventually(out, 5*time.Second).Should(Receive(&resp))
+			Expect(resp.Err).NotTo(HaveOccurred())
+			return resp.Kvs
+		}
+
+		It("Emits changes made after the first query, but not existing values", func() {
+			Consistently(out, 500*time.Millisecond).ShouldNot(Receive())
+
+			Expect(s.Put(ctx, key, "changed")).To(Succeed())
+			Expect(receiveKvs()).To(ConsistOf(matchKv(key, "changed")))
+		})
+
+		It("Emits deleted keys with an empty value", func() {
+			Consistently(out, 500*time.Millisecond).ShouldNot(Receive())
+
+			_, err := client.KV().Delete(strings.TrimPrefix(key, "/"), nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(receiveKvs()).To(ConsistOf(
+				PointTo(MatchFields(IgnoreExtras, Fields{"Key": Equal([]byte(key)), "Value": BeEmpty()})),
+			))
+		})
+
+		It("Ignores keys that only share the key as a prefix", func() {
+			Consistently(out, 500*time.Millisecond).ShouldNot(Receive())
+
+			Expect(s.Put(ctx, key+"-sibling", "ignored")).To(Succeed())
+			Consistently(out, 500*time.Millisecond).ShouldNot(Receive())
+		})
+
+		Context("With prefix", func() {
+			BeforeEach(func() { opt.Prefix = true })
+
+			It("Emits changes to every key with the prefix", func() {
+				Consistently(out, 500*time.Millisecond).ShouldNot(Receive())
+
+				Expect(s.Put(ctx, key+"/child", "child")).To(Succeed())
+				Expect(receiveKvs()).To(ConsistOf(matchKv(key+"/child", "child")))
+			})
+		})
+
+		Context("When resuming from a revision", func() {
+			BeforeEach(func() {
+				Expect(s.Put(ctx, key, "missed")).To(Succeed())
+				kv, err := s.Get(ctx, key)
+				Expect(err).NotTo(HaveOccurred())
+
+				opt.Revision = kv.ModRevision
+			})
+
+			It("Emits keys modified since that revision", func() {
+				Expect(receiveKvs()).To(ConsistOf(matchKv(key, "missed")))
+			})
+		})
+	})
+
+	Describe("Grant", func() {
+		var lease store.Lease
+
+		BeforeEach(func() {
+			var err error
+			lease, err = s.Grant(ctx, time.Second) // raised to Consul's minimum of 10s
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.Put(ctx, key, "leased")).To(Succeed())
+		})
+
+		It("Attaches keys to the session", func() {
+			Expect(getPair(key).Session).NotTo(BeEmpty())
+		})
+
+		It("Prevents other sessions from putting the key", func() {
+			other, err := s.Grant(ctx, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			defer other.Revoke(ctx)
+
+			Expect(other.Put(ctx, key, "stolen")).To(MatchError(ContainSubstring("held by another session")))
+		})
+
+		It("Removes keys when revoked", func() {
+			Expect(lease.Revoke(ctx)).To(Succeed())
+			Eventually(func() (*store.KeyValue, error) { return s.Get(ctx, key) }).Should(BeNil())
+		})
+
+		It("Reports the lease lost once the session is gone", func() {
+			lost, err := lease.KeepAlive(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lease.Revoke(ctx)).To(Succeed())
+			Eventually(lost, 10*time.Second).Should(BeClosed())
+		})
+
+		It("Stops renewing once the context is cancelled", func() {
+			keepAliveCtx, cancelKeepAlive := context.WithCancel(ctx)
+			lost, err := lease.KeepAlive(keepAliveCtx)
+			Expect(err).NotTo(HaveOccurred())
+
+			cancelKeepAlive()
+			Eventually(lost).Should(BeClosed())
+			Expect(getPair(key)).NotTo(BeNil(), "the session should outlive its keep alive")
+		})
+
+		// Consul invalidates sessions up to twice their TTL after the last renewal, so we
+		// must wait beyond that to know we're renewing
+		It("Keeps keys beyond the TTL while kept alive", func() {
+			_, err := lease.KeepAlive(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Consistently(func() (*store.KeyValue, error) { return s.Get(ctx, key) }, 25*time.Second, time.Second).
+				ShouldNot(BeNil())
+		})
+	})
+
+	Describe("Locker", func() {
+		It("Grants the lock to one holder at a time", func() {
+			first, second := s.Locker(key), s.Locker(key)
+			Expect(first.Lock(ctx)).To(Succeed())
+
+			acquired := make(chan error)
+			go func() { acquired <- second.Lock(ctx) }()
+			Consistently(acquired, 500*time.Millisecond).ShouldNot(Receive())
+
+			Expect(first.Unlock(ctx)).To(Succeed())
+			Eventually(acquired, 10*time.Second).Should(Receive(BeNil()))
+			Expect(second.Unlock(ctx)).To(Succeed())
+		})
+
+		It("Gives up once the context expires", func() {
+			first := s.Locker(key)
+			Expect(first.Lock(ctx)).To(Succeed())
+			defer first.Unlock(ctx)
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			Expect(s.Locker(key).Lock(timeoutCtx)).NotTo(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", 3*time.Second))
+		})
+	})
+
+	Describe("SentinelLeader", func() {
+		It("Reads the leader from stolon's lock while it is held", func() {
+			Expect(s.SentinelLeader(ctx, key)).To(Equal(""))
+
+			// Stolon's sentinels acquire a single key with a session, as libkv does
+			session, _, err := client.Session().CreateNoChecks(&api.SessionEntry{TTL: "10s"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer client.Session().Destroy(session, nil)
+
+			pair := &api.KVPair{Key: strings.TrimPrefix(key, "/") + "/sentinel-leader", Value: []byte("sentinel-a"), Session: session}
+			acquired, _, err := client.KV().Acquire(pair, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(acquired).To(BeTrue())
+			Expect(s.SentinelLeader(ctx, key)).To(Equal("sentinel-a"))
+
+			released, _, err := client.KV().Release(pair, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(released).To(BeTrue())
+			Expect(s.SentinelLeader(ctx, key)).To(Equal(""), "a released lock has no leader")
+		})
+	})
+})
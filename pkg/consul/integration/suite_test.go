@@ -0,0 +1,29 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var (
+	client  *api.Client
+	cleanup func()
+)
+
+// All tests in this suite require access to a Consul agent. Boot one that we can use for
+// everything, and rely on RandomKey() to generate unique keys.
+var _ = BeforeSuite(func() {
+	client, cleanup = StartConsul()
+})
+
+var _ = AfterSuite(func() {
+	cleanup()
+})
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "pkg/consul/integration")
+}
@@ -0,0 +1,322 @@
+package consul
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// Consul rejects TTLs shorter than this
+const minimumTTL = 10 * time.Second
+
+// Store implements store.Store with a Consul client. Consul keys may not begin with a
+// slash, so we strip any leading slash before querying and restore it on the keys we
+// return. Consul indexes stand in for etcd revisions, and leases are implemented with
+// sessions that delete their keys on expiry.
+type Store struct {
+	logger kitlog.Logger
+	client *api.Client
+	// WatchWaitTime bounds each blocking query made by Watch
+	WatchWaitTime time.Duration
+}
+
+var _ store.Store = &Store{}
+
+// NewStore wraps the client for use as a store.Store
+func NewStore(logger kitlog.Logger, client *api.Client) *Store {
+	return &Store{logger: logger, client: client, WatchWaitTime: time.Minute}
+}
+
+func (s *Store) Get(ctx context.Context, key string) (*store.KeyValue, error) {
+	pair, _, err := s.client.KV().Get(consulKey(key), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil || pair == nil {
+		return nil, err
+	}
+
+	return toKv(pair, strings.HasPrefix(key, "/")), nil
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.KeyValue, error) {
+	pairs, _, err := s.client.KV().List(consulKey(prefix), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	kvs := []*store.KeyValue{}
+	for _, pair := range pairs {
+		kvs = append(kvs, toKv(pair, strings.HasPrefix(prefix, "/")))
+	}
+
+	return kvs, nil
+}
+
+// SentinelLeader reads the lock that stolon's sentinels contend for in Consul, a single
+// key holding the leader's UID. The key outlives the lock, so only a key held by a
+// session identifies a leader.
+func (s *Store) SentinelLeader(ctx context.Context, clusterPath string) (string, error) {
+	pair, _, err := s.client.KV().Get(consulKey(path.Join(clusterPath, "sentinel-leader")), (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	if pair == nil || pair.Session == "" {
+		return "", nil
+	}
+
+	return string(pair.Value), nil
+}
+
+func (s *Store) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.KV().Put(
+		&api.KVPair{Key: consulKey(key), Value: []byte(value)}, (&api.WriteOptions{}).WithContext(ctx),
+	)
+
+	return err
+}
+
+// CompareAndSwap relies on Consul's check-and-set, where an index of zero requires that
+// the key does not exist
+func (s *Store) CompareAndSwap(ctx context.Context, key string, prev *store.KeyValue, value string) error {
+	pair := &api.KVPair{Key: consulKey(key), Value: []byte(value)}
+	if prev != nil {
+		pair.ModifyIndex = uint64(prev.ModRevision)
+	}
+
+	ok, _, err := s.client.KV().CAS(pair, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return store.ErrCompareFailed
+	}
+
+	return nil
+}
+
+// Watch uses blocking queries against the key, diffing each response against the last
+// to find what changed. Deleted keys are emitted with an empty value, using the index at
+// which we observed the deletion. Consul has no history, so resuming from a revision
+// emits the keys modified since, but can't replay intermediate values or deletions.
+func (s *Store) Watch(ctx context.Context, key string, opt store.WatchOptions) <-chan store.WatchResponse {
+	out := make(chan store.WatchResponse)
+
+	go func() {
+		defer close(out)
+
+		var index uint64
+		var primed, slash = false, strings.HasPrefix(key, "/")
+		seen := map[string]uint64{}
+		for {
+			opts := (&api.QueryOptions{WaitIndex: index, WaitTime: s.WatchWaitTime}).WithContext(ctx)
+			pairs, meta, err := s.client.KV().List(consulKey(key), opts)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- store.WatchResponse{Err: err}:
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+
+				continue
+			}
+
+			// The first query establishes what exists, and we only report changes from then
+			resp := store.WatchResponse{}
+			current := map[string]uint64{}
+			for _, pair := range pairs {
+				if !opt.Prefix && pair.Key != consulKey(key) {
+					continue
+				}
+
+				current[pair.Key] = pair.ModifyIndex
+				if primed && seen[pair.Key] != pair.ModifyIndex || !primed && opt.Revision > 0 && pair.ModifyIndex >= uint64(opt.Revision) {
+					resp.Kvs = append(resp.Kvs, toKv(pair, slash))
+				}
+			}
+
+			for key := range seen {
+				if _, ok := current[key]; !ok && primed {
+					resp.Kvs = append(resp.Kvs, &store.KeyValue{Key: []byte(withSlash(key, slash)), ModRevision: int64(meta.LastIndex)})
+				}
+			}
+
+			// Consul may reset its index, such as after a restore from snapshot, in which
+			// case we must query from zero to avoid blocking until the old index is reached
+			if meta.LastIndex < index {
+				s.logger.Log("event", "watch_index_reset", "key", key, "index", index, "last_index", meta.LastIndex)
+				meta.LastIndex = 0
+			}
+
+			index, seen, primed = meta.LastIndex, current, true
+
+			if len(resp.Kvs) > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- resp:
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Grant creates a session without health checks, which Consul removes along with its
+// keys unless renewed within the TTL. Consul requires a TTL of at least ten seconds.
+func (s *Store) Grant(ctx context.Context, ttl time.Duration) (store.Lease, error) {
+	if ttl < minimumTTL {
+		ttl = minimumTTL
+	}
+
+	id, _, err := s.client.Session().CreateNoChecks(
+		&api.SessionEntry{TTL: ttl.String(), Behavior: api.SessionBehaviorDelete},
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session")
+	}
+
+	return &lease{client: s.client, id: id, ttl: ttl}, nil
+}
+
+type lease struct {
+	client *api.Client
+	id     string
+	ttl    time.Duration
+}
+
+func (l *lease) Put(ctx context.Context, key, value string) error {
+	ok, _, err := l.client.KV().Acquire(
+		&api.KVPair{Key: consulKey(key), Value: []byte(value), Session: l.id},
+		(&api.WriteOptions{}).WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.Errorf("key %s is held by another session", key)
+	}
+
+	return nil
+}
+
+// KeepAlive renews the session at a third of its TTL. We avoid the client's
+// RenewPeriodic, which destroys the session when stopped, as etcd leases outlive their
+// keep alive.
+func (l *lease) KeepAlive(ctx context.Context) (<-chan struct{}, error) {
+	lost := make(chan struct{})
+
+	go func() {
+		defer close(lost)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.ttl / 3):
+			}
+
+			entry, _, err := l.client.Session().Renew(l.id, (&api.WriteOptions{}).WithContext(ctx))
+			if err == nil && entry == nil {
+				return // session has expired
+			}
+		}
+	}()
+
+	return lost, nil
+}
+
+func (l *lease) Revoke(ctx context.Context) error {
+	_, err := l.client.Session().Destroy(l.id, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+func (s *Store) Locker(key string) store.Locker {
+	return &locker{client: s.client, key: key}
+}
+
+type locker struct {
+	client *api.Client
+	key    string
+	lock   *api.Lock
+}
+
+func (l *locker) Lock(ctx context.Context) error {
+	// Consul only checks whether to stop between blocking queries, so we bound each
+	// query to respond promptly to our context
+	lock, err := l.client.LockOpts(&api.LockOptions{Key: consulKey(l.key), LockWaitTime: time.Second})
+	if err != nil {
+		return err
+	}
+
+	// Consul's lock is cancelled by a channel rather than a context
+	stop, acquired := make(chan struct{}), make(chan struct{})
+	defer close(acquired)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-acquired:
+		}
+	}()
+
+	held, err := lock.Lock(stop)
+	if err != nil {
+		return err
+	}
+
+	if held == nil {
+		return ctx.Err()
+	}
+
+	l.lock = lock
+	return nil
+}
+
+func (l *locker) Unlock(ctx context.Context) error {
+	if l.lock == nil {
+		return nil
+	}
+
+	return l.lock.Unlock()
+}
+
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// withSlash restores the leading slash we stripped from the key we queried, so that
+// callers see the keys they asked for
+func withSlash(key string, slash bool) string {
+	if slash {
+		return "/" + key
+	}
+
+	return key
+}
+
+func toKv(pair *api.KVPair, slash bool) *store.KeyValue {
+	return &store.KeyValue{
+		Key:            []byte(withSlash(pair.Key, slash)),
+		Value:          pair.Value,
+		CreateRevision: int64(pair.CreateIndex),
+		ModRevision:    int64(pair.ModifyIndex),
+	}
+}
@@ -0,0 +1,57 @@
+package integration
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registration", func() {
+	var (
+		ctx          context.Context
+		cancel       func()
+		key          string
+		registration *store.Registration
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		key = RandomKey()
+		registration = store.NewRegistration(ctx, etcd.NewStore(client), key, 5*time.Second)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	get := func() []byte {
+		resp, err := client.Get(ctx, key)
+		Expect(err).NotTo(HaveOccurred())
+
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+
+		Expect(resp.Kvs[0].Lease).NotTo(BeZero())
+		return resp.Kvs[0].Value
+	}
+
+	It("Publishes the value attached to a lease", func() {
+		Expect(registration.Put(ctx, "initial")).To(Succeed())
+		Expect(get()).To(Equal([]byte("initial")))
+
+		Expect(registration.Put(ctx, "changed")).To(Succeed())
+		Expect(get()).To(Equal([]byte("changed")))
+	})
+
+	It("Removes the key when revoked", func() {
+		Expect(registration.Put(ctx, "initial")).To(Succeed())
+		Expect(registration.Revoke(ctx)).To(Succeed())
+		Expect(get()).To(BeNil())
+	})
+})
@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		ctx    context.Context
+		cancel func()
+		key    string
+		s      *etcd.Store
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
+		key = RandomKey()
+		s = etcd.NewStore(client)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("CompareAndSwap", func() {
+		It("Creates keys only when they don't exist", func() {
+			Expect(s.CompareAndSwap(ctx, key, nil, "initial")).To(Succeed())
+			Expect(s.CompareAndSwap(ctx, key, nil, "initial")).To(Equal(store.ErrCompareFailed))
+		})
+
+		It("Swaps only if the key is unchanged since read", func() {
+			Expect(s.Put(ctx, key, "initial")).To(Succeed())
+			initial, err := s.Get(ctx, key)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(s.CompareAndSwap(ctx, key, initial, "swapped")).To(Succeed())
+			Expect(s.CompareAndSwap(ctx, key, initial, "stale")).To(Equal(store.ErrCompareFailed))
+
+			Expect(s.Get(ctx, key)).To(PointTo(MatchFields(IgnoreExtras, Fields{"Value": Equal([]byte("swapped"))})))
+		})
+	})
+
+	Describe("Grant", func() {
+		var lease store.Lease
+
+		BeforeEach(func() {
+			var err error
+			lease, err = s.Grant(ctx, 2*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lease.Put(ctx, key, "leased")).To(Succeed())
+		})
+
+		It("Attaches keys to the lease", func() {
+			resp, err := client.Get(ctx, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Kvs).To(HaveLen(1))
+			Expect(resp.Kvs[0].Lease).NotTo(BeZero())
+		})
+
+		It("Removes keys once the lease expires", func() {
+			Eventually(func() (*store.KeyValue, error) { return s.Get(ctx, key) }, 5*time.Second).Should(BeNil())
+		})
+
+		It("Keeps keys while kept alive", func() {
+			keepAliveCtx, cancelKeepAlive := context.WithCancel(ctx)
+			defer cancelKeepAlive()
+
+			lost, err := lease.KeepAlive(keepAliveCtx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Consistently(func() (*store.KeyValue, error) { return s.Get(ctx, key) }, 4*time.Second).ShouldNot(BeNil())
+
+			cancelKeepAlive()
+			Eventually(lost).Should(BeClosed())
+		})
+
+		It("Removes keys when revoked", func() {
+			Expect(lease.Revoke(ctx)).To(Succeed())
+			Expect(s.Get(ctx, key)).To(BeNil())
+		})
+	})
+
+	Describe("Locker", func() {
+		It("Grants the lock to one holder at a time", func() {
+			first, second := s.Locker(key), s.Locker(key)
+			Expect(first.Lock(ctx)).To(Succeed())
+
+			acquired := make(chan error)
+			go func() { acquired <- second.Lock(ctx) }()
+			Consistently(acquired, 500*time.Millisecond).ShouldNot(Receive())
+
+			Expect(first.Unlock(ctx)).To(Succeed())
+			Eventually(acquired).Should(Receive(BeNil()))
+			Expect(second.Unlock(ctx)).To(Succeed())
+		})
+
+		It("Gives up once the context expires", func() {
+			first := s.Locker(key)
+			Expect(first.Lock(ctx)).To(Succeed())
+			defer first.Unlock(ctx)
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+
+			Expect(s.Locker(key).Lock(timeoutCtx)).NotTo(Succeed())
+		})
+	})
+
+	Describe("SentinelLeader", func() {
+		It("Returns the earliest sentinel to campaign", func() {
+			Expect(s.SentinelLeader(ctx, key)).To(Equal(""))
+
+			Expect(s.Put(ctx, key+"/sentinel-leader/b", "sentinel-b")).To(Succeed())
+			Expect(s.Put(ctx, key+"/sentinel-leader/a", "sentinel-a")).To(Succeed())
+
+			Expect(s.SentinelLeader(ctx, key)).To(Equal("sentinel-b"))
+		})
+	})
+})
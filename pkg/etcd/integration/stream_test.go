@@ -6,6 +6,7 @@ import (
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 
 	kitlog "github.com/go-kit/kit/log"
 
@@ -32,10 +33,10 @@ var _ = Describe("Stream", func() {
 	})
 
 	createStream := func() <-chan *mvccpb.KeyValue {
-		stream, _ := etcd.NewStream(
+		stream, _ := store.NewStream(
 			kitlog.NewLogfmtLogger(GinkgoWriter),
-			client,
-			etcd.StreamOptions{
+			etcd.NewStore(client),
+			store.StreamOptions{
 				Ctx:          ctx,
 				Keys:         []string{key},
 				PollInterval: time.Second,
@@ -6,6 +6,7 @@ import (
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -13,7 +14,7 @@ import (
 	"github.com/onsi/gomega/types"
 )
 
-var _ = Describe("CompareAndUpdate", func() {
+var _ = Describe("Update", func() {
 	var (
 		ctx    context.Context
 		cancel func()
@@ -29,8 +30,12 @@ var _ = Describe("CompareAndUpdate", func() {
 		cancel()
 	})
 
-	compareAndUpdate := func(value string) error {
-		return etcd.CompareAndUpdate(ctx, client, key, value)
+	update := func(mutate func([]byte) ([]byte, error)) error {
+		return store.Update(ctx, etcd.NewStore(client), key, mutate)
+	}
+
+	setValue := func(value string) func([]byte) ([]byte, error) {
+		return func([]byte) ([]byte, error) { return []byte(value), nil }
 	}
 
 	put := func(value string) {
@@ -54,9 +59,12 @@ var _ = Describe("CompareAndUpdate", func() {
 	}
 
 	Context("When key does not exist", func() {
-		It("Creates key", func() {
-			Expect(compareAndUpdate("initial")).To(Succeed())
-			Expect(get()).To(matchValueRevision("initial", BeNumerically(">", 0)))
+		It("Fails without creating the key", func() {
+			Expect(update(setValue("initial"))).To(MatchError(ContainSubstring("does not exist")))
+
+			resp, err := client.Get(ctx, key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Kvs).To(BeEmpty())
 		})
 	})
 
@@ -72,18 +80,35 @@ var _ = Describe("CompareAndUpdate", func() {
 
 		Context("With same value", func() {
 			It("No-ops update", func() {
-				Expect(compareAndUpdate(string(initial.Value))).To(Succeed())
+				Expect(update(setValue(string(initial.Value)))).To(Succeed())
 				Expect(get()).To(Equal(initial))
 			})
 		})
 
 		Context("With different value", func() {
 			It("Performs update", func() {
-				Expect(compareAndUpdate("changed")).To(Succeed())
+				Expect(update(setValue("changed"))).To(Succeed())
 				Expect(get()).To(matchValueRevision(
 					"changed", BeNumerically(">", initial.ModRevision),
 				))
 			})
 		})
+
+		Context("When the key changes between read and write", func() {
+			It("Retries against the latest value", func() {
+				var attempts int
+				Expect(update(func(value []byte) ([]byte, error) {
+					attempts++
+					if attempts == 1 {
+						put("concurrent")
+					}
+
+					return append(value, []byte("-updated")...), nil
+				})).To(Succeed())
+
+				Expect(attempts).To(Equal(2))
+				Expect(get()).To(matchValueRevision("concurrent-updated", BeNumerically(">", initial.ModRevision)))
+			})
+		})
 	})
 })
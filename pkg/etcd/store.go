@@ -0,0 +1,193 @@
+package etcd
+
+import (
+	"context"
+	"math"
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+)
+
+// Store implements store.Store with an etcd v3 client
+type Store struct {
+	client *clientv3.Client
+}
+
+var _ store.Store = &Store{}
+
+// NewStore wraps the client for use as a store.Store
+func NewStore(client *clientv3.Client) *Store {
+	return &Store{client: client}
+}
+
+func (s *Store) Get(ctx context.Context, key string) (*store.KeyValue, error) {
+	resp, err := s.client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return resp.Kvs[0], nil
+}
+
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.KeyValue, error) {
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Kvs, nil
+}
+
+// SentinelLeader finds the winner of stolon's etcd election, where each sentinel
+// campaigns by creating a key under the sentinel-leader prefix
+func (s *Store) SentinelLeader(ctx context.Context, clusterPath string) (string, error) {
+	return store.ElectionLeader(ctx, s, path.Join(clusterPath, "sentinel-leader")+"/")
+}
+
+func (s *Store) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.Put(ctx, key, value)
+	return err
+}
+
+func (s *Store) CompareAndSwap(ctx context.Context, key string, prev *store.KeyValue, value string) error {
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if prev != nil {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", prev.ModRevision)
+	}
+
+	resp, err := s.client.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, value)).Commit()
+	if err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return store.ErrCompareFailed
+	}
+
+	return nil
+}
+
+// Watch requires an etcd leader, ensuring a partitioned member can't leave us waiting
+// on changes that will never arrive. etcd closes the watch after reporting compaction.
+func (s *Store) Watch(ctx context.Context, key string, opt store.WatchOptions) <-chan store.WatchResponse {
+	out := make(chan store.WatchResponse)
+
+	opts := []clientv3.OpOption{}
+	if opt.Prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	if opt.Revision > 0 {
+		opts = append(opts, clientv3.WithRev(opt.Revision))
+	}
+
+	go func() {
+		defer close(out)
+		for resp := range s.client.Watch(clientv3.WithRequireLeader(ctx), key, opts...) {
+			watchResp := store.WatchResponse{Err: resp.Err()}
+			if resp.CompactRevision > 0 {
+				watchResp.Err, watchResp.CompactRevision = store.ErrCompacted, resp.CompactRevision
+			}
+
+			for _, event := range resp.Events {
+				watchResp.Kvs = append(watchResp.Kvs, event.Kv)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- watchResp:
+			}
+		}
+	}()
+
+	return out
+}
+
+// Grant creates a lease, rounding the TTL up to whole seconds as etcd can't express
+// anything finer
+func (s *Store) Grant(ctx context.Context, ttl time.Duration) (store.Lease, error) {
+	resp, err := s.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease{client: s.client, id: resp.ID}, nil
+}
+
+type lease struct {
+	client *clientv3.Client
+	id     clientv3.LeaseID
+}
+
+func (l *lease) Put(ctx context.Context, key, value string) error {
+	_, err := l.client.Put(ctx, key, value, clientv3.WithLease(l.id))
+	return err
+}
+
+func (l *lease) KeepAlive(ctx context.Context) (<-chan struct{}, error) {
+	keepAlive, err := l.client.KeepAlive(ctx, l.id)
+	if err != nil {
+		return nil, err
+	}
+
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for range keepAlive {
+		}
+	}()
+
+	return lost, nil
+}
+
+func (l *lease) Revoke(ctx context.Context) error {
+	_, err := l.client.Revoke(ctx, l.id)
+	return err
+}
+
+// Locker returns an etcd mutex, which is compatible with other etcd clients using the
+// concurrency package at the same key. Each lock is held with its own session.
+func (s *Store) Locker(key string) store.Locker {
+	return &locker{client: s.client, key: key}
+}
+
+type locker struct {
+	client  *clientv3.Client
+	key     string
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+func (l *locker) Lock(ctx context.Context) error {
+	// The session must outlive the context we lock with, as it keeps our lock alive
+	session, err := concurrency.NewSession(l.client)
+	if err != nil {
+		return err
+	}
+
+	mutex := concurrency.NewMutex(session, l.key)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		return err
+	}
+
+	l.session, l.mutex = session, mutex
+	return nil
+}
+
+func (l *locker) Unlock(ctx context.Context) error {
+	if l.mutex == nil {
+		return nil
+	}
+
+	defer l.session.Close()
+	return l.mutex.Unlock(ctx)
+}
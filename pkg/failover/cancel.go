@@ -0,0 +1,148 @@
+package failover
+
+import (
+	"context"
+	"net"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// CancelMode determines how we signal Postgres backends that are blocking a pause
+type CancelMode string
+
+const (
+	// CancelNone leaves blocking backends alone, allowing the pause to time out
+	CancelNone CancelMode = "none"
+	// CancelQuery cancels the current query with pg_cancel_backend
+	CancelQuery CancelMode = "cancel"
+	// CancelTerminate terminates the backend with pg_terminate_backend, which also ends
+	// transactions left idle
+	CancelTerminate CancelMode = "terminate"
+)
+
+// CancelPolicy decides which backends, if any, we may signal to allow a pause to
+// complete. Backends are matched on the user and application_name reported by
+// pg_stat_activity: empty allow lists match everything, while deny lists always win.
+type CancelPolicy struct {
+	Mode CancelMode
+	// ConnConfig is used to connect to the Postgres that PgBouncer routes to, and must be
+	// a superuser or a member of pg_signal_backend
+	ConnConfig        pgx.ConnConfig
+	AllowUsers        []string
+	DenyUsers         []string
+	AllowApplications []string
+	DenyApplications  []string
+}
+
+// Enabled returns true if the policy permits signalling any backends
+func (p CancelPolicy) Enabled() bool {
+	return p.Mode == CancelQuery || p.Mode == CancelTerminate
+}
+
+// Allows returns true if we may signal a backend for this user and application
+func (p CancelPolicy) Allows(user, applicationName string) bool {
+	if contains(p.DenyUsers, user) || contains(p.DenyApplications, applicationName) {
+		return false
+	}
+
+	return (len(p.AllowUsers) == 0 || contains(p.AllowUsers, user)) &&
+		(len(p.AllowApplications) == 0 || contains(p.AllowApplications, applicationName))
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cancelBlocking signals the Postgres backends behind every active PgBouncer server
+// connection that the policy allows. Backends we're not permitted to signal are left
+// alone, and the pause will continue to wait on them.
+func (p CancelPolicy) cancelBlocking(ctx context.Context, logger kitlog.Logger, bouncer *pgbouncer.PgBouncer) ([]*Cancellation, error) {
+	servers, err := bouncer.ShowServers(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to show servers")
+	}
+
+	pids := []int32{}
+	for _, server := range servers {
+		if server.Database != "pgbouncer" && server.State == "active" && server.RemotePID > 0 {
+			pids = append(pids, int32(server.RemotePID))
+		}
+	}
+
+	if len(pids) == 0 {
+		return nil, nil
+	}
+
+	conn, err := connect(ctx, p.ConnConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	rows, err := conn.QueryEx(
+		ctx, `select pid::bigint, datname, usename, application_name from pg_stat_activity where pid = any($1);`, nil, pids,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query pg_stat_activity")
+	}
+
+	backends := []*Cancellation{}
+	for rows.Next() {
+		backend := &Cancellation{Mode: string(p.Mode)}
+		if err := rows.Scan(&backend.Pid, &backend.Database, &backend.User, &backend.ApplicationName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if p.Allows(backend.User, backend.ApplicationName) {
+			backends = append(backends, backend)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	signal := `select pg_cancel_backend($1);`
+	if p.Mode == CancelTerminate {
+		signal = `select pg_terminate_backend($1);`
+	}
+
+	for _, backend := range backends {
+		if err := conn.QueryRowEx(ctx, signal, nil, int32(backend.Pid)).Scan(&backend.Signalled); err != nil {
+			backend.Error = err.Error()
+		}
+
+		logger.Log("event", "cancel_backend", "mode", p.Mode, "pid", backend.Pid, "database", backend.Database,
+			"user", backend.User, "application_name", backend.ApplicationName, "signalled", backend.Signalled,
+			"error", backend.Error)
+	}
+
+	return backends, nil
+}
+
+// connect opens a connection to Postgres. pgx doesn't accept a context when connecting,
+// so we bound our dial by the context deadline.
+func connect(ctx context.Context, cfg pgx.ConnConfig) (*pgx.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok && cfg.Dial == nil {
+		cfg.Dial = (&net.Dialer{Timeout: time.Until(deadline), KeepAlive: 5 * time.Minute}).Dial
+	}
+
+	conn, err := pgx.Connect(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to Postgres")
+	}
+
+	return conn, nil
+}
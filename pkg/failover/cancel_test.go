@@ -0,0 +1,80 @@
+package failover
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+)
+
+var _ = Describe("CancelPolicy", func() {
+	Describe("Enabled", func() {
+		It("Is disabled by default and for none", func() {
+			Expect(CancelPolicy{}.Enabled()).To(BeFalse())
+			Expect(CancelPolicy{Mode: CancelNone}.Enabled()).To(BeFalse())
+			Expect(CancelPolicy{Mode: CancelQuery}.Enabled()).To(BeTrue())
+			Expect(CancelPolicy{Mode: CancelTerminate}.Enabled()).To(BeTrue())
+		})
+	})
+
+	Describe("Allows", func() {
+		var policy CancelPolicy
+
+		BeforeEach(func() { policy = CancelPolicy{Mode: CancelQuery} })
+
+		It("Allows every backend without lists", func() {
+			Expect(policy.Allows("app", "rails")).To(BeTrue())
+		})
+
+		Context("With allow lists", func() {
+			BeforeEach(func() {
+				policy.AllowUsers = []string{"batch", "reporting"}
+				policy.AllowApplications = []string{"etl"}
+			})
+
+			It("Requires both user and application to be allowed", func() {
+				Expect(policy.Allows("batch", "etl")).To(BeTrue())
+				Expect(policy.Allows("batch", "rails")).To(BeFalse())
+				Expect(policy.Allows("app", "etl")).To(BeFalse())
+			})
+		})
+
+		Context("With deny lists", func() {
+			BeforeEach(func() {
+				policy.AllowUsers = []string{"batch"}
+				policy.DenyApplications = []string{"billing"}
+			})
+
+			It("Denies matching backends even when allowed", func() {
+				Expect(policy.Allows("batch", "etl")).To(BeTrue())
+				Expect(policy.Allows("batch", "billing")).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("CancellationsFromError", func() {
+		It("Recovers the cancellations attached to a failed pause", func() {
+			cancellations := []*Cancellation{
+				{Mode: string(CancelQuery), Pid: 10, User: "batch", Signalled: true},
+				{Mode: string(CancelQuery), Pid: 11, User: "batch", Error: "permission denied"},
+			}
+
+			err := withCancellations(status.New(codes.DeadlineExceeded, "exceeded pause timeout"), cancellations)
+
+			Expect(status.Code(err)).To(Equal(codes.DeadlineExceeded))
+			Expect(CancellationsFromError(err)).To(ConsistOf(
+				PointTo(MatchFields(IgnoreExtras, Fields{"Pid": BeEquivalentTo(10), "Signalled": BeTrue()})),
+				PointTo(MatchFields(IgnoreExtras, Fields{"Pid": BeEquivalentTo(11), "Error": Equal("permission denied")})),
+			))
+		})
+
+		It("Returns nothing for errors without cancellations", func() {
+			Expect(CancellationsFromError(withCancellations(status.New(codes.Unknown, "failed"), nil))).To(BeEmpty())
+			Expect(CancellationsFromError(fmt.Errorf("not a status"))).To(BeEmpty())
+		})
+	})
+})
@@ -4,7 +4,6 @@ package failover
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -14,24 +13,23 @@ import (
 	"google.golang.org/grpc/metadata"
 
 	"github.com/buger/jsonparser"
-	"github.com/gocardless/stolon-pgbouncer/pkg/etcd"
+	"github.com/gocardless/stolon-pgbouncer/pkg/fleet"
 	"github.com/gocardless/stolon-pgbouncer/pkg/stolon"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
 
-	"github.com/coreos/etcd/clientv3"
-	"github.com/coreos/etcd/clientv3/concurrency"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 )
 
 type Failover struct {
 	logger        kitlog.Logger
-	client        *clientv3.Client
+	client        store.Store
 	clients       map[string]FailoverClient
 	stolonctl     stolon.Stolonctl
 	sleepInterval string
 	pausedAt      time.Time
-	locker        locker
+	locker        store.Locker
 	opt           FailoverOptions
 }
 
@@ -44,11 +42,21 @@ type FailoverOptions struct {
 	PauseExpiry        time.Duration
 	ResumeTimeout      time.Duration
 	StolonctlTimeout   time.Duration
-}
-
-type locker interface {
-	Lock(context.Context) error
-	Unlock(context.Context) error
+	// TolerateFailures is the number of standby failures the cluster must be able to
+	// survive before we'll failover
+	TolerateFailures int
+	// CheckPauseSafety refuses to pause when clients of session pools, or transactions
+	// open longer than MaxTransactionAge, would block the pause
+	CheckPauseSafety  bool
+	MaxTransactionAge time.Duration
+	// ProxiesPrefix is where supervise instances publish their fleet.Proxy records
+	ProxiesPrefix string
+	// ProxyConvergenceTimeout bounds how long we wait for proxies to route to the new
+	// master before resuming, where 0 disables the wait
+	ProxyConvergenceTimeout time.Duration
+	// MaxKeeperHeartbeatAge is how long before the last clusterdata update each keeper
+	// must have been seen healthy by the sentinel, where 0 disables the check
+	MaxKeeperHeartbeatAge time.Duration
 }
 
 // NewClientCtx generates a new context that will authenticate against the pauser API
@@ -61,7 +69,7 @@ func NewClientCtx(ctx context.Context, token string, timeout time.Duration) (con
 	return context.WithTimeout(ctx, timeout)
 }
 
-func NewFailover(logger kitlog.Logger, client *clientv3.Client, clients map[string]FailoverClient, stolonctl stolon.Stolonctl, opt FailoverOptions) *Failover {
+func NewFailover(logger kitlog.Logger, client store.Store, clients map[string]FailoverClient, stolonctl stolon.Stolonctl, opt FailoverOptions) *Failover {
 	return &Failover{
 		logger:    logger,
 		client:    client,
@@ -76,11 +84,8 @@ func NewFailover(logger kitlog.Logger, client *clientv3.Client, clients map[stri
 // clusterdata resource. Any application trying to modify clusterdata- such as a config
 // management system applying clusterdata configuration- should acquire this lock before
 // making changes.
-func NewLock(client *clientv3.Client, clusterdataKey string) locker {
-	session, _ := concurrency.NewSession(client)
-	return concurrency.NewMutex(
-		session, fmt.Sprintf("%s/failover", clusterdataKey),
-	)
+func NewLock(client store.Store, clusterdataKey string) store.Locker {
+	return client.Locker(fmt.Sprintf("%s/failover", clusterdataKey))
 }
 
 // Run triggers the failover process. We model this as a Pipeline of steps, where each
@@ -96,8 +101,10 @@ func (f *Failover) Run(ctx context.Context, deferCtx context.Context) error {
 		Step(f.HealthCheckClients),
 		Step(f.AcquireLock).Defer(f.ReleaseLock),
 		Step(f.ShortenSleepInterval).Defer(f.RestoreSleepInterval),
+		Step(f.CheckPauseSafe),
 		Step(f.Pause).Defer(f.Resume),
 		Step(f.Failkeeper),
+		Step(f.WaitForProxies),
 	)(
 		ctx, deferCtx,
 	)
@@ -109,28 +116,25 @@ func (f *Failover) Run(ctx context.Context, deferCtx context.Context) error {
 func (f *Failover) ShortenSleepInterval(ctx context.Context) error {
 	f.logger.Log("event", "cache_original_sleep_interval",
 		"msg", "load original sleep interval for replacement after failover")
-	cd, err := stolon.GetClusterdataBytes(ctx, f.client, f.opt.ClusterdataKey)
-	if err != nil {
-		return err
-	}
 
+	// Sentinels regularly write clusterdata, so we update it with a compare-and-swap to
+	// avoid reverting their changes
 	var interval time.Duration
-	f.sleepInterval, err = jsonparser.GetString(cd, "cluster", "spec", "sleepInterval")
-	if err == nil {
-		interval, err = time.ParseDuration(f.sleepInterval)
-	}
+	err := store.Update(ctx, f.client, f.opt.ClusterdataKey, func(cd []byte) ([]byte, error) {
+		var err error
+		f.sleepInterval, err = jsonparser.GetString(cd, "cluster", "spec", "sleepInterval")
+		if err == nil {
+			interval, err = time.ParseDuration(f.sleepInterval)
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to parse sleepInterval: %v", err)
-	}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sleepInterval: %v", err)
+		}
 
-	f.logger.Log("event", "apply_short_sleep_interval", "interval", "1s", "msg", "apply short sleep interval")
-	cd, err = jsonparser.Set(cd, []byte(`"1s"`), "cluster", "spec", "sleepInterval")
-	if err != nil {
-		return err
-	}
+		f.logger.Log("event", "apply_short_sleep_interval", "interval", "1s", "msg", "apply short sleep interval")
+		return jsonparser.Set(cd, []byte(`"1s"`), "cluster", "spec", "sleepInterval")
+	})
 
-	_, err = f.client.Put(ctx, f.opt.ClusterdataKey, string(cd))
 	if err != nil {
 		return err
 	}
@@ -145,21 +149,12 @@ func (f *Failover) ShortenSleepInterval(ctx context.Context) error {
 // RestoreSleepInterval removes the temporary short sleep interval that we apply for the
 // purpose of fast failover.
 func (f *Failover) RestoreSleepInterval(ctx context.Context) error {
-	cd, err := stolon.GetClusterdataBytes(ctx, f.client, f.opt.ClusterdataKey)
-	if err != nil {
-		return err
-	}
-
-	cd, err = jsonparser.Set(cd, []byte(fmt.Sprintf(`"%s"`, f.sleepInterval)), "cluster", "spec", "sleepInterval")
-	if err != nil {
-		return err
-	}
-
 	f.logger.Log("event", "restore_sleep_interval", "interval", f.sleepInterval,
 		"msg", "restoring original sleep interval now failover is complete")
-	_, err = f.client.Put(ctx, f.opt.ClusterdataKey, string(cd))
 
-	return err
+	return store.Update(ctx, f.client, f.opt.ClusterdataKey, func(cd []byte) ([]byte, error) {
+		return jsonparser.Set(cd, []byte(fmt.Sprintf(`"%s"`, f.sleepInterval)), "cluster", "spec", "sleepInterval")
+	})
 }
 
 func (f *Failover) CheckClusterHealthy(ctx context.Context) error {
@@ -168,7 +163,20 @@ func (f *Failover) CheckClusterHealthy(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	return clusterdata.CheckHealthy(1)
+
+	// DB health is computed by the sentinel leader, so would remain healthy should every
+	// sentinel die. Starting a failover that no sentinel will act on would pause traffic
+	// for the full pause expiry, so we check the components are alive first.
+	liveness, err := stolon.GetLiveness(ctx, f.client, f.opt.ClusterdataKey)
+	if err != nil {
+		return err
+	}
+
+	if err := clusterdata.CheckLiveness(liveness, f.opt.MaxKeeperHeartbeatAge); err != nil {
+		return err
+	}
+
+	return clusterdata.CheckHealthy(f.opt.TolerateFailures)
 }
 
 func (f *Failover) HealthCheckClients(ctx context.Context) error {
@@ -224,13 +232,27 @@ func (f *Failover) Pause(ctx context.Context) error {
 	f.pausedAt = time.Now()
 
 	err := f.EachClient(logger, func(endpoint string, client FailoverClient) error {
-		_, err := client.Pause(
+		resp, err := client.Pause(
 			ctx, &PauseRequest{
 				Timeout: int64(f.opt.PauseTimeout),
 				Expiry:  int64(f.opt.PauseExpiry),
 			},
 		)
 
+		// Pausers may have signalled backends that were blocking the pause, which operators
+		// will want to know about when investigating interrupted queries. Failed pauses
+		// carry these in the error, as the backends were interrupted all the same.
+		cancellations := resp.GetCancellations()
+		if err != nil {
+			cancellations = CancellationsFromError(err)
+		}
+
+		for _, c := range cancellations {
+			logger.Log("endpoint", endpoint, "mode", c.Mode, "pid", c.Pid, "database", c.Database,
+				"user", c.User, "application_name", c.ApplicationName, "signalled", c.Signalled,
+				"error", c.Error, "msg", "pauser signalled backend blocking the pause")
+		}
+
 		return err
 	})
 
@@ -312,27 +334,81 @@ func (f *Failover) Failkeeper(ctx context.Context) error {
 		return errors.Wrap(err, "failed to run stolonctl failkeeper")
 	}
 
+	// Stop watching for recovery once we return, as we may be waiting on stolon proxies
+	recoveredCtx, cancelRecovered := context.WithCancel(ctx)
+	defer cancelRecovered()
+
 	select {
 	case <-time.After(f.opt.PauseExpiry):
 		return fmt.Errorf("timed out waiting for successful recovery")
-	case newMaster := <-f.NotifyRecovered(ctx, f.logger, master):
+	case newMaster := <-f.NotifyRecovered(recoveredCtx, f.logger, *clusterdata):
 		f.logger.Log("msg", "cluster successfully recovered", "master", newMaster)
 	}
 
 	return nil
 }
 
+// WaitForProxies waits for every live proxy to report it routes to the new master, so
+// that queries released by our resume don't follow the old route. Proxies failing to
+// converge within the timeout are logged, but don't fail the failover: we'd rather
+// resume than have the pauser expiry release queries for us.
+func (f *Failover) WaitForProxies(ctx context.Context) error {
+	if f.opt.ProxyConvergenceTimeout == 0 {
+		return nil
+	}
+
+	clusterdata, err := stolon.GetClusterdata(ctx, f.client, f.opt.ClusterdataKey)
+	if err != nil {
+		return err
+	}
+
+	master := clusterdata.Master()
+	logger := kitlog.With(f.logger, "event", "wait_for_proxies", "master", master)
+	logger.Log("msg", "waiting for proxies to route to new master")
+
+	timeout := time.After(f.opt.ProxyConvergenceTimeout)
+	for {
+		var stragglers []fleet.Proxy
+		proxies, err := fleet.List(ctx, f.client, f.opt.ProxiesPrefix)
+		if err == nil {
+			stragglers = fleet.Stragglers(proxies, master.Status.ListenAddress)
+			if len(stragglers) == 0 {
+				logger.Log("proxies", len(proxies), "msg", "all proxies route to new master")
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(250 * time.Millisecond):
+			// check again
+		case <-timeout:
+			if err != nil {
+				logger.Log("error", err, "msg", "failed to list proxies, resuming anyway")
+			}
+
+			for _, straggler := range stragglers {
+				logger.Log("hostname", straggler.Hostname, "host", straggler.Host,
+					"msg", "proxy has not converged on new master, resuming anyway")
+			}
+
+			return nil
+		}
+	}
+}
+
 // NotifyRecovered will return a channel that receives the new master DB only once it is
 // healthy and available for writes. We determine this by checking the new master and all
-// its sync nodes are healthy.
-func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, oldMaster stolon.DB) chan stolon.DB {
+// its sync nodes are healthy, and that every stolon proxy has applied the new master.
+// Changes are detected relative to the clusterdata from before the failover.
+func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, previous stolon.Clusterdata) chan stolon.DB {
+	oldMaster := previous.Master()
 	logger = kitlog.With(logger, "key", f.opt.ClusterdataKey)
 	logger.Log("msg", "waiting for stolon to report master change")
 
-	kvs, _ := etcd.NewStream(
+	kvs, _ := store.NewStream(
 		f.logger,
 		f.client,
-		etcd.StreamOptions{
+		store.StreamOptions{
 			Ctx:          ctx,
 			Keys:         []string{f.opt.ClusterdataKey},
 			PollInterval: 5 * time.Second,
@@ -341,20 +417,30 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 	)
 
 	kvs = streams.RevisionFilter(f.logger, kvs)
+	updates := stolon.ParseClusterdata(ctx, logger, kvs)
+
+	// We wait for the master to change, but once it has may still need to wait for it, or
+	// its sync standbys, to become healthy. Each of these changes can make the cluster
+	// available, so we re-check on any of them.
+	watcher := stolon.NewClusterdataWatcher(logger, &previous)
+	events := watcher.Subscribe(
+		stolon.EventMasterChanged, stolon.EventDBHealthChanged, stolon.EventSyncStandbysChanged, stolon.EventSpecChanged,
+	)
+
+	go watcher.Run(ctx, updates)
 
 	notify := make(chan stolon.DB)
 	go func() {
-		for kv := range kvs {
-			if string(kv.Key) != f.opt.ClusterdataKey {
-				continue
-			}
-
-			var clusterdata = &stolon.Clusterdata{}
-			if err := json.Unmarshal(kv.Value, clusterdata); err != nil {
-				logger.Log("error", err, "msg", "failed to parse clusterdata update")
+		var checkedRevision int64
+		for event := range events {
+			// A single clusterdata change can produce several events, but we need only
+			// check each clusterdata once
+			if event.Revision == checkedRevision {
 				continue
 			}
 
+			checkedRevision = event.Revision
+			clusterdata := event.Clusterdata
 			master := clusterdata.Master()
 			if master.Spec.KeeperUID == oldMaster.Spec.KeeperUID {
 				logger.Log("event", "pending_failover", "master", master, "msg", "master has not changed nodes")
@@ -387,6 +473,13 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 				continue
 			}
 
+			// Stolon proxies fence connections until they've applied the proxy generation
+			// that names our new master. Resuming before then causes a burst of connection
+			// errors, so we wait for every live proxy to catch up.
+			if err := f.waitForStolonProxies(ctx, logger, *clusterdata); err != nil {
+				break
+			}
+
 			logger.Log("master", master, "msg", "master is available for writes")
 			notify <- master
 
@@ -398,3 +491,31 @@ func (f *Failover) NotifyRecovered(ctx context.Context, logger kitlog.Logger, ol
 
 	return notify
 }
+
+// waitForStolonProxies blocks until every live stolon proxy has applied the proxy
+// generation of the given clusterdata, or our context expires.
+func (f *Failover) waitForStolonProxies(ctx context.Context, logger kitlog.Logger, clusterdata stolon.Clusterdata) error {
+	for {
+		infos, err := stolon.GetProxiesInfo(ctx, f.client, f.opt.ClusterdataKey)
+		if err != nil {
+			logger.Log("error", err, "msg", "failed to get stolon proxy info")
+		} else {
+			lagging := clusterdata.LaggingProxies(infos)
+			if len(lagging) == 0 {
+				return nil
+			}
+
+			for _, info := range lagging {
+				logger.Log("event", "stolon_proxy_pending", "proxy", info.UID, "generation", info.Generation,
+					"expected", clusterdata.Proxy.Generation, "msg", "stolon proxy has not applied new master")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+			// check again
+		}
+	}
+}
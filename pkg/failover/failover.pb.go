@@ -238,6 +238,7 @@ func (m *PauseRequest) GetExpiry() int64 {
 type PauseResponse struct {
 	CreatedAt            *timestamp.Timestamp `protobuf:"bytes,1,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
 	ExpiresAt            *timestamp.Timestamp `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Cancellations        []*Cancellation      `protobuf:"bytes,3,rep,name=cancellations,proto3" json:"cancellations,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
 	XXX_unrecognized     []byte               `json:"-"`
 	XXX_sizecache        int32                `json:"-"`
@@ -282,6 +283,101 @@ func (m *PauseResponse) GetExpiresAt() *timestamp.Timestamp {
 	return nil
 }
 
+func (m *PauseResponse) GetCancellations() []*Cancellation {
+	if m != nil {
+		return m.Cancellations
+	}
+	return nil
+}
+
+// Cancellation records a Postgres backend we signalled because it was blocking a pause
+type Cancellation struct {
+	Pid                  int64    `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
+	Database             string   `protobuf:"bytes,2,opt,name=database,proto3" json:"database,omitempty"`
+	User                 string   `protobuf:"bytes,3,opt,name=user,proto3" json:"user,omitempty"`
+	ApplicationName      string   `protobuf:"bytes,4,opt,name=application_name,json=applicationName,proto3" json:"application_name,omitempty"`
+	Mode                 string   `protobuf:"bytes,5,opt,name=mode,proto3" json:"mode,omitempty"`
+	Signalled            bool     `protobuf:"varint,6,opt,name=signalled,proto3" json:"signalled,omitempty"`
+	Error                string   `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Cancellation) Reset()         { *m = Cancellation{} }
+func (m *Cancellation) String() string { return proto.CompactTextString(m) }
+func (*Cancellation) ProtoMessage()    {}
+func (*Cancellation) Descriptor() ([]byte, []int) {
+	return fileDescriptor_da12a31637dd43b4, []int{4}
+}
+
+func (m *Cancellation) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Cancellation.Unmarshal(m, b)
+}
+func (m *Cancellation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Cancellation.Marshal(b, m, deterministic)
+}
+func (m *Cancellation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Cancellation.Merge(m, src)
+}
+func (m *Cancellation) XXX_Size() int {
+	return xxx_messageInfo_Cancellation.Size(m)
+}
+func (m *Cancellation) XXX_DiscardUnknown() {
+	xxx_messageInfo_Cancellation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Cancellation proto.InternalMessageInfo
+
+func (m *Cancellation) GetPid() int64 {
+	if m != nil {
+		return m.Pid
+	}
+	return 0
+}
+
+func (m *Cancellation) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *Cancellation) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *Cancellation) GetApplicationName() string {
+	if m != nil {
+		return m.ApplicationName
+	}
+	return ""
+}
+
+func (m *Cancellation) GetMode() string {
+	if m != nil {
+		return m.Mode
+	}
+	return ""
+}
+
+func (m *Cancellation) GetSignalled() bool {
+	if m != nil {
+		return m.Signalled
+	}
+	return false
+}
+
+func (m *Cancellation) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
 type ResumeResponse struct {
 	CreatedAt            *timestamp.Timestamp `protobuf:"bytes,1,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
@@ -293,7 +389,7 @@ func (m *ResumeResponse) Reset()         { *m = ResumeResponse{} }
 func (m *ResumeResponse) String() string { return proto.CompactTextString(m) }
 func (*ResumeResponse) ProtoMessage()    {}
 func (*ResumeResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_da12a31637dd43b4, []int{4}
+	return fileDescriptor_da12a31637dd43b4, []int{5}
 }
 
 func (m *ResumeResponse) XXX_Unmarshal(b []byte) error {
@@ -321,6 +417,213 @@ func (m *ResumeResponse) GetCreatedAt() *timestamp.Timestamp {
 	return nil
 }
 
+type PoolsResponse struct {
+	Pools                []*PoolsResponse_Pool   `protobuf:"bytes,1,rep,name=pools,proto3" json:"pools,omitempty"`
+	Clients              []*PoolsResponse_Client `protobuf:"bytes,2,rep,name=clients,proto3" json:"clients,omitempty"`
+	CreatedAt            *timestamp.Timestamp    `protobuf:"bytes,3,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
+	XXX_unrecognized     []byte                  `json:"-"`
+	XXX_sizecache        int32                   `json:"-"`
+}
+
+func (m *PoolsResponse) Reset()         { *m = PoolsResponse{} }
+func (m *PoolsResponse) String() string { return proto.CompactTextString(m) }
+func (*PoolsResponse) ProtoMessage()    {}
+func (*PoolsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_da12a31637dd43b4, []int{6}
+}
+
+func (m *PoolsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PoolsResponse.Unmarshal(m, b)
+}
+func (m *PoolsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PoolsResponse.Marshal(b, m, deterministic)
+}
+func (m *PoolsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PoolsResponse.Merge(m, src)
+}
+func (m *PoolsResponse) XXX_Size() int {
+	return xxx_messageInfo_PoolsResponse.Size(m)
+}
+func (m *PoolsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PoolsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PoolsResponse proto.InternalMessageInfo
+
+func (m *PoolsResponse) GetPools() []*PoolsResponse_Pool {
+	if m != nil {
+		return m.Pools
+	}
+	return nil
+}
+
+func (m *PoolsResponse) GetClients() []*PoolsResponse_Client {
+	if m != nil {
+		return m.Clients
+	}
+	return nil
+}
+
+func (m *PoolsResponse) GetCreatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+type PoolsResponse_Pool struct {
+	Database             string   `protobuf:"bytes,1,opt,name=database,proto3" json:"database,omitempty"`
+	User                 string   `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	PoolMode             string   `protobuf:"bytes,3,opt,name=pool_mode,json=poolMode,proto3" json:"pool_mode,omitempty"`
+	ActiveClients        int64    `protobuf:"varint,4,opt,name=active_clients,json=activeClients,proto3" json:"active_clients,omitempty"`
+	WaitingClients       int64    `protobuf:"varint,5,opt,name=waiting_clients,json=waitingClients,proto3" json:"waiting_clients,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PoolsResponse_Pool) Reset()         { *m = PoolsResponse_Pool{} }
+func (m *PoolsResponse_Pool) String() string { return proto.CompactTextString(m) }
+func (*PoolsResponse_Pool) ProtoMessage()    {}
+func (*PoolsResponse_Pool) Descriptor() ([]byte, []int) {
+	return fileDescriptor_da12a31637dd43b4, []int{6, 0}
+}
+
+func (m *PoolsResponse_Pool) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PoolsResponse_Pool.Unmarshal(m, b)
+}
+func (m *PoolsResponse_Pool) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PoolsResponse_Pool.Marshal(b, m, deterministic)
+}
+func (m *PoolsResponse_Pool) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PoolsResponse_Pool.Merge(m, src)
+}
+func (m *PoolsResponse_Pool) XXX_Size() int {
+	return xxx_messageInfo_PoolsResponse_Pool.Size(m)
+}
+func (m *PoolsResponse_Pool) XXX_DiscardUnknown() {
+	xxx_messageInfo_PoolsResponse_Pool.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PoolsResponse_Pool proto.InternalMessageInfo
+
+func (m *PoolsResponse_Pool) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Pool) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Pool) GetPoolMode() string {
+	if m != nil {
+		return m.PoolMode
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Pool) GetActiveClients() int64 {
+	if m != nil {
+		return m.ActiveClients
+	}
+	return 0
+}
+
+func (m *PoolsResponse_Pool) GetWaitingClients() int64 {
+	if m != nil {
+		return m.WaitingClients
+	}
+	return 0
+}
+
+type PoolsResponse_Client struct {
+	Database    string               `protobuf:"bytes,1,opt,name=database,proto3" json:"database,omitempty"`
+	User        string               `protobuf:"bytes,2,opt,name=user,proto3" json:"user,omitempty"`
+	State       string               `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Linked      bool                 `protobuf:"varint,4,opt,name=linked,proto3" json:"linked,omitempty"`
+	RequestTime *timestamp.Timestamp `protobuf:"bytes,5,opt,name=request_time,json=requestTime,proto3" json:"request_time,omitempty"`
+	// Start of the client's open transaction, from pg_stat_activity of the linked server.
+	// Only reported when the pauser can connect to Postgres.
+	XactStart            *timestamp.Timestamp `protobuf:"bytes,6,opt,name=xact_start,json=xactStart,proto3" json:"xact_start,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *PoolsResponse_Client) Reset()         { *m = PoolsResponse_Client{} }
+func (m *PoolsResponse_Client) String() string { return proto.CompactTextString(m) }
+func (*PoolsResponse_Client) ProtoMessage()    {}
+func (*PoolsResponse_Client) Descriptor() ([]byte, []int) {
+	return fileDescriptor_da12a31637dd43b4, []int{6, 1}
+}
+
+func (m *PoolsResponse_Client) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PoolsResponse_Client.Unmarshal(m, b)
+}
+func (m *PoolsResponse_Client) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PoolsResponse_Client.Marshal(b, m, deterministic)
+}
+func (m *PoolsResponse_Client) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PoolsResponse_Client.Merge(m, src)
+}
+func (m *PoolsResponse_Client) XXX_Size() int {
+	return xxx_messageInfo_PoolsResponse_Client.Size(m)
+}
+func (m *PoolsResponse_Client) XXX_DiscardUnknown() {
+	xxx_messageInfo_PoolsResponse_Client.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PoolsResponse_Client proto.InternalMessageInfo
+
+func (m *PoolsResponse_Client) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Client) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Client) GetState() string {
+	if m != nil {
+		return m.State
+	}
+	return ""
+}
+
+func (m *PoolsResponse_Client) GetLinked() bool {
+	if m != nil {
+		return m.Linked
+	}
+	return false
+}
+
+func (m *PoolsResponse_Client) GetRequestTime() *timestamp.Timestamp {
+	if m != nil {
+		return m.RequestTime
+	}
+	return nil
+}
+
+func (m *PoolsResponse_Client) GetXactStart() *timestamp.Timestamp {
+	if m != nil {
+		return m.XactStart
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("failover.HealthCheckResponse_Status", HealthCheckResponse_Status_name, HealthCheckResponse_Status_value)
 	proto.RegisterType((*Empty)(nil), "failover.Empty")
@@ -328,40 +631,63 @@ func init() {
 	proto.RegisterType((*HealthCheckResponse_ComponentHealthCheck)(nil), "failover.HealthCheckResponse.ComponentHealthCheck")
 	proto.RegisterType((*PauseRequest)(nil), "failover.PauseRequest")
 	proto.RegisterType((*PauseResponse)(nil), "failover.PauseResponse")
+	proto.RegisterType((*Cancellation)(nil), "failover.Cancellation")
 	proto.RegisterType((*ResumeResponse)(nil), "failover.ResumeResponse")
+	proto.RegisterType((*PoolsResponse)(nil), "failover.PoolsResponse")
+	proto.RegisterType((*PoolsResponse_Pool)(nil), "failover.PoolsResponse.Pool")
+	proto.RegisterType((*PoolsResponse_Client)(nil), "failover.PoolsResponse.Client")
 }
 
 func init() { proto.RegisterFile("failover.proto", fileDescriptor_da12a31637dd43b4) }
 
 var fileDescriptor_da12a31637dd43b4 = []byte{
-	// 420 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x92, 0xcf, 0x8e, 0xd3, 0x30,
-	0x10, 0xc6, 0x9b, 0x84, 0xa6, 0xdb, 0xe9, 0x6e, 0x59, 0x99, 0xd5, 0x12, 0x45, 0x42, 0x54, 0x16,
-	0x87, 0x9e, 0xb2, 0x22, 0x88, 0x03, 0x88, 0xc3, 0x56, 0xab, 0xa2, 0x4a, 0x45, 0x01, 0x99, 0x56,
-	0x88, 0x53, 0xe5, 0x86, 0xe9, 0x1f, 0xd1, 0xd4, 0xc1, 0x76, 0x10, 0xbd, 0x70, 0xe3, 0xb9, 0x78,
-	0x0f, 0x9e, 0x06, 0xc5, 0x49, 0xda, 0xa2, 0x56, 0xe5, 0x00, 0xb7, 0x7c, 0x93, 0xef, 0x1b, 0xcf,
-	0xf8, 0x67, 0x68, 0xcf, 0xf8, 0x72, 0x25, 0xbe, 0xa2, 0x0c, 0x52, 0x29, 0xb4, 0x20, 0x67, 0x95,
-	0xf6, 0x1f, 0xcf, 0x85, 0x98, 0xaf, 0xf0, 0xc6, 0xd4, 0xa7, 0xd9, 0xec, 0x46, 0x2f, 0x13, 0x54,
-	0x9a, 0x27, 0x69, 0x61, 0xa5, 0x0d, 0xa8, 0xf7, 0x93, 0x54, 0x6f, 0xe8, 0x2f, 0x1b, 0x1e, 0x0c,
-	0x90, 0xaf, 0xf4, 0xe2, 0x6e, 0x81, 0xf1, 0x67, 0x86, 0x2a, 0x15, 0x6b, 0x85, 0xe4, 0x15, 0xb8,
-	0x4a, 0x73, 0x9d, 0x29, 0xcf, 0xea, 0x58, 0xdd, 0x76, 0xf8, 0x24, 0xd8, 0x1e, 0x76, 0xc4, 0x1e,
-	0xbc, 0x37, 0x5e, 0x56, 0x66, 0x08, 0x03, 0x88, 0x45, 0x92, 0x8a, 0x35, 0xae, 0xb5, 0xf2, 0xec,
-	0x8e, 0xd3, 0x6d, 0x85, 0xe1, 0xe9, 0x0e, 0x77, 0x95, 0x7f, 0xff, 0xe7, 0x5e, 0x17, 0xff, 0x3b,
-	0x5c, 0x1d, 0xf3, 0xfc, 0xe3, 0xa4, 0x04, 0xee, 0x45, 0x3c, 0x41, 0xcf, 0xee, 0x58, 0xdd, 0x26,
-	0x33, 0xdf, 0xe4, 0x0a, 0xea, 0x7d, 0x29, 0x85, 0xf4, 0x1c, 0x53, 0x2c, 0x04, 0x7d, 0x0a, 0x6e,
-	0x91, 0x25, 0x2d, 0x68, 0x8c, 0xa3, 0x61, 0xf4, 0xf6, 0x43, 0x74, 0x59, 0xcb, 0xc5, 0xa0, 0xdf,
-	0x7b, 0x33, 0x1a, 0x7c, 0xbc, 0xb4, 0xc8, 0x05, 0x34, 0xc7, 0x51, 0x25, 0x6d, 0x7a, 0x0b, 0xe7,
-	0xef, 0x78, 0xa6, 0x90, 0xe1, 0x97, 0x0c, 0x95, 0x26, 0x1e, 0x34, 0x72, 0x10, 0x22, 0xd3, 0x66,
-	0x56, 0x87, 0x55, 0x92, 0x5c, 0x83, 0x8b, 0xdf, 0xd2, 0xa5, 0xdc, 0x98, 0x41, 0x1c, 0x56, 0x2a,
-	0xfa, 0xc3, 0x82, 0x8b, 0xb2, 0x45, 0x09, 0xe6, 0x05, 0x40, 0x2c, 0x91, 0x6b, 0xfc, 0x34, 0xe1,
-	0x45, 0x9b, 0x56, 0xe8, 0x07, 0x05, 0xef, 0xa0, 0xe2, 0x1d, 0x8c, 0x2a, 0xde, 0xac, 0x59, 0xba,
-	0x7b, 0x3a, 0x8f, 0x9a, 0xb6, 0xa8, 0xf2, 0xa8, 0xfd, 0xf7, 0x68, 0xe9, 0xee, 0x69, 0x3a, 0x84,
-	0x36, 0x43, 0x95, 0x25, 0xff, 0x63, 0x8e, 0xf0, 0xa7, 0x05, 0x67, 0xaf, 0x4b, 0x46, 0xe4, 0x16,
-	0xce, 0x17, 0x06, 0xd3, 0x24, 0x36, 0x38, 0xef, 0xef, 0xf0, 0x99, 0x17, 0xea, 0x3f, 0x3a, 0xc9,
-	0x93, 0xd6, 0xc8, 0x4b, 0xa8, 0xa7, 0xf9, 0x15, 0x91, 0xeb, 0x9d, 0x73, 0xff, 0xda, 0xfd, 0x87,
-	0x07, 0xf5, 0x6d, 0xf6, 0x39, 0xb8, 0xd2, 0xec, 0x75, 0x78, 0xae, 0xb7, 0x2b, 0xfc, 0xb9, 0x3a,
-	0xad, 0x4d, 0x5d, 0xb3, 0xe0, 0xb3, 0xdf, 0x01, 0x00, 0x00, 0xff, 0xff, 0x1e, 0x28, 0x0c, 0x60,
-	0x82, 0x03, 0x00, 0x00,
+	// 730 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0xdd, 0x6e, 0xd3, 0x4a,
+	0x10, 0x8e, 0x93, 0xd8, 0x49, 0x26, 0x3f, 0x8d, 0xf6, 0x54, 0xad, 0xe5, 0xd3, 0x73, 0x88, 0x2c,
+	0x10, 0xe1, 0x26, 0x15, 0xa9, 0x90, 0x00, 0x15, 0xa9, 0x55, 0x54, 0x54, 0xa9, 0x10, 0xaa, 0x6d,
+	0x2b, 0xc4, 0x55, 0xb4, 0x75, 0xb6, 0xa9, 0x55, 0xc7, 0x6b, 0xbc, 0xeb, 0xd2, 0xde, 0xf0, 0x32,
+	0x3c, 0x09, 0xe2, 0x2d, 0xb8, 0xe2, 0x21, 0x78, 0x00, 0xb4, 0xeb, 0x75, 0xe2, 0xd0, 0x3f, 0x55,
+	0x70, 0xb7, 0xdf, 0xb7, 0xdf, 0x8c, 0xc7, 0xfb, 0xcd, 0x0c, 0xb4, 0x4e, 0x88, 0x1f, 0xb0, 0x73,
+	0x1a, 0xf7, 0xa2, 0x98, 0x09, 0x86, 0xaa, 0x19, 0x76, 0x1e, 0x4c, 0x18, 0x9b, 0x04, 0x74, 0x5d,
+	0xf1, 0xc7, 0xc9, 0xc9, 0xba, 0xf0, 0xa7, 0x94, 0x0b, 0x32, 0x8d, 0x52, 0xa9, 0x5b, 0x01, 0x73,
+	0x67, 0x1a, 0x89, 0x4b, 0xf7, 0x7b, 0x11, 0xfe, 0xd9, 0xa5, 0x24, 0x10, 0xa7, 0x83, 0x53, 0xea,
+	0x9d, 0x61, 0xca, 0x23, 0x16, 0x72, 0x8a, 0x36, 0xc1, 0xe2, 0x82, 0x88, 0x84, 0xdb, 0x46, 0xc7,
+	0xe8, 0xb6, 0xfa, 0x0f, 0x7b, 0xb3, 0x8f, 0x5d, 0x23, 0xef, 0x1d, 0x28, 0x2d, 0xd6, 0x31, 0x08,
+	0x03, 0x78, 0x6c, 0x1a, 0xb1, 0x90, 0x86, 0x82, 0xdb, 0xc5, 0x4e, 0xa9, 0x5b, 0xef, 0xf7, 0x6f,
+	0xcf, 0x30, 0xc8, 0xf4, 0xf9, 0xcb, 0x5c, 0x16, 0xe7, 0x33, 0x2c, 0x5f, 0xa7, 0xf9, 0xc3, 0x4a,
+	0x11, 0x94, 0x87, 0x64, 0x4a, 0xed, 0x62, 0xc7, 0xe8, 0xd6, 0xb0, 0x3a, 0xa3, 0x65, 0x30, 0x77,
+	0xe2, 0x98, 0xc5, 0x76, 0x49, 0x91, 0x29, 0x70, 0x9f, 0x82, 0x95, 0xc6, 0xa2, 0x3a, 0x54, 0x8e,
+	0x86, 0x7b, 0xc3, 0x77, 0xef, 0x87, 0xed, 0x82, 0x04, 0xbb, 0x3b, 0xdb, 0x6f, 0x0e, 0x77, 0x3f,
+	0xb4, 0x0d, 0xd4, 0x84, 0xda, 0xd1, 0x30, 0x83, 0x45, 0x77, 0x0b, 0x1a, 0xfb, 0x24, 0xe1, 0x14,
+	0xd3, 0x8f, 0x09, 0xe5, 0x02, 0xd9, 0x50, 0x91, 0x46, 0xb0, 0x44, 0xa8, 0x5a, 0x4b, 0x38, 0x83,
+	0x68, 0x05, 0x2c, 0x7a, 0x11, 0xf9, 0xf1, 0xa5, 0x2a, 0xa4, 0x84, 0x35, 0x72, 0xbf, 0x19, 0xd0,
+	0xd4, 0x29, 0xb4, 0x31, 0x2f, 0x00, 0xbc, 0x98, 0x12, 0x41, 0xc7, 0x23, 0x92, 0xa6, 0xa9, 0xf7,
+	0x9d, 0x5e, 0xea, 0x77, 0x2f, 0xf3, 0xbb, 0x77, 0x98, 0xf9, 0x8d, 0x6b, 0x5a, 0xbd, 0x2d, 0x64,
+	0xa8, 0x4a, 0x4b, 0xb9, 0x0c, 0x2d, 0xde, 0x1d, 0xaa, 0xd5, 0xdb, 0x02, 0x6d, 0x42, 0xd3, 0x23,
+	0xa1, 0x47, 0x83, 0x80, 0x08, 0x9f, 0x85, 0xdc, 0x2e, 0x29, 0x4f, 0x57, 0xe6, 0x6f, 0x3d, 0xc8,
+	0x5d, 0xe3, 0x45, 0xb1, 0xfc, 0x8b, 0x46, 0xfe, 0x1e, 0xb5, 0xa1, 0x14, 0xf9, 0x63, 0xfd, 0x08,
+	0xf2, 0x88, 0x1c, 0xa8, 0x8e, 0x89, 0x20, 0xc7, 0x84, 0x67, 0x5e, 0xcc, 0xb0, 0xf4, 0x28, 0xe1,
+	0x34, 0xb3, 0x43, 0x9d, 0xd1, 0x13, 0x68, 0x93, 0x28, 0x0a, 0x7c, 0x4f, 0x25, 0x1c, 0x85, 0xd2,
+	0xc3, 0xb2, 0xba, 0x5f, 0xca, 0xf1, 0xca, 0x4e, 0x04, 0xe5, 0x29, 0x1b, 0x53, 0xdb, 0x4c, 0xc3,
+	0xe5, 0x19, 0xad, 0x41, 0x8d, 0xfb, 0x93, 0x90, 0x04, 0x01, 0x1d, 0xdb, 0x56, 0xc7, 0xe8, 0x56,
+	0xf1, 0x9c, 0x90, 0x0d, 0x40, 0x55, 0x03, 0x54, 0xd2, 0x06, 0x50, 0xc0, 0xdd, 0x83, 0x16, 0xa6,
+	0x3c, 0x99, 0xfe, 0x0d, 0x2f, 0xdc, 0xaf, 0x65, 0x68, 0xee, 0x33, 0x16, 0xf0, 0x59, 0xb2, 0x3e,
+	0x98, 0x91, 0x24, 0x6c, 0x43, 0x3d, 0xed, 0xda, 0xfc, 0x69, 0x17, 0x74, 0x0a, 0xe1, 0x54, 0x8a,
+	0x9e, 0x43, 0xc5, 0x0b, 0xfc, 0xdc, 0x90, 0xfd, 0x7f, 0x53, 0xd4, 0x40, 0xc9, 0x70, 0x26, 0xff,
+	0xad, 0xf4, 0xd2, 0x3d, 0x4a, 0x77, 0xbe, 0x18, 0x50, 0x96, 0xc9, 0x17, 0x3c, 0x33, 0x6e, 0xf0,
+	0xac, 0x98, 0xf3, 0xec, 0x5f, 0xa8, 0xc9, 0xb2, 0x47, 0xca, 0x8d, 0xd4, 0xcc, 0xaa, 0x24, 0xde,
+	0x4a, 0x47, 0x1e, 0x41, 0x8b, 0x78, 0xc2, 0x3f, 0xa7, 0xa3, 0xec, 0x8f, 0xca, 0xaa, 0x3b, 0x9a,
+	0x29, 0x3b, 0xd0, 0x75, 0x3f, 0x86, 0xa5, 0x4f, 0xc4, 0x17, 0x7e, 0x38, 0x99, 0xe9, 0x4c, 0xa5,
+	0x6b, 0x69, 0x5a, 0x0b, 0x9d, 0x1f, 0x06, 0x58, 0xe9, 0xf9, 0xde, 0x75, 0x2e, 0x83, 0xc9, 0x05,
+	0x11, 0x59, 0x8d, 0x29, 0x90, 0x23, 0x1a, 0xf8, 0xe1, 0x19, 0x1d, 0xab, 0xc2, 0xaa, 0x58, 0x23,
+	0xf4, 0x0a, 0x1a, 0x71, 0x3a, 0xdf, 0x23, 0x39, 0xcd, 0xb6, 0x79, 0xe7, 0x5b, 0xd6, 0xb5, 0x5e,
+	0x32, 0xd2, 0x88, 0x0b, 0xe2, 0x89, 0x11, 0x17, 0x24, 0x16, 0xb6, 0x75, 0x67, 0x70, 0x4d, 0xaa,
+	0x0f, 0xa4, 0xb8, 0xff, 0xd3, 0x80, 0xea, 0x6b, 0x6d, 0x37, 0xda, 0x82, 0xc6, 0xa9, 0x5a, 0x77,
+	0x23, 0x4f, 0xad, 0xc5, 0xa5, 0x79, 0x27, 0xa8, 0x4d, 0xef, 0xfc, 0x77, 0xeb, 0x5e, 0x74, 0x0b,
+	0xe8, 0x25, 0x98, 0x91, 0x5c, 0x35, 0x28, 0x37, 0xd5, 0xf9, 0xf5, 0xe5, 0xac, 0x5e, 0xe1, 0x67,
+	0xb1, 0xcf, 0xc0, 0x8a, 0xd5, 0x6c, 0x5c, 0xfd, 0xae, 0x3d, 0x27, 0x16, 0xc7, 0xc7, 0x2d, 0xa0,
+	0x0d, 0xdd, 0xf3, 0x57, 0xa3, 0x56, 0x6f, 0x68, 0x64, 0xb7, 0x70, 0x6c, 0xa9, 0x57, 0xd9, 0xf8,
+	0x35, 0x00, 0xfc, 0xf3, 0x2b, 0xf2, 0xff, 0x06, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -379,6 +705,7 @@ type FailoverClient interface {
 	HealthCheck(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*HealthCheckResponse, error)
 	Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error)
 	Resume(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ResumeResponse, error)
+	Pools(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PoolsResponse, error)
 }
 
 type failoverClient struct {
@@ -416,11 +743,21 @@ func (c *failoverClient) Resume(ctx context.Context, in *Empty, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *failoverClient) Pools(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*PoolsResponse, error) {
+	out := new(PoolsResponse)
+	err := c.cc.Invoke(ctx, "/failover.Failover/pools", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FailoverServer is the server API for Failover service.
 type FailoverServer interface {
 	HealthCheck(context.Context, *Empty) (*HealthCheckResponse, error)
 	Pause(context.Context, *PauseRequest) (*PauseResponse, error)
 	Resume(context.Context, *Empty) (*ResumeResponse, error)
+	Pools(context.Context, *Empty) (*PoolsResponse, error)
 }
 
 // UnimplementedFailoverServer can be embedded to have forward compatible implementations.
@@ -436,6 +773,9 @@ func (*UnimplementedFailoverServer) Pause(ctx context.Context, req *PauseRequest
 func (*UnimplementedFailoverServer) Resume(ctx context.Context, req *Empty) (*ResumeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
 }
+func (*UnimplementedFailoverServer) Pools(ctx context.Context, req *Empty) (*PoolsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pools not implemented")
+}
 
 func RegisterFailoverServer(s *grpc.Server, srv FailoverServer) {
 	s.RegisterService(&_Failover_serviceDesc, srv)
@@ -495,6 +835,24 @@ func _Failover_Resume_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Failover_Pools_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FailoverServer).Pools(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/failover.Failover/Pools",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FailoverServer).Pools(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Failover_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "failover.Failover",
 	HandlerType: (*FailoverServer)(nil),
@@ -511,6 +869,10 @@ var _Failover_serviceDesc = grpc.ServiceDesc{
 			MethodName: "resume",
 			Handler:    _Failover_Resume_Handler,
 		},
+		{
+			MethodName: "pools",
+			Handler:    _Failover_Pools_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "failover.proto",
@@ -0,0 +1,90 @@
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/stolon"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Failover", func() {
+	Describe("NotifyRecovered", func() {
+		var (
+			ctx      context.Context
+			cancel   func()
+			client   *store.Memory
+			notify   chan stolon.DB
+			failover *Failover
+			initial  stolon.Clusterdata
+		)
+
+		const clusterdataKey = "/stolon/cluster/main/clusterdata"
+
+		// putClusterdata publishes a cluster where the master has a single sync standby
+		putClusterdata := func(master, standby string, healthy bool) stolon.Clusterdata {
+			clusterdata := stolon.Clusterdata{
+				FormatVersion: stolon.SupportedFormatVersion,
+				Cluster: stolon.Cluster{
+					UID:  "cluster",
+					Spec: stolon.ClusterSpec{SynchronousReplication: true, MinSynchronousStandbys: 1},
+				},
+				Keepers: map[string]stolon.Keeper{},
+				Proxy:   stolon.Proxy{Spec: stolon.ProxySpec{MasterDbUID: "db-" + master}},
+				Dbs: map[string]stolon.DB{
+					"db-" + master: {
+						Spec:   stolon.DBSpec{KeeperUID: master},
+						Status: stolon.DBStatus{Healthy: healthy, SynchronousStandbys: []string{"db-" + standby}},
+					},
+					"db-" + standby: {
+						Spec:   stolon.DBSpec{KeeperUID: standby},
+						Status: stolon.DBStatus{Healthy: true},
+					},
+				},
+			}
+
+			value, err := json.Marshal(clusterdata)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Put(ctx, clusterdataKey, string(value))).To(Succeed())
+
+			return clusterdata
+		}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+			client = store.NewMemory()
+			failover = NewFailover(
+				kitlog.NewLogfmtLogger(GinkgoWriter), client, nil, stolon.Stolonctl{}, FailoverOptions{ClusterdataKey: clusterdataKey},
+			)
+
+			initial = putClusterdata("keeper0", "keeper1", true)
+		})
+
+		JustBeforeEach(func() {
+			notify = failover.NotifyRecovered(ctx, kitlog.NewLogfmtLogger(GinkgoWriter), initial)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("Waits for the new master to become healthy", func() {
+			Consistently(notify, 500*time.Millisecond).ShouldNot(Receive())
+
+			putClusterdata("keeper1", "keeper0", false)
+			Consistently(notify, 500*time.Millisecond).ShouldNot(Receive())
+
+			putClusterdata("keeper1", "keeper0", true)
+
+			var master stolon.DB
+			Eventually(notify).Should(Receive(&master))
+			Expect(master.Spec.KeeperUID).To(Equal("keeper1"))
+		})
+	})
+})
@@ -2,16 +2,20 @@ package integration
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/gocardless/stolon-pgbouncer/pkg/failover"
 	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
 	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer/integration"
+	"github.com/golang/protobuf/ptypes"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/jackc/pgx"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 )
 
 var _ = Describe("Server", func() {
@@ -30,7 +34,7 @@ var _ = Describe("Server", func() {
 	BeforeEach(func() {
 		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		bouncer, cleanup = integration.StartPgBouncer(database, user, password, port, "transaction")
-		server = failover.NewServer(logger, bouncer)
+		server = failover.NewServer(logger, bouncer, failover.ServerOptions{})
 
 		// Point the PgBouncer configuration at our integration Postgres database
 		Expect(bouncer.GenerateConfig(host)).To(Succeed())
@@ -89,4 +93,134 @@ var _ = Describe("Server", func() {
 			)
 		})
 	})
+
+	Describe("Pause with a cancel policy", func() {
+		BeforeEach(func() {
+			server = failover.NewServer(logger, bouncer, failover.ServerOptions{
+				CancelPolicy: failover.CancelPolicy{
+					Mode: failover.CancelQuery,
+					ConnConfig: pgx.ConnConfig{
+						Host: host, Port: mustAtoi(port), Database: database, User: user, Password: password,
+					},
+				},
+				CancelGracePeriod: 100 * time.Millisecond,
+			})
+		})
+
+		It("Cancels the query blocking the pause, and reports it", func() {
+			conn := connectToDatabase()
+			defer conn.Close()
+
+			queryErr := make(chan error, 1)
+			go func() {
+				_, err := conn.ExecEx(ctx, "select pg_sleep(5)", nil)
+				queryErr <- err
+			}()
+
+			// Give the query time to reach Postgres
+			time.Sleep(100 * time.Millisecond)
+
+			resp, err := server.Pause(ctx, &failover.PauseRequest{
+				Timeout: int64(2 * time.Second), Expiry: int64(time.Second),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resp.Cancellations).To(ConsistOf(
+				PointTo(MatchFields(IgnoreExtras, Fields{
+					"Mode":      Equal("cancel"),
+					"User":      Equal(user),
+					"Signalled": BeTrue(),
+				})),
+			))
+
+			Eventually(queryErr).Should(Receive(MatchError(ContainSubstring("canceling statement"))))
+		})
+	})
+
+	Describe("Pools", func() {
+		It("Reports clients holding a server mid-transaction", func() {
+			conn := connectToDatabase()
+			defer conn.Close()
+
+			tx, err := conn.BeginEx(ctx, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer tx.Rollback()
+
+			Expect(tx.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
+
+			resp, err := server.Pools(ctx, &failover.Empty{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(resp.Pools).To(ContainElement(
+				PointTo(MatchFields(IgnoreExtras, Fields{
+					"Database": Equal(database),
+					"PoolMode": Equal("transaction"),
+				})),
+			))
+
+			Expect(resp.Clients).To(ContainElement(
+				PointTo(MatchFields(IgnoreExtras, Fields{
+					"Database": Equal(database),
+					"Linked":   BeTrue(),
+				})),
+			))
+
+			// Our transaction is only just open, so shouldn't block a pause yet
+			Expect(failover.PauseBlockers(resp, time.Minute)).To(BeEmpty())
+		})
+
+		Context("When connected to Postgres", func() {
+			BeforeEach(func() {
+				server = failover.NewServer(logger, bouncer, failover.ServerOptions{
+					ConnConfig: &pgx.ConnConfig{
+						Host: host, Port: mustAtoi(port), Database: database, User: user, Password: password,
+					},
+				})
+			})
+
+			It("Reports when the transaction started, despite recent queries", func() {
+				conn := connectToDatabase()
+				defer conn.Close()
+
+				tx, err := conn.BeginEx(ctx, nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer tx.Rollback()
+
+				var xactStart time.Time
+				Expect(tx.QueryRowEx(ctx, "select xact_start from pg_stat_activity where pid = pg_backend_pid()", nil).
+					Scan(&xactStart)).To(Succeed())
+
+				time.Sleep(time.Second)
+				Expect(tx.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
+
+				resp, err := server.Pools(ctx, &failover.Empty{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resp.Clients).To(ContainElement(
+					PointTo(MatchFields(IgnoreExtras, Fields{
+						"Database":  Equal(database),
+						"XactStart": WithTransform(mustTimestamp, BeTemporally("==", xactStart)),
+					})),
+				))
+
+				Expect(failover.PauseBlockers(resp, 500*time.Millisecond)).To(ConsistOf(
+					MatchFields(IgnoreExtras, Fields{"Database": Equal(database), "User": Equal(user)}),
+				))
+			})
+		})
+	})
 })
+
+func mustAtoi(value string) uint16 {
+	number, err := strconv.Atoi(value)
+	Expect(err).NotTo(HaveOccurred())
+
+	return uint16(number)
+}
+
+func mustTimestamp(ts *tspb.Timestamp) time.Time {
+	t, err := ptypes.Timestamp(ts)
+	Expect(err).NotTo(HaveOccurred())
+
+	return t
+}
@@ -0,0 +1,142 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+)
+
+// PauseBlocker is a user and database whose clients would prevent PgBouncer from
+// pausing, causing the pause to hang until the pause timeout.
+type PauseBlocker struct {
+	Database, User, Reason string
+}
+
+func (b PauseBlocker) String() string {
+	return fmt.Sprintf("%s@%s: %s", b.User, b.Database, b.Reason)
+}
+
+// PauseBlockers inspects the pools of a PgBouncer for clients that would block a PAUSE.
+// PAUSE waits for every server connection to be released, which never happens for
+// session pools with active clients, and happens only once transactions complete for
+// transaction pools. We report transactions that have been open for longer than
+// maxTransactionAge at the time PgBouncer was queried.
+//
+// Transaction age is measured from xact_start when the pauser can reach Postgres.
+// Otherwise we fall back to the client's last request, which is only a heuristic: it
+// misses long transactions that keep issuing queries, and flags single slow queries.
+func PauseBlockers(resp *PoolsResponse, maxTransactionAge time.Duration) []PauseBlocker {
+	type key struct{ database, user string }
+
+	blockers := []PauseBlocker{}
+	sessionPools := map[key]bool{}
+	for _, pool := range resp.GetPools() {
+		// Our own admin connections are never paused
+		if pool.Database == "pgbouncer" || pool.PoolMode != "session" || pool.ActiveClients == 0 {
+			continue
+		}
+
+		sessionPools[key{pool.Database, pool.User}] = true
+		blockers = append(blockers, PauseBlocker{
+			pool.Database, pool.User, fmt.Sprintf("session pool has %d active clients", pool.ActiveClients),
+		})
+	}
+
+	queriedAt, err := ptypes.Timestamp(resp.GetCreatedAt())
+	if err != nil {
+		queriedAt = time.Now()
+	}
+
+	type transactions struct {
+		count  int
+		oldest time.Duration
+	}
+
+	open := map[key]*transactions{}
+	for _, client := range resp.GetClients() {
+		k := key{client.Database, client.User}
+		if client.Database == "pgbouncer" || !client.Linked || sessionPools[k] {
+			continue
+		}
+
+		startedAt := client.GetXactStart()
+		if startedAt == nil {
+			startedAt = client.GetRequestTime()
+		}
+
+		transactionStart, err := ptypes.Timestamp(startedAt)
+		if err != nil {
+			continue
+		}
+
+		age := queriedAt.Sub(transactionStart)
+		if age <= maxTransactionAge {
+			continue
+		}
+
+		if open[k] == nil {
+			open[k] = &transactions{}
+		}
+
+		open[k].count++
+		if age > open[k].oldest {
+			open[k].oldest = age
+		}
+	}
+
+	for k, txns := range open {
+		blockers = append(blockers, PauseBlocker{
+			k.database, k.user, fmt.Sprintf("%d transactions open longer than %s, oldest for %s",
+				txns.count, maxTransactionAge, txns.oldest.Truncate(time.Millisecond)),
+		})
+	}
+
+	sort.Slice(blockers, func(i, j int) bool {
+		if blockers[i].Database != blockers[j].Database {
+			return blockers[i].Database < blockers[j].Database
+		}
+
+		return blockers[i].User < blockers[j].User
+	})
+
+	return blockers
+}
+
+// CheckPauseSafe asks every pauser for its pools, refusing to proceed if any clients
+// would block the pause. Discovering this through a pause timeout would interrupt
+// traffic for the full timeout, only to abandon the failover.
+func (f *Failover) CheckPauseSafe(ctx context.Context) error {
+	if !f.opt.CheckPauseSafety {
+		return nil
+	}
+
+	f.logger.Log("event", "check_pause_safe", "msg", "checking no clients would block pause")
+	unsafe := []string{}
+	for endpoint, client := range f.clients {
+		ctx, cancel := NewClientCtx(ctx, f.opt.Token, f.opt.HealthCheckTimeout)
+		defer cancel()
+
+		resp, err := client.Pools(ctx, &Empty{})
+		if err != nil {
+			return errors.Wrapf(err, "client %s failed to report pools", endpoint)
+		}
+
+		for _, blocker := range PauseBlockers(resp, f.opt.MaxTransactionAge) {
+			f.logger.Log("event", "pause_blocker", "endpoint", endpoint, "database", blocker.Database,
+				"user", blocker.User, "reason", blocker.Reason)
+			unsafe = append(unsafe, fmt.Sprintf("%s %s", endpoint, blocker))
+		}
+	}
+
+	if len(unsafe) > 0 {
+		sort.Strings(unsafe)
+		return fmt.Errorf("clients would block pause: %s", strings.Join(unsafe, "; "))
+	}
+
+	return nil
+}
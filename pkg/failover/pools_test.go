@@ -0,0 +1,100 @@
+package failover
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PauseBlockers", func() {
+	var (
+		queriedAt = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		resp      *PoolsResponse
+	)
+
+	client := func(database, user string, linked bool, age time.Duration) *PoolsResponse_Client {
+		return &PoolsResponse_Client{
+			Database:    database,
+			User:        user,
+			State:       "active",
+			Linked:      linked,
+			RequestTime: mustTimestampProto(queriedAt.Add(-age)),
+		}
+	}
+
+	BeforeEach(func() {
+		resp = &PoolsResponse{
+			CreatedAt: mustTimestampProto(queriedAt),
+			Pools: []*PoolsResponse_Pool{
+				{Database: "app", User: "app", PoolMode: "transaction", ActiveClients: 3},
+				{Database: "pgbouncer", User: "pgbouncer", PoolMode: "statement", ActiveClients: 1},
+			},
+			Clients: []*PoolsResponse_Client{
+				client("app", "app", true, 10*time.Millisecond),
+				client("app", "app", false, time.Minute),
+				client("pgbouncer", "pgbouncer", true, time.Minute),
+			},
+		}
+	})
+
+	It("Returns nothing when transactions are short and pools are transaction mode", func() {
+		Expect(PauseBlockers(resp, time.Second)).To(BeEmpty())
+	})
+
+	Context("With active clients of a session pool", func() {
+		BeforeEach(func() {
+			resp.Pools = append(resp.Pools,
+				&PoolsResponse_Pool{Database: "reporting", User: "analyst", PoolMode: "session", ActiveClients: 2},
+				&PoolsResponse_Pool{Database: "reporting", User: "idle", PoolMode: "session"},
+			)
+			resp.Clients = append(resp.Clients, client("reporting", "analyst", true, time.Hour))
+		})
+
+		It("Reports only the session pool", func() {
+			Expect(PauseBlockers(resp, time.Second)).To(ConsistOf(
+				PauseBlocker{"reporting", "analyst", "session pool has 2 active clients"},
+			))
+		})
+	})
+
+	Context("With long transactions", func() {
+		BeforeEach(func() {
+			resp.Clients = append(resp.Clients,
+				client("app", "app", true, 5*time.Second),
+				client("app", "app", true, 3*time.Second),
+				client("app", "batch", true, 2*time.Second),
+			)
+		})
+
+		It("Reports each user and database, with the oldest transaction", func() {
+			blockers := PauseBlockers(resp, time.Second)
+
+			Expect(blockers).To(Equal([]PauseBlocker{
+				{"app", "app", "2 transactions open longer than 1s, oldest for 5s"},
+				{"app", "batch", "1 transactions open longer than 1s, oldest for 2s"},
+			}))
+			Expect(blockers[0].String()).To(Equal("app@app: 2 transactions open longer than 1s, oldest for 5s"))
+		})
+	})
+
+	Context("With transaction start times from Postgres", func() {
+		BeforeEach(func() {
+			// A transaction that keeps issuing queries looks young from its last request,
+			// while a single slow query in a young transaction looks old
+			busy := client("app", "app", true, 10*time.Millisecond)
+			busy.XactStart = mustTimestampProto(queriedAt.Add(-time.Minute))
+
+			slow := client("app", "batch", true, 5*time.Second)
+			slow.XactStart = mustTimestampProto(queriedAt.Add(-500 * time.Millisecond))
+
+			resp.Clients = append(resp.Clients, busy, slow)
+		})
+
+		It("Measures age from the start of the transaction", func() {
+			Expect(PauseBlockers(resp, time.Second)).To(Equal([]PauseBlocker{
+				{"app", "app", "1 transactions open longer than 1s, oldest for 1m0s"},
+			}))
+		})
+	})
+})
@@ -8,8 +8,11 @@ import (
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	tspb "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 	grpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -21,12 +24,25 @@ import (
 type Server struct {
 	logger  kitlog.Logger
 	bouncer *pgbouncer.PgBouncer
+	opt     ServerOptions
 }
 
-func NewServer(logger kitlog.Logger, bouncer *pgbouncer.PgBouncer) *Server {
+// ServerOptions configures a Server
+type ServerOptions struct {
+	// CancelPolicy decides which backends we signal when they block a pause for longer
+	// than CancelGracePeriod
+	CancelPolicy      CancelPolicy
+	CancelGracePeriod time.Duration
+	// ConnConfig, when set, connects to the Postgres that PgBouncer routes to so we can
+	// report when each client's transaction started
+	ConnConfig *pgx.ConnConfig
+}
+
+func NewServer(logger kitlog.Logger, bouncer *pgbouncer.PgBouncer, opt ServerOptions) *Server {
 	return &Server{
 		logger:  logger,
 		bouncer: bouncer,
+		opt:     opt,
 	}
 }
 
@@ -106,12 +122,13 @@ func (s *Server) Pause(ctx context.Context, req *PauseRequest) (resp *PauseRespo
 	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	if err := s.bouncer.Pause(timeoutCtx); err != nil {
+	cancellations, err := s.pause(timeoutCtx)
+	if err != nil {
 		if timeoutCtx.Err() == nil {
-			return nil, status.Error(codes.Unknown, err.Error())
+			return nil, withCancellations(status.New(codes.Unknown, err.Error()), cancellations)
 		}
 
-		return nil, status.Errorf(codes.DeadlineExceeded, "exceeded pause timeout")
+		return nil, withCancellations(status.New(codes.DeadlineExceeded, "exceeded pause timeout"), cancellations)
 	}
 
 	// We need to ensure we remove the pause at expiry seconds from the moment the request
@@ -134,11 +151,84 @@ func (s *Server) Pause(ctx context.Context, req *PauseRequest) (resp *PauseRespo
 	}
 
 	return &PauseResponse{
-		CreatedAt: mustTimestampProto(createdAt),
-		ExpiresAt: mustTimestampProto(expiresAt),
+		CreatedAt:     mustTimestampProto(createdAt),
+		ExpiresAt:     mustTimestampProto(expiresAt),
+		Cancellations: cancellations,
 	}, err
 }
 
+// pause issues a PAUSE, and if our cancel policy is enabled, signals the backends that
+// are still blocking it once the grace period has elapsed. Signalled backends are
+// returned so the caller can see what was interrupted.
+func (s *Server) pause(ctx context.Context) ([]*Cancellation, error) {
+	if !s.opt.CancelPolicy.Enabled() {
+		return nil, s.bouncer.Pause(ctx)
+	}
+
+	paused := make(chan error, 1)
+	go func() { paused <- s.bouncer.Pause(ctx) }()
+
+	select {
+	case err := <-paused:
+		return nil, err
+	case <-time.After(s.opt.CancelGracePeriod):
+	}
+
+	s.logger.Log("event", "pause_blocked", "grace_period", s.opt.CancelGracePeriod.Seconds(),
+		"msg", "pause exceeded grace period, signalling blocking backends")
+
+	// PgBouncer accepts admin commands while a PAUSE is pending, so we can find the
+	// backends that are blocking it.
+	cancellations, err := s.opt.CancelPolicy.cancelBlocking(ctx, s.logger, s.bouncer)
+	if err != nil {
+		s.logger.Log("error", err, "msg", "failed to signal blocking backends")
+	}
+
+	if err := <-paused; err != nil {
+		s.logger.Log("error", err, "cancellations", len(cancellations),
+			"msg", "pause failed despite signalling blocking backends")
+		return cancellations, err
+	}
+
+	return cancellations, nil
+}
+
+// withCancellations attaches the backends we signalled to a failed pause, as they will
+// have been interrupted even though the pause didn't succeed.
+func withCancellations(st *status.Status, cancellations []*Cancellation) error {
+	if len(cancellations) == 0 {
+		return st.Err()
+	}
+
+	details := make([]proto.Message, len(cancellations))
+	for idx, cancellation := range cancellations {
+		details[idx] = cancellation
+	}
+
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+
+	return st.Err()
+}
+
+// CancellationsFromError recovers the cancellations attached to a failed pause
+func CancellationsFromError(err error) []*Cancellation {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+
+	var cancellations []*Cancellation
+	for _, detail := range st.Details() {
+		if cancellation, ok := detail.(*Cancellation); ok {
+			cancellations = append(cancellations, cancellation)
+		}
+	}
+
+	return cancellations
+}
+
 func (s *Server) Resume(ctx context.Context, _ *Empty) (*ResumeResponse, error) {
 	if err := s.bouncer.Resume(ctx); err != nil {
 		return nil, status.Errorf(codes.Unknown, "failed to resume pgbouncer: %s", err.Error())
@@ -147,6 +237,118 @@ func (s *Server) Resume(ctx context.Context, _ *Empty) (*ResumeResponse, error)
 	return &ResumeResponse{CreatedAt: mustTimestampProto(time.Now())}, nil
 }
 
+// Pools reports PgBouncer's pools and clients, allowing the failover to check whether
+// it is safe to pause before doing so.
+func (s *Server) Pools(ctx context.Context, _ *Empty) (*PoolsResponse, error) {
+	createdAt := time.Now()
+
+	pools, err := s.bouncer.ShowPools(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, "failed to show pools: %s", err.Error())
+	}
+
+	clients, err := s.bouncer.ShowClients(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, "failed to show clients: %s", err.Error())
+	}
+
+	xactStarts := map[string]time.Time{}
+	if s.opt.ConnConfig != nil {
+		// Without transaction start times, the caller falls back to the client's last
+		// request, so we log rather than fail
+		if xactStarts, err = s.transactionStarts(ctx); err != nil {
+			s.logger.Log("error", err, "msg", "failed to find transaction start times")
+		}
+	}
+
+	resp := &PoolsResponse{CreatedAt: mustTimestampProto(createdAt)}
+	for _, pool := range pools {
+		resp.Pools = append(resp.Pools, &PoolsResponse_Pool{
+			Database:       pool.Database,
+			User:           pool.User,
+			PoolMode:       pool.PoolMode,
+			ActiveClients:  pool.ActiveClients,
+			WaitingClients: pool.WaitingClients,
+		})
+	}
+
+	for _, client := range clients {
+		respClient := &PoolsResponse_Client{
+			Database: client.Database,
+			User:     client.User,
+			State:    client.State,
+			Linked:   client.Linked,
+		}
+
+		if !client.RequestTime.IsZero() {
+			respClient.RequestTime = mustTimestampProto(client.RequestTime)
+		}
+
+		if xactStart, ok := xactStarts[client.Link]; ok && client.Linked {
+			respClient.XactStart = mustTimestampProto(xactStart)
+		}
+
+		resp.Clients = append(resp.Clients, respClient)
+	}
+
+	return resp, nil
+}
+
+// transactionStarts finds when the open transaction on each PgBouncer server connection
+// began, keyed by the server Ptr that linked clients refer to. We match server
+// connections to Postgres backends by their remote_pid.
+func (s *Server) transactionStarts(ctx context.Context) (map[string]time.Time, error) {
+	servers, err := s.bouncer.ShowServers(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to show servers")
+	}
+
+	ptrs := map[int64]string{}
+	pids := []int32{}
+	for _, server := range servers {
+		if server.Database != "pgbouncer" && server.State == "active" && server.RemotePID > 0 {
+			ptrs[server.RemotePID] = server.Ptr
+			pids = append(pids, int32(server.RemotePID))
+		}
+	}
+
+	xactStarts := map[string]time.Time{}
+	if len(pids) == 0 {
+		return xactStarts, nil
+	}
+
+	conn, err := connect(ctx, *s.opt.ConnConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	rows, err := conn.QueryEx(
+		ctx, `select pid::bigint, xact_start from pg_stat_activity where pid = any($1) and xact_start is not null;`, nil, pids,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query pg_stat_activity")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			pid       int64
+			xactStart time.Time
+		)
+
+		if err := rows.Scan(&pid, &xactStart); err != nil {
+			return nil, err
+		}
+
+		xactStarts[ptrs[pid]] = xactStart
+	}
+
+	return xactStarts, rows.Err()
+}
+
 func mustTimestampProto(t time.Time) *tspb.Timestamp {
 	ts, err := ptypes.TimestampProto(t)
 
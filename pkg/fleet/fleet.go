@@ -0,0 +1,174 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/pkg/errors"
+)
+
+// Proxy is the record each supervise publishes about itself, allowing operators to see
+// which proxies have converged on the current master.
+type Proxy struct {
+	Hostname         string    `json:"hostname"`
+	KeeperUID        string    `json:"keeperUid"`
+	Host             string    `json:"host"`
+	LastReload       time.Time `json:"lastReload"`
+	PgBouncerVersion string    `json:"pgbouncerVersion"`
+	Healthy          bool      `json:"healthy"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// Converged returns true if the proxy routes to the given master address
+func (p Proxy) Converged(masterAddress string) bool {
+	return p.Host == masterAddress
+}
+
+// Stragglers returns the healthy proxies that have yet to converge on the given master.
+// Unhealthy proxies are excluded, as they're unable to reload and waiting on them would
+// be pointless.
+func Stragglers(proxies []Proxy, masterAddress string) []Proxy {
+	stragglers := []Proxy{}
+	for _, proxy := range proxies {
+		if proxy.Healthy && !proxy.Converged(masterAddress) {
+			stragglers = append(stragglers, proxy)
+		}
+	}
+
+	return stragglers
+}
+
+// List returns every proxy record published under the given prefix, sorted by hostname
+func List(ctx context.Context, client store.Store, prefix string) ([]Proxy, error) {
+	kvs, err := client.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list proxies")
+	}
+
+	proxies := []Proxy{}
+	for _, kv := range kvs {
+		var proxy Proxy
+		if err := json.Unmarshal(kv.Value, &proxy); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse proxy record %s", kv.Key)
+		}
+
+		proxies = append(proxies, proxy)
+	}
+
+	sort.Slice(proxies, func(i, j int) bool { return proxies[i].Hostname < proxies[j].Hostname })
+
+	return proxies, nil
+}
+
+type registration interface {
+	Put(context.Context, string) error
+	Revoke(context.Context) error
+}
+
+// PublisherOptions configures a Publisher
+type PublisherOptions struct {
+	// Interval at which we refresh our health and republish our record
+	Interval time.Duration
+	// Timeout is applied to each health check and store operation
+	Timeout time.Duration
+	// HealthCheck returns the PgBouncer version, or an error if PgBouncer is unhealthy
+	HealthCheck func(context.Context) (string, error)
+}
+
+// Publisher keeps our proxy record up to date in the store, publishing whenever the
+// record is updated and at regular intervals.
+type Publisher struct {
+	logger       kitlog.Logger
+	registration registration
+	opt          PublisherOptions
+	updated      chan struct{}
+
+	mu    sync.Mutex
+	proxy Proxy
+}
+
+// NewPublisher constructs a publisher for the initial proxy record. Use a
+// store.Registration to publish records that expire shortly after supervise stops.
+func NewPublisher(logger kitlog.Logger, registration registration, proxy Proxy, opt PublisherOptions) *Publisher {
+	return &Publisher{
+		logger:       logger,
+		registration: registration,
+		opt:          opt,
+		updated:      make(chan struct{}, 1),
+		proxy:        proxy,
+	}
+}
+
+// Update modifies our proxy record, causing it to be published promptly
+func (p *Publisher) Update(update func(*Proxy)) {
+	p.mu.Lock()
+	update(&p.proxy)
+	p.mu.Unlock()
+
+	select {
+	case p.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes our record until the context is cancelled, at which point we remove it
+// from the store.
+func (p *Publisher) Run(ctx context.Context) error {
+	for {
+		if p.opt.HealthCheck != nil {
+			p.healthCheck(ctx)
+		}
+
+		if err := p.publish(ctx); err != nil {
+			p.logger.Log("error", err, "msg", "failed to publish proxy record")
+		}
+
+		select {
+		case <-ctx.Done():
+			revokeCtx, cancel := context.WithTimeout(context.Background(), p.opt.Timeout)
+			defer cancel()
+
+			p.logger.Log("event", "revoke", "msg", "removing proxy record")
+			return p.registration.Revoke(revokeCtx)
+		case <-p.updated:
+		case <-time.After(p.opt.Interval):
+		}
+	}
+}
+
+func (p *Publisher) healthCheck(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.opt.Timeout)
+	defer cancel()
+
+	version, err := p.opt.HealthCheck(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.proxy.Healthy, p.proxy.Error = err == nil, ""
+	if err != nil {
+		p.proxy.Error = err.Error()
+	} else {
+		p.proxy.PgBouncerVersion = version
+	}
+}
+
+func (p *Publisher) publish(ctx context.Context) error {
+	p.mu.Lock()
+	value, err := json.Marshal(p.proxy)
+	p.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.opt.Timeout)
+	defer cancel()
+
+	return p.registration.Put(ctx, string(value))
+}
@@ -0,0 +1,147 @@
+package fleet_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/fleet"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRegistration struct {
+	sync.Mutex
+	value   string
+	revoked bool
+}
+
+func (f *fakeRegistration) Put(_ context.Context, value string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.value = value
+
+	return nil
+}
+
+func (f *fakeRegistration) Revoke(context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	f.revoked = true
+
+	return nil
+}
+
+func (f *fakeRegistration) Proxy() fleet.Proxy {
+	f.Lock()
+	defer f.Unlock()
+
+	var proxy fleet.Proxy
+	json.Unmarshal([]byte(f.value), &proxy)
+
+	return proxy
+}
+
+var _ = Describe("Proxy", func() {
+	Describe(".Converged()", func() {
+		It("Returns true only when routing to the master", func() {
+			proxy := fleet.Proxy{Host: "10.0.0.1"}
+
+			Expect(proxy.Converged("10.0.0.1")).To(BeTrue())
+			Expect(proxy.Converged("10.0.0.2")).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("Stragglers", func() {
+	It("Returns healthy proxies not routing to the master", func() {
+		proxies := []fleet.Proxy{
+			{Hostname: "converged", Host: "10.0.0.1", Healthy: true},
+			{Hostname: "straggler", Host: "10.0.0.2", Healthy: true},
+			{Hostname: "unhealthy", Host: "10.0.0.2", Healthy: false},
+		}
+
+		Expect(fleet.Stragglers(proxies, "10.0.0.1")).To(Equal([]fleet.Proxy{
+			{Hostname: "straggler", Host: "10.0.0.2", Healthy: true},
+		}))
+	})
+})
+
+var _ = Describe("Publisher", func() {
+	var (
+		ctx          context.Context
+		cancel       func()
+		registration *fakeRegistration
+		healthErr    error
+		publisher    *fleet.Publisher
+		stopped      chan struct{}
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		registration = &fakeRegistration{}
+		healthErr = nil
+	})
+
+	JustBeforeEach(func() {
+		publisher = fleet.NewPublisher(
+			kitlog.NewNopLogger(), registration, fleet.Proxy{Hostname: "proxy0"},
+			fleet.PublisherOptions{
+				Interval: time.Hour,
+				Timeout:  time.Second,
+				HealthCheck: func(context.Context) (string, error) {
+					return "PgBouncer 1.12.0", healthErr
+				},
+			},
+		)
+
+		stopped = make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(stopped)
+			Expect(publisher.Run(ctx)).To(Succeed())
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("Publishes the record with health on start", func() {
+		Eventually(registration.Proxy).Should(Equal(fleet.Proxy{
+			Hostname:         "proxy0",
+			PgBouncerVersion: "PgBouncer 1.12.0",
+			Healthy:          true,
+		}))
+	})
+
+	It("Republishes on update", func() {
+		publisher.Update(func(proxy *fleet.Proxy) { proxy.Host = "10.0.0.1" })
+		Eventually(func() string { return registration.Proxy().Host }).Should(Equal("10.0.0.1"))
+	})
+
+	It("Revokes the record once cancelled", func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+
+		registration.Lock()
+		defer registration.Unlock()
+		Expect(registration.revoked).To(BeTrue())
+	})
+
+	Context("When PgBouncer is unhealthy", func() {
+		BeforeEach(func() { healthErr = errors.New("connection refused") })
+
+		It("Publishes the error", func() {
+			Eventually(registration.Proxy).Should(Equal(fleet.Proxy{
+				Hostname: "proxy0",
+				Error:    "connection refused",
+			}))
+		})
+	})
+})
@@ -0,0 +1,799 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// These match the resources that stolon manages when run with its kubernetes backend
+const (
+	clusterLabel          = "stolon-cluster"
+	componentLabel        = "component"
+	clusterdataAnnotation = "stolon-clusterdata"
+	statusAnnotation      = "stolon-status"
+)
+
+// components maps the info prefixes that stolon uses in other backends to the pods that
+// publish the same info as annotations
+var components = map[string]string{
+	"keepers/info/":   "stolon-keeper",
+	"sentinels/info/": "stolon-sentinel",
+	"proxies/info/":   "stolon-proxy",
+}
+
+// lockTTL is how long a failover lock survives without being renewed
+const lockTTL = 15 * time.Second
+
+var errLocked = errors.New("lock is held by another process")
+
+// Store implements store.Store against the resources stolon uses with its kubernetes
+// backend, presenting them under the same keys that stolon uses for etcd:
+//
+//	<prefix>/<cluster>/clusterdata          stolon-cluster-<cluster> ConfigMap annotation
+//	<prefix>/<cluster>/sentinel-leader/     stolon-cluster-<cluster> leader election record
+//	<prefix>/<cluster>/keepers/info/<pod>   stolon-status annotation of each keeper pod
+//
+// Sentinel and proxy info is read from pods in the same way. Keys that stolon doesn't
+// own, such as our proxy registry and failover lock, are stored as entries in a
+// stolon-pgbouncer-<cluster> ConfigMap that we create on first write. Kubernetes has no
+// leases, so leased entries record their expiry and are ignored once it has passed.
+// This relies on clocks being roughly in sync.
+type Store struct {
+	logger      kitlog.Logger
+	client      clientset.Interface
+	namespace   string
+	clusterName string
+	root        string
+	// LockRetryInterval is how often we try to acquire a lock that is held elsewhere
+	LockRetryInterval time.Duration
+	// WatchRetryInterval is how long we wait before restarting a failed watch
+	WatchRetryInterval time.Duration
+
+	now func() time.Time
+}
+
+var _ store.Store = &Store{}
+
+// NewStore serves the keys of the given stolon cluster from the Kubernetes namespace
+func NewStore(logger kitlog.Logger, client clientset.Interface, namespace, prefix, clusterName string) *Store {
+	return &Store{
+		logger:             logger,
+		client:             client,
+		namespace:          namespace,
+		clusterName:        clusterName,
+		root:               fmt.Sprintf("%s/%s/", prefix, clusterName),
+		LockRetryInterval:  time.Second,
+		WatchRetryInterval: time.Second,
+		now:                time.Now,
+	}
+}
+
+func (s *Store) stolonConfigMap() string {
+	return fmt.Sprintf("stolon-cluster-%s", s.clusterName)
+}
+
+func (s *Store) dataConfigMap() string {
+	return fmt.Sprintf("stolon-pgbouncer-%s", s.clusterName)
+}
+
+func (s *Store) Get(ctx context.Context, key string) (*store.KeyValue, error) {
+	kvs, err := s.List(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kv := range kvs {
+		if string(kv.Key) == key {
+			return kv, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// List only queries the resources that could hold keys with the given prefix, failing
+// for prefixes outside of our cluster
+func (s *Store) List(ctx context.Context, prefix string) ([]*store.KeyValue, error) {
+	if !overlaps(prefix, s.root) {
+		return nil, errors.Errorf("prefix %s is outside of cluster %s", prefix, s.clusterName)
+	}
+
+	kvs := []*store.KeyValue{}
+
+	for _, name := range []string{s.stolonConfigMap(), s.dataConfigMap()} {
+		cm, err := s.getConfigMap(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		kvs = append(kvs, s.configMapKvs(cm)...)
+	}
+
+	for infoPrefix, component := range components {
+		if !overlaps(prefix, s.root+infoPrefix) {
+			continue
+		}
+
+		selector := labels.SelectorFromSet(labels.Set{clusterLabel: s.clusterName, componentLabel: component})
+		pods, err := s.client.CoreV1().Pods(s.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s pods", component)
+		}
+
+		for _, pod := range pods.Items {
+			if status, ok := pod.Annotations[statusAnnotation]; ok {
+				kvs = append(kvs, &store.KeyValue{
+					Key:         []byte(s.root + infoPrefix + pod.Name),
+					Value:       []byte(status),
+					ModRevision: revision(pod.ResourceVersion),
+				})
+			}
+		}
+	}
+
+	return sortKvs(filterKvs(kvs, prefix)), nil
+}
+
+// SentinelLeader reads the leader election record that stolon's sentinels maintain on
+// the cluster's ConfigMap
+func (s *Store) SentinelLeader(ctx context.Context, clusterPath string) (string, error) {
+	if clusterPath+"/" != s.root {
+		return "", errors.Errorf("cluster %s is not served by this store, which holds %s", clusterPath, s.root)
+	}
+
+	cm, err := s.getConfigMap(ctx, s.stolonConfigMap())
+	if err != nil || cm == nil {
+		return "", err
+	}
+
+	return s.sentinelLeader(cm), nil
+}
+
+func (s *Store) Put(ctx context.Context, key, value string) error {
+	name, set, err := s.locate(key)
+	if err != nil {
+		return err
+	}
+
+	return s.modify(ctx, name, true, func(cm *corev1.ConfigMap) error {
+		return set(cm, &entry{Value: value})
+	})
+}
+
+// CompareAndSwap compares values rather than revisions, as we share a single
+// resourceVersion across every key in a ConfigMap. Our update is made against the
+// resourceVersion we read, so concurrent writes still cause us to fail.
+func (s *Store) CompareAndSwap(ctx context.Context, key string, prev *store.KeyValue, value string) error {
+	name, set, err := s.locate(key)
+	if err != nil {
+		return err
+	}
+
+	return s.modify(ctx, name, false, func(cm *corev1.ConfigMap) error {
+		var current *store.KeyValue
+		for _, kv := range s.configMapKvs(cm) {
+			if string(kv.Key) == key {
+				current = kv
+			}
+		}
+
+		if prev == nil && current != nil || prev != nil && (current == nil || string(current.Value) != string(prev.Value)) {
+			return store.ErrCompareFailed
+		}
+
+		return set(cm, &entry{Value: value})
+	})
+}
+
+// Watch follows changes to the ConfigMaps, diffing each version against the last to
+// find what changed. Pod info is not watched, and can only be listed. As with Consul,
+// resuming from a revision emits keys modified since without replaying history. Keys we
+// can't watch receive a single error response.
+func (s *Store) Watch(ctx context.Context, key string, opt store.WatchOptions) <-chan store.WatchResponse {
+	out := make(chan store.WatchResponse)
+
+	var wg sync.WaitGroup
+	if err := s.watchable(key); err != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+			case out <- store.WatchResponse{Err: err}:
+			}
+		}()
+	} else {
+		for _, name := range []string{s.stolonConfigMap(), s.dataConfigMap()} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				s.watchConfigMap(ctx, name, key, opt, out)
+			}(name)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		<-ctx.Done()
+		close(out)
+	}()
+
+	return out
+}
+
+// watchable checks the key lies within our cluster, and outside of the pod info that
+// we're unable to watch
+func (s *Store) watchable(key string) error {
+	if !overlaps(key, s.root) {
+		return errors.Errorf("key %s is outside of cluster %s", key, s.clusterName)
+	}
+
+	for infoPrefix := range components {
+		if strings.HasPrefix(key, s.root+infoPrefix) {
+			return errors.Errorf("key %s is read from pods, which can only be listed", key)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) watchConfigMap(ctx context.Context, name, key string, opt store.WatchOptions, out chan<- store.WatchResponse) {
+	logger := kitlog.With(s.logger, "configmap", name)
+
+	var primed bool
+	seen := map[string]*store.KeyValue{}
+	emit := func(cm *corev1.ConfigMap) bool {
+		current, resp := map[string]*store.KeyValue{}, store.WatchResponse{}
+		for _, kv := range filterKvs(s.configMapKvs(cm), key) {
+			if !opt.Prefix && string(kv.Key) != key {
+				continue
+			}
+
+			current[string(kv.Key)] = kv
+			last, ok := seen[string(kv.Key)]
+			if primed && (!ok || string(last.Value) != string(kv.Value)) || !primed && opt.Revision > 0 && kv.ModRevision >= opt.Revision {
+				resp.Kvs = append(resp.Kvs, kv)
+			}
+		}
+
+		for seenKey := range seen {
+			if _, ok := current[seenKey]; !ok && primed {
+				resp.Kvs = append(resp.Kvs, &store.KeyValue{Key: []byte(seenKey), ModRevision: revision(resourceVersion(cm))})
+			}
+		}
+
+		seen, primed = current, true
+		if len(resp.Kvs) == 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- store.WatchResponse{Kvs: sortKvs(resp.Kvs)}:
+			return true
+		}
+	}
+
+	fail := func(err error) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- store.WatchResponse{Err: err}:
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(s.WatchRetryInterval):
+			return true
+		}
+	}
+
+	for ctx.Err() == nil {
+		// Each time we start watching we re-read the ConfigMap, catching up on anything we
+		// missed while the watch was down
+		cm, err := s.getConfigMap(ctx, name)
+		if err != nil {
+			if !fail(err) {
+				return
+			}
+
+			continue
+		}
+
+		if !emit(cm) {
+			return
+		}
+
+		w, err := s.client.CoreV1().ConfigMaps(s.namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion(cm),
+		})
+		if err != nil {
+			if !fail(errors.Wrap(err, "failed to watch configmap")) {
+				return
+			}
+
+			continue
+		}
+
+	Events:
+		for {
+			select {
+			case <-ctx.Done():
+				w.Stop()
+				return
+			case event, ok := <-w.ResultChan():
+				if !ok {
+					break Events
+				}
+
+				// Errors, such as our resourceVersion being too old, are resolved by
+				// re-reading the ConfigMap and starting again
+				cm, isConfigMap := event.Object.(*corev1.ConfigMap)
+				if event.Type == watch.Error || !isConfigMap {
+					logger.Log("event", "watch_error", "object", event.Object)
+					break Events
+				}
+
+				if cm.Name != name {
+					continue
+				}
+
+				if event.Type == watch.Deleted {
+					cm = nil
+				}
+
+				if !emit(cm) {
+					w.Stop()
+					return
+				}
+			}
+		}
+
+		w.Stop()
+		logger.Log("event", "watch_restart", "msg", "configmap watch ended, restarting")
+	}
+}
+
+// Grant creates a lease that stamps an expiry on the entries it writes
+func (s *Store) Grant(ctx context.Context, ttl time.Duration) (store.Lease, error) {
+	return s.newLease(uuid.NewV4().String(), ttl), nil
+}
+
+func (s *Store) newLease(id string, ttl time.Duration) *lease {
+	return &lease{store: s, id: id, ttl: ttl}
+}
+
+type lease struct {
+	store *Store
+	id    string
+	ttl   time.Duration
+}
+
+func (l *lease) Put(ctx context.Context, key, value string) error {
+	name, set, err := l.store.locate(key)
+	if err != nil {
+		return err
+	}
+
+	if name != l.store.dataConfigMap() {
+		return errors.Errorf("key %s cannot be attached to a lease", key)
+	}
+
+	return l.store.modify(ctx, name, true, func(cm *corev1.ConfigMap) error {
+		return set(cm, l.entry(value))
+	})
+}
+
+func (l *lease) entry(value string) *entry {
+	expiresAt := l.store.now().Add(l.ttl)
+	return &entry{Value: value, Lease: l.id, ExpiresAt: &expiresAt}
+}
+
+// KeepAlive extends the expiry of our entries at a third of the TTL. Should we fail to
+// renew within the TTL, or find our entries have expired, the lease is lost.
+func (l *lease) KeepAlive(ctx context.Context) (<-chan struct{}, error) {
+	lost := make(chan struct{})
+
+	go func() {
+		defer close(lost)
+
+		renewed := l.store.now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(l.ttl / 3):
+			}
+
+			err := l.renew(ctx)
+			if err == nil {
+				renewed = l.store.now()
+				continue
+			}
+
+			l.store.logger.Log("event", "lease_renew_failed", "lease", l.id, "error", err)
+			if err == errLeaseExpired || l.store.now().Sub(renewed) > l.ttl {
+				return
+			}
+		}
+	}()
+
+	return lost, nil
+}
+
+var errLeaseExpired = errors.New("lease has expired")
+
+func (l *lease) renew(ctx context.Context) error {
+	return l.store.modify(ctx, l.store.dataConfigMap(), true, func(cm *corev1.ConfigMap) error {
+		for name, value := range cm.Data {
+			e := decodeEntry(value)
+			if e == nil || e.Lease != l.id {
+				continue
+			}
+
+			if e.expired(l.store.now()) {
+				return errLeaseExpired
+			}
+
+			cm.Data[name] = l.entry(e.Value).encode()
+		}
+
+		return nil
+	})
+}
+
+func (l *lease) Revoke(ctx context.Context) error {
+	return l.store.modify(ctx, l.store.dataConfigMap(), true, func(cm *corev1.ConfigMap) error {
+		for name, value := range cm.Data {
+			if e := decodeEntry(value); e != nil && e.Lease == l.id {
+				delete(cm.Data, name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Locker returns a lock held as a leased entry, which we renew until unlocked
+func (s *Store) Locker(key string) store.Locker {
+	return &locker{store: s, key: key}
+}
+
+type locker struct {
+	store  *Store
+	key    string
+	lease  *lease
+	cancel func()
+}
+
+func (l *locker) Lock(ctx context.Context) error {
+	name, set, err := l.store.locate(l.key)
+	if err != nil {
+		return err
+	}
+
+	held := l.store.newLease(uuid.NewV4().String(), lockTTL)
+	for {
+		err := l.store.modify(ctx, name, true, func(cm *corev1.ConfigMap) error {
+			if e := decodeEntry(cm.Data[encodeKey(l.store.relative(l.key))]); e != nil && !e.expired(l.store.now()) {
+				return errLocked
+			}
+
+			return set(cm, held.entry(held.id))
+		})
+
+		if err == nil {
+			break
+		}
+
+		if err != errLocked {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.store.LockRetryInterval):
+		}
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	if _, err := held.KeepAlive(keepAliveCtx); err != nil {
+		cancel()
+		return err
+	}
+
+	l.lease, l.cancel = held, cancel
+	return nil
+}
+
+func (l *locker) Unlock(ctx context.Context) error {
+	if l.lease == nil {
+		return nil
+	}
+
+	l.cancel()
+	defer func() { l.lease, l.cancel = nil, nil }()
+
+	return l.lease.Revoke(ctx)
+}
+
+// entry is how we store values in our own ConfigMap, allowing us to record which lease
+// wrote them and when they expire
+type entry struct {
+	Value     string     `json:"value"`
+	Lease     string     `json:"lease,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && now.After(*e.ExpiresAt)
+}
+
+func (e *entry) encode() string {
+	data, _ := json.Marshal(e)
+	return string(data)
+}
+
+func decodeEntry(value string) *entry {
+	if value == "" {
+		return nil
+	}
+
+	var e entry
+	if err := json.Unmarshal([]byte(value), &e); err != nil {
+		return nil
+	}
+
+	return &e
+}
+
+// locate finds the ConfigMap that holds the key, along with a function to set its value
+// within that ConfigMap
+func (s *Store) locate(key string) (string, func(*corev1.ConfigMap, *entry) error, error) {
+	if !strings.HasPrefix(key, s.root) {
+		return "", nil, errors.Errorf("key %s is outside of cluster %s", key, s.clusterName)
+	}
+
+	relative := s.relative(key)
+	if relative == "clusterdata" {
+		return s.stolonConfigMap(), func(cm *corev1.ConfigMap, e *entry) error {
+			if e.Lease != "" {
+				return errors.New("clusterdata cannot be attached to a lease")
+			}
+
+			if cm.Annotations == nil {
+				cm.Annotations = map[string]string{}
+			}
+
+			cm.Annotations[clusterdataAnnotation] = e.Value
+			return nil
+		}, nil
+	}
+
+	for _, reserved := range []string{"sentinel-leader/", "keepers/info/", "sentinels/info/", "proxies/info/"} {
+		if strings.HasPrefix(relative, reserved) {
+			return "", nil, errors.Errorf("key %s is managed by stolon and cannot be written", key)
+		}
+	}
+
+	return s.dataConfigMap(), func(cm *corev1.ConfigMap, e *entry) error {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+
+		cm.Data[encodeKey(relative)] = e.encode()
+		return nil
+	}, nil
+}
+
+func (s *Store) relative(key string) string {
+	return strings.TrimPrefix(key, s.root)
+}
+
+// modify applies the change to the latest version of the ConfigMap, creating our own
+// ConfigMap should it not exist. Conflicting writes are retried if requested, or
+// otherwise fail with store.ErrCompareFailed.
+func (s *Store) modify(ctx context.Context, name string, retry bool, change func(*corev1.ConfigMap) error) error {
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		cm, err := s.getConfigMap(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		create := cm == nil
+		if create {
+			if name != s.dataConfigMap() {
+				return errors.Errorf("configmap %s does not exist", name)
+			}
+
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: name, Namespace: s.namespace, Labels: map[string]string{clusterLabel: s.clusterName},
+				},
+			}
+		}
+
+		if err := change(cm); err != nil {
+			return err
+		}
+
+		if create {
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+		} else {
+			_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		if !apierrors.IsConflict(err) && !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "failed to update configmap %s", name)
+		}
+
+		if !retry {
+			return store.ErrCompareFailed
+		}
+	}
+}
+
+// getConfigMap returns nil if the ConfigMap does not exist
+func (s *Store) getConfigMap(ctx context.Context, name string) (*corev1.ConfigMap, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get configmap %s", name)
+	}
+
+	return cm, nil
+}
+
+// configMapKvs presents the contents of either of our ConfigMaps as keys
+func (s *Store) configMapKvs(cm *corev1.ConfigMap) []*store.KeyValue {
+	kvs := []*store.KeyValue{}
+	if cm == nil {
+		return kvs
+	}
+
+	rev := revision(cm.ResourceVersion)
+	if cm.Name == s.stolonConfigMap() {
+		if clusterdata, ok := cm.Annotations[clusterdataAnnotation]; ok {
+			kvs = append(kvs, &store.KeyValue{Key: []byte(s.root + "clusterdata"), Value: []byte(clusterdata), ModRevision: rev})
+		}
+
+		if holder := s.sentinelLeader(cm); holder != "" {
+			kvs = append(kvs, &store.KeyValue{Key: []byte(s.root + "sentinel-leader/" + holder), Value: []byte(holder), ModRevision: rev})
+		}
+
+		return kvs
+	}
+
+	for name, value := range cm.Data {
+		if e := decodeEntry(value); e != nil && !e.expired(s.now()) {
+			kvs = append(kvs, &store.KeyValue{Key: []byte(s.root + decodeKey(name)), Value: []byte(e.Value), ModRevision: rev})
+		}
+	}
+
+	return kvs
+}
+
+// sentinelLeader returns the holder of the sentinels' leader election, provided their
+// lease has yet to expire
+func (s *Store) sentinelLeader(cm *corev1.ConfigMap) string {
+	var record resourcelock.LeaderElectionRecord
+	if err := json.Unmarshal([]byte(cm.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]), &record); err != nil {
+		return ""
+	}
+
+	expiresAt := record.RenewTime.Add(time.Duration(record.LeaseDurationSeconds) * time.Second)
+	if record.HolderIdentity == "" || s.now().After(expiresAt) {
+		return ""
+	}
+
+	return record.HolderIdentity
+}
+
+// ConfigMap keys may only contain alphanumerics, '-', '_' and '.', so we replace path
+// separators with underscores. To keep this reversible we escape any '_' or '.' in the
+// key with a '.', leaving keys without either as they were before we escaped anything.
+func encodeKey(relative string) string {
+	var b strings.Builder
+	for _, r := range relative {
+		switch r {
+		case '.', '_':
+			b.WriteRune('.')
+			b.WriteRune(r)
+		case '/':
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// decodeKey reverses encodeKey. A '.' that escapes nothing is kept, so we can still read
+// keys written before we escaped dots.
+func decodeKey(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '.' && i+1 < len(name) && (name[i+1] == '.' || name[i+1] == '_'):
+			i++
+			b.WriteByte(name[i])
+		case name[i] == '_':
+			b.WriteByte('/')
+		default:
+			b.WriteByte(name[i])
+		}
+	}
+
+	return b.String()
+}
+
+func overlaps(prefix, root string) bool {
+	return strings.HasPrefix(prefix, root) || strings.HasPrefix(root, prefix)
+}
+
+func filterKvs(kvs []*store.KeyValue, prefix string) []*store.KeyValue {
+	filtered := []*store.KeyValue{}
+	for _, kv := range kvs {
+		if strings.HasPrefix(string(kv.Key), prefix) {
+			filtered = append(filtered, kv)
+		}
+	}
+
+	return filtered
+}
+
+func sortKvs(kvs []*store.KeyValue) []*store.KeyValue {
+	sort.Slice(kvs, func(i, j int) bool { return string(kvs[i].Key) < string(kvs[j].Key) })
+	return kvs
+}
+
+func resourceVersion(cm *corev1.ConfigMap) string {
+	if cm == nil {
+		return ""
+	}
+
+	return cm.ResourceVersion
+}
+
+// revision interprets a resourceVersion as a revision. Kubernetes considers these
+// opaque, but in practice they are etcd revisions and increase with every change.
+func revision(resourceVersion string) int64 {
+	rev, _ := strconv.ParseInt(resourceVersion, 10, 64)
+	return rev
+}
@@ -0,0 +1,279 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+)
+
+var _ = Describe("Store", func() {
+	var (
+		ctx    context.Context
+		cancel func()
+		client *fake.Clientset
+		s      *Store
+		now    time.Time
+	)
+
+	const root = "stolon/cluster/main/"
+
+	matchKv := func(key, value string) types.GomegaMatcher {
+		return PointTo(
+			MatchFields(
+				IgnoreExtras,
+				Fields{
+					"Key":   Equal([]byte(key)),
+					"Value": Equal([]byte(value)),
+				},
+			),
+		)
+	}
+
+	pod := func(name, component, status string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "stolon",
+				Labels:      map[string]string{"stolon-cluster": "main", "component": component},
+				Annotations: map[string]string{"stolon-status": status},
+			},
+		}
+	}
+
+	updateClusterdata := func(value string) {
+		configMaps := client.CoreV1().ConfigMaps("stolon")
+		cm, err := configMaps.Get(ctx, "stolon-cluster-main", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		cm.Annotations["stolon-clusterdata"] = value
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		now = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		client = fake.NewSimpleClientset(
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "stolon-cluster-main",
+					Namespace: "stolon",
+					Annotations: map[string]string{
+						"stolon-clusterdata": `{"formatVersion":1}`,
+						"control-plane.alpha.kubernetes.io/leader": fmt.Sprintf(
+							`{"holderIdentity":"sentinel-a","leaseDurationSeconds":15,"renewTime":"%s"}`,
+							now.Add(-5*time.Second).Format(time.RFC3339),
+						),
+					},
+				},
+			},
+			pod("keeper-0", "stolon-keeper", `{"uid":"keeper0"}`),
+			pod("keeper-1", "stolon-keeper", `{"uid":"keeper1"}`),
+			pod("sentinel-0", "stolon-sentinel", `{"uid":"sentinel-a"}`),
+		)
+
+		s = NewStore(kitlog.NewLogfmtLogger(GinkgoWriter), client, "stolon", "stolon/cluster", "main")
+		s.LockRetryInterval = 10 * time.Millisecond
+		s.now = func() time.Time { return now }
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Reads clusterdata from the stolon ConfigMap", func() {
+		Expect(s.Get(ctx, root+"clusterdata")).To(matchKv(root+"clusterdata", `{"formatVersion":1}`))
+	})
+
+	It("Reads component info from pod annotations", func() {
+		Expect(s.List(ctx, root+"keepers/info/")).To(
+			ConsistOf(
+				matchKv(root+"keepers/info/keeper-0", `{"uid":"keeper0"}`),
+				matchKv(root+"keepers/info/keeper-1", `{"uid":"keeper1"}`),
+			),
+		)
+	})
+
+	It("Reads the sentinel leader while its lease is current", func() {
+		Expect(s.List(ctx, root+"sentinel-leader/")).To(
+			ConsistOf(matchKv(root+"sentinel-leader/sentinel-a", "sentinel-a")),
+		)
+
+		Expect(s.SentinelLeader(ctx, "stolon/cluster/main")).To(Equal("sentinel-a"))
+
+		now = now.Add(time.Minute)
+		Expect(s.List(ctx, root+"sentinel-leader/")).To(BeEmpty())
+		Expect(s.SentinelLeader(ctx, "stolon/cluster/main")).To(Equal(""))
+	})
+
+	It("Fails to read keys outside of the cluster", func() {
+		_, err := s.List(ctx, "stolon/cluster/other/")
+		Expect(err).To(MatchError(ContainSubstring("is outside of cluster main")))
+
+		_, err = s.Get(ctx, "stolon/cluster/other/clusterdata")
+		Expect(err).To(MatchError(ContainSubstring("is outside of cluster main")))
+	})
+
+	It("Refuses to write keys managed by stolon", func() {
+		Expect(s.Put(ctx, root+"keepers/info/keeper-0", "value")).To(
+			MatchError(ContainSubstring("is managed by stolon")),
+		)
+	})
+
+	Describe("CompareAndSwap", func() {
+		It("Updates clusterdata if unchanged since read", func() {
+			kv, err := s.Get(ctx, root+"clusterdata")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(s.CompareAndSwap(ctx, root+"clusterdata", kv, `{"formatVersion":2}`)).To(Succeed())
+			Expect(s.Get(ctx, root+"clusterdata")).To(matchKv(root+"clusterdata", `{"formatVersion":2}`))
+		})
+
+		It("Fails if clusterdata has changed", func() {
+			kv, err := s.Get(ctx, root+"clusterdata")
+			Expect(err).NotTo(HaveOccurred())
+
+			updateClusterdata(`{"formatVersion":3}`)
+			Expect(s.CompareAndSwap(ctx, root+"clusterdata", kv, `{"formatVersion":2}`)).To(
+				Equal(store.ErrCompareFailed),
+			)
+		})
+	})
+
+	Describe("Leases", func() {
+		var lease store.Lease
+
+		BeforeEach(func() {
+			var err error
+			lease, err = s.Grant(ctx, 10*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lease.Put(ctx, root+"stolon-pgbouncer/proxies/proxy-0", "record")).To(Succeed())
+		})
+
+		It("Creates our ConfigMap to store entries", func() {
+			cm, err := client.CoreV1().ConfigMaps("stolon").Get(ctx, "stolon-pgbouncer-main", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Data).To(HaveKey("stolon-pgbouncer_proxies_proxy-0"))
+
+			Expect(s.List(ctx, root+"stolon-pgbouncer/proxies/")).To(
+				ConsistOf(matchKv(root+"stolon-pgbouncer/proxies/proxy-0", "record")),
+			)
+		})
+
+		It("Removes entries when revoked", func() {
+			Expect(lease.Revoke(ctx)).To(Succeed())
+			Expect(s.Get(ctx, root+"stolon-pgbouncer/proxies/proxy-0")).To(BeNil())
+		})
+
+		It("Ignores entries once expired", func() {
+			now = now.Add(time.Minute)
+			Expect(s.Get(ctx, root+"stolon-pgbouncer/proxies/proxy-0")).To(BeNil())
+		})
+
+		It("Preserves underscores and dots in keys", func() {
+			key := root + "stolon-pgbouncer/proxies/proxy_1.example.com"
+			Expect(lease.Put(ctx, key, "other")).To(Succeed())
+
+			Expect(s.Get(ctx, key)).To(matchKv(key, "other"))
+			Expect(s.List(ctx, root+"stolon-pgbouncer/proxies/")).To(ConsistOf(
+				matchKv(root+"stolon-pgbouncer/proxies/proxy-0", "record"),
+				matchKv(key, "other"),
+			))
+		})
+	})
+
+	Describe("encodeKey", func() {
+		It("Round-trips keys through decodeKey", func() {
+			for _, key := range []string{
+				"stolon-pgbouncer/proxies/proxy-0",
+				"stolon-pgbouncer/proxies/proxy_0",
+				"stolon-pgbouncer/proxies/proxy._/0.",
+				"a__b/..c",
+			} {
+				Expect(decodeKey(encodeKey(key))).To(Equal(key))
+			}
+		})
+
+		It("Produces valid ConfigMap keys", func() {
+			Expect(encodeKey("stolon-pgbouncer/proxies/proxy_1.example.com")).To(
+				MatchRegexp(`^[-._a-zA-Z0-9]+$`),
+			)
+		})
+	})
+
+	Describe("Locker", func() {
+		It("Provides exclusive access until unlocked", func() {
+			first, second := s.Locker(root+"clusterdata/failover"), s.Locker(root+"clusterdata/failover")
+			Expect(first.Lock(ctx)).To(Succeed())
+
+			timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer timeoutCancel()
+
+			Expect(second.Lock(timeoutCtx)).To(Equal(context.DeadlineExceeded))
+
+			Expect(first.Unlock(ctx)).To(Succeed())
+			Expect(second.Lock(ctx)).To(Succeed())
+		})
+
+		It("Can be taken once the holder's lock expires", func() {
+			first, second := s.Locker(root+"clusterdata/failover"), s.Locker(root+"clusterdata/failover")
+			Expect(first.Lock(ctx)).To(Succeed())
+
+			now = now.Add(time.Minute)
+			Expect(second.Lock(ctx)).To(Succeed())
+		})
+	})
+
+	Describe("Watch", func() {
+		It("Emits changes to clusterdata", func() {
+			watch := s.Watch(ctx, root+"clusterdata", store.WatchOptions{})
+
+			// The fake clientset doesn't replay changes made before our watch started, so
+			// we keep changing clusterdata until we see it
+			attempt := 0
+			Eventually(func() []*store.KeyValue {
+				attempt++
+				updateClusterdata(fmt.Sprintf(`{"attempt":%d}`, attempt))
+
+				select {
+				case resp := <-watch:
+					return resp.Kvs
+				case <-time.After(50 * time.Millisecond):
+					return nil
+				}
+			}).Should(
+				ContainElement(
+					PointTo(MatchFields(IgnoreExtras, Fields{"Key": Equal([]byte(root + "clusterdata"))})),
+				),
+			)
+		})
+
+		It("Fails for keys outside of the cluster, or read from pods", func() {
+			var resp store.WatchResponse
+			Eventually(s.Watch(ctx, "stolon/cluster/other/clusterdata", store.WatchOptions{})).Should(Receive(&resp))
+			Expect(resp.Err).To(MatchError(ContainSubstring("is outside of cluster main")))
+
+			Eventually(s.Watch(ctx, root+"keepers/info/", store.WatchOptions{Prefix: true})).Should(Receive(&resp))
+			Expect(resp.Err).To(MatchError(ContainSubstring("can only be listed")))
+		})
+
+		It("Closes the channel when the context ends", func() {
+			watch := s.Watch(ctx, root+"clusterdata", store.WatchOptions{})
+			cancel()
+
+			Eventually(watch).Should(BeClosed())
+		})
+	})
+})
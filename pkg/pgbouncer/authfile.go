@@ -0,0 +1,141 @@
+package pgbouncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// User is a single entry of the PgBouncer auth_file. Password can be plain-text, or any
+// of the md5/SCRAM secret formats that PgBouncer accepts.
+type User struct {
+	Name, Password string
+}
+
+// AuthFile manages the PgBouncer auth_file (commonly userlist.txt) at the given path
+type AuthFile struct {
+	Path string
+}
+
+// Write renders the users into the auth_file format and atomically replaces the file at
+// Path. If the rendered contents match what is already on disk then the file is left
+// untouched, and changed is false. Callers should only reload PgBouncer when changed.
+func (a AuthFile) Write(users []User) (changed bool, err error) {
+	return writeFileAtomic(a.Path, RenderAuthFile(users), 0600)
+}
+
+// RenderAuthFile produces the auth_file contents for the given users, sorted by name so
+// the output is stable regardless of the order of our source.
+func RenderAuthFile(users []User) []byte {
+	sorted := make([]User, len(users))
+	copy(sorted, users)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var buffer bytes.Buffer
+	for _, user := range sorted {
+		fmt.Fprintf(&buffer, "%s %s\n", quoteAuthFileValue(user.Name), quoteAuthFileValue(user.Password))
+	}
+
+	return buffer.Bytes()
+}
+
+// PgBouncer expects each value to be double quoted, with any double quotes inside the
+// value escaped by doubling them.
+func quoteAuthFileValue(value string) string {
+	return fmt.Sprintf(`"%s"`, strings.Replace(value, `"`, `""`, -1))
+}
+
+// LoadUsersFromDirectory reads users from a directory of secret files, where each file
+// name is the user name and the file contents are the password. This is the layout
+// produced when mounting secrets from tools like Vault or Kubernetes. Hidden files and
+// directories are ignored.
+func LoadUsersFromDirectory(dir string) ([]User, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read auth source directory")
+	}
+
+	users := []User{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		password, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read password for user %s", entry.Name())
+		}
+
+		users = append(users, User{Name: entry.Name(), Password: strings.TrimRight(string(password), "\r\n")})
+	}
+
+	return users, nil
+}
+
+// ParseUsersJSON parses users from a JSON object of user name to password, such as:
+//
+//	{"app": "md5c9b1ab6ac2a9fdd29a0a3bd4e1d2a5e6", "reporting": "secret"}
+func ParseUsersJSON(data []byte) ([]User, error) {
+	passwords := map[string]string{}
+	if err := json.Unmarshal(data, &passwords); err != nil {
+		return nil, errors.Wrap(err, "failed to parse users")
+	}
+
+	users := []User{}
+	for name, password := range passwords {
+		users = append(users, User{Name: name, Password: password})
+	}
+
+	return users, nil
+}
+
+// writeFileAtomic writes contents to a temporary file in the same directory as path,
+// before renaming it into place. This ensures PgBouncer never reads a partially written
+// file. No write happens if the existing file already has the given contents.
+func writeFileAtomic(path string, contents []byte, perm os.FileMode) (changed bool, err error) {
+	if existing, err := ioutil.ReadFile(path); err == nil && bytes.Equal(existing, contents) {
+		return false, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), fmt.Sprintf(".%s-", filepath.Base(path)))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create temporary file")
+	}
+
+	// If anything goes wrong, don't leave our temporary file lying around
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(contents); err != nil {
+		return false, errors.Wrap(err, "failed to write temporary file")
+	}
+
+	if err = tmp.Chmod(perm); err != nil {
+		return false, errors.Wrap(err, "failed to set file permissions")
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return false, errors.Wrap(err, "failed to sync temporary file")
+	}
+
+	if err = tmp.Close(); err != nil {
+		return false, errors.Wrap(err, "failed to close temporary file")
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return false, errors.Wrap(err, "failed to rename temporary file into place")
+	}
+
+	return true, nil
+}
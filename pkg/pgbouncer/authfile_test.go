@@ -0,0 +1,124 @@
+package pgbouncer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuthFile", func() {
+	var (
+		workspace string
+		authFile  pgbouncer.AuthFile
+		err       error
+	)
+
+	BeforeEach(func() {
+		workspace, err = ioutil.TempDir("", "pgbouncer-auth-file-")
+		Expect(err).NotTo(HaveOccurred())
+
+		authFile = pgbouncer.AuthFile{Path: filepath.Join(workspace, "userlist.txt")}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(workspace)
+	})
+
+	Describe("RenderAuthFile", func() {
+		It("Sorts users and escapes quotes", func() {
+			Expect(
+				string(pgbouncer.RenderAuthFile([]pgbouncer.User{
+					{Name: "zebra", Password: `pass"word`},
+					{Name: "app", Password: "secret"},
+				})),
+			).To(
+				Equal("\"app\" \"secret\"\n\"zebra\" \"pass\"\"word\"\n"),
+			)
+		})
+	})
+
+	Describe("Write", func() {
+		var users = []pgbouncer.User{{Name: "app", Password: "secret"}}
+
+		It("Writes the file and reports a change", func() {
+			Expect(authFile.Write(users)).To(BeTrue())
+			Expect(ioutil.ReadFile(authFile.Path)).To(Equal([]byte("\"app\" \"secret\"\n")))
+		})
+
+		It("Restricts file permissions", func() {
+			Expect(authFile.Write(users)).To(BeTrue())
+
+			info, err := os.Stat(authFile.Path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		Context("When contents are unchanged", func() {
+			BeforeEach(func() {
+				Expect(authFile.Write(users)).To(BeTrue())
+			})
+
+			It("Reports no change", func() {
+				Expect(authFile.Write(users)).To(BeFalse())
+			})
+
+			It("Leaves no temporary files behind", func() {
+				Expect(authFile.Write(users)).To(BeFalse())
+				Expect(ioutil.ReadDir(workspace)).To(HaveLen(1))
+			})
+		})
+
+		Context("When a password changes", func() {
+			BeforeEach(func() {
+				Expect(authFile.Write(users)).To(BeTrue())
+			})
+
+			It("Reports a change", func() {
+				Expect(authFile.Write([]pgbouncer.User{{Name: "app", Password: "rotated"}})).To(BeTrue())
+				Expect(ioutil.ReadFile(authFile.Path)).To(Equal([]byte("\"app\" \"rotated\"\n")))
+			})
+		})
+	})
+
+	Describe("LoadUsersFromDirectory", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(workspace, "app"), []byte("secret\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(workspace, ".hidden"), []byte("ignored"), 0600)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(workspace, "nested"), 0700)).To(Succeed())
+		})
+
+		It("Loads a user per file, ignoring hidden files and directories", func() {
+			Expect(pgbouncer.LoadUsersFromDirectory(workspace)).To(
+				ConsistOf(pgbouncer.User{Name: "app", Password: "secret"}),
+			)
+		})
+
+		Context("When directory does not exist", func() {
+			It("Returns error", func() {
+				_, err := pgbouncer.LoadUsersFromDirectory("/directory/does/not/exist")
+				Expect(err).To(MatchError(MatchRegexp("failed to read auth source directory")))
+			})
+		})
+	})
+
+	Describe("ParseUsersJSON", func() {
+		It("Parses a map of user to password", func() {
+			Expect(pgbouncer.ParseUsersJSON([]byte(`{"app":"secret","reporting":"md5abc"}`))).To(
+				ConsistOf(
+					pgbouncer.User{Name: "app", Password: "secret"},
+					pgbouncer.User{Name: "reporting", Password: "md5abc"},
+				),
+			)
+		})
+
+		It("Returns error for invalid JSON", func() {
+			_, err := pgbouncer.ParseUsersJSON([]byte(`["app"]`))
+			Expect(err).To(MatchError(MatchRegexp("failed to parse users")))
+		})
+	})
+})
@@ -0,0 +1,447 @@
+package pgbouncer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// UpgradeMode selects how a running PgBouncer is replaced by a new process
+type UpgradeMode string
+
+const (
+	// UpgradeTakeover starts the new process with -R, causing it to take over the
+	// listening sockets and client connections of the running PgBouncer. The old process
+	// exits once the takeover is complete.
+	UpgradeTakeover UpgradeMode = "takeover"
+
+	// UpgradeReusePort starts a new process alongside the old, relying on so_reuseport to
+	// share the listening port. Once the new process is accepting connections, the old
+	// process is sent SIGINT so that it exits after its outstanding queries complete.
+	UpgradeReusePort UpgradeMode = "reuseport"
+)
+
+// ChildOptions configures the PgBouncer process managed by a Child
+type ChildOptions struct {
+	Binary         string
+	ConfigFile     string
+	Stderr         io.Writer
+	UpgradeMode    UpgradeMode
+	UpgradeTimeout time.Duration // time for the new process to take over from the old
+	ReadyDelay     time.Duration // time the new process must survive before replacing the old
+
+	MaxRestarts       int           // restarts permitted within the RestartWindow, 0 disables restarts
+	RestartWindow     time.Duration // window over which we apply MaxRestarts
+	RestartBackoff    time.Duration // initial wait before restarting a crashed process
+	MaxRestartBackoff time.Duration // limit for our exponentially increasing backoff
+	OnRestart         func(error)   // called with the exit error whenever we restart
+
+	// When Adopt is set, Run will first look for a PgBouncer that is already running, as
+	// identified by PidFile, and manage that process instead of starting a new one. The
+	// process must share the name of our Binary and respond to HealthCheck to be adopted.
+	// PgBouncers started with Adopt set are placed in their own process group, so they
+	// can outlive supervise. We can't capture the stderr of adopted processes, so their
+	// logs are not written to Stderr.
+	Adopt       bool
+	PidFile     string
+	HealthCheck func(context.Context) error
+}
+
+// adoptedPollInterval is how often we check that an adopted PgBouncer is still running.
+// We can't wait on processes that aren't our children, so must poll instead.
+const adoptedPollInterval = time.Second
+
+// Child runs PgBouncer as a child process, supporting replacement of the running
+// process with a new one (typically after installing a new PgBouncer binary) without
+// dropping client connections.
+type Child struct {
+	logger    kitlog.Logger
+	opt       ChildOptions
+	mu        sync.Mutex
+	ctx       context.Context
+	current   *childProcess
+	upgrading bool
+	detach    chan struct{}
+	detached  sync.Once
+}
+
+type childProcess struct {
+	pid  int
+	done chan struct{}
+	err  error
+}
+
+func NewChild(logger kitlog.Logger, opt ChildOptions) *Child {
+	if opt.Binary == "" {
+		opt.Binary = "pgbouncer"
+	}
+
+	if opt.Stderr == nil {
+		opt.Stderr = os.Stderr
+	}
+
+	return &Child{logger: logger, opt: opt, detach: make(chan struct{})}
+}
+
+// Run starts PgBouncer and blocks until it exits. Processes that exit because they have
+// been replaced by an upgrade are not considered to have exited, and Run will continue
+// to wait on their replacement. All processes are killed when the context expires.
+//
+// If MaxRestarts is non-zero, PgBouncer is restarted whenever it exits unexpectedly,
+// waiting an exponentially increasing backoff between each attempt. The restarted
+// process uses the config file as it was last rendered, so comes back pointing at the
+// current master. Run gives up if PgBouncer has been restarted more than MaxRestarts
+// times within the RestartWindow.
+func (c *Child) Run(ctx context.Context) error {
+	var (
+		backoff  = c.opt.RestartBackoff
+		restarts = []time.Time{}
+	)
+
+	for {
+		c.mu.Lock()
+		c.ctx = ctx
+
+		var proc *childProcess
+		var err error
+
+		// We only consider adoption when we first run, as any subsequent iteration is in
+		// response to the process we were managing having exited.
+		if c.opt.Adopt && len(restarts) == 0 {
+			proc = c.adopt()
+		}
+
+		if proc == nil {
+			if proc, err = c.start(); err != nil {
+				c.mu.Unlock()
+				return err
+			}
+		}
+
+		c.current = proc
+		c.mu.Unlock()
+
+		startedAt := time.Now()
+		err = c.wait(proc)
+
+		if c.isDetached() {
+			c.logger.Log("event", "detached", "pid", proc.pid, "msg", "leaving PgBouncer running")
+			return nil
+		}
+
+		if ctx.Err() != nil || c.opt.MaxRestarts == 0 {
+			return err
+		}
+
+		logger := kitlog.With(c.logger, "event", "process_exit", "uptime", time.Since(startedAt).Seconds())
+		logger = withExitStatus(logger, err)
+
+		// Only count restarts that fall within our window, so that occasional crashes over
+		// a long period don't cause us to give up.
+		recent := []time.Time{}
+		for _, restartedAt := range restarts {
+			if time.Since(restartedAt) < c.opt.RestartWindow {
+				recent = append(recent, restartedAt)
+			}
+		}
+
+		restarts = append(recent, time.Now())
+		if len(restarts) > c.opt.MaxRestarts {
+			logger.Log("msg", "PgBouncer is restarting too frequently, giving up")
+			return errors.Wrap(err, "PgBouncer exceeded maximum restart rate")
+		}
+
+		// If PgBouncer has been running for longer than our maximum backoff, consider it to
+		// have been healthy and start our backoff again from the beginning.
+		if time.Since(startedAt) > c.opt.MaxRestartBackoff {
+			backoff = c.opt.RestartBackoff
+		}
+
+		logger.Log("backoff", backoff.Seconds(), "msg", "PgBouncer exited unexpectedly, restarting after backoff")
+		if c.opt.OnRestart != nil {
+			c.opt.OnRestart(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > c.opt.MaxRestartBackoff {
+			backoff = c.opt.MaxRestartBackoff
+		}
+	}
+}
+
+// Detach causes Run to return without terminating PgBouncer, leaving it running so that
+// a future supervise can adopt it.
+func (c *Child) Detach() {
+	c.detached.Do(func() { close(c.detach) })
+}
+
+func (c *Child) isDetached() bool {
+	select {
+	case <-c.detach:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks until the given process, or any process that has replaced it, exits, or
+// until we are detached.
+func (c *Child) wait(proc *childProcess) error {
+	for {
+		select {
+		case <-proc.done:
+		case <-c.detach:
+			return nil
+		}
+
+		c.mu.Lock()
+		if c.current == proc {
+			c.mu.Unlock()
+			return proc.err
+		}
+
+		c.logger.Log("event", "process_replaced", "pid", proc.pid, "replacement", c.current.pid)
+		proc = c.current
+		c.mu.Unlock()
+	}
+}
+
+// withExitStatus annotates the logger with the exit code or terminating signal of the
+// process that produced err.
+func withExitStatus(logger kitlog.Logger, err error) kitlog.Logger {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return kitlog.With(logger, "signal", status.Signal().String())
+			}
+
+			return kitlog.With(logger, "exit_code", status.ExitStatus())
+		}
+	}
+
+	if err != nil {
+		return kitlog.With(logger, "error", err)
+	}
+
+	return kitlog.With(logger, "exit_code", 0)
+}
+
+// Upgrade replaces the running PgBouncer with a freshly started process. The binary is
+// resolved again from the PATH, so any newly installed version will be used.
+func (c *Child) Upgrade(ctx context.Context) error {
+	c.mu.Lock()
+	if c.current == nil {
+		c.mu.Unlock()
+		return errors.New("PgBouncer is not running")
+	}
+
+	if c.upgrading {
+		c.mu.Unlock()
+		return errors.New("upgrade already in progress")
+	}
+
+	c.upgrading = true
+	old := c.current
+
+	args := []string{}
+	if c.opt.UpgradeMode == UpgradeTakeover {
+		args = append(args, "-R")
+	}
+
+	next, err := c.start(args...)
+	if err != nil {
+		c.upgrading = false
+		c.mu.Unlock()
+		return err
+	}
+
+	// Mark our new process as current before the old process can exit, ensuring Run
+	// treats the exit as a handover.
+	c.current = next
+	c.mu.Unlock()
+
+	logger := kitlog.With(c.logger, "mode", c.opt.UpgradeMode, "pid", old.pid, "replacement", next.pid)
+	logger.Log("event", "upgrade_start")
+
+	err = c.handover(ctx, old, next)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.upgrading = false
+	if err != nil {
+		logger.Log("event", "upgrade_failed", "error", err)
+		syscall.Kill(next.pid, syscall.SIGKILL)
+		c.current = old
+
+		return err
+	}
+
+	logger.Log("event", "upgrade_complete")
+	return nil
+}
+
+func (c *Child) handover(ctx context.Context, old, next *childProcess) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opt.UpgradeTimeout)
+	defer cancel()
+
+	switch c.opt.UpgradeMode {
+	case UpgradeTakeover:
+		select {
+		case <-old.done:
+			return nil
+		case <-next.done:
+			return errors.Wrap(next.err, "new PgBouncer exited before taking over")
+		case <-ctx.Done():
+			return errors.New("timed out waiting for new PgBouncer to take over")
+		}
+
+	case UpgradeReusePort:
+		select {
+		case <-time.After(c.opt.ReadyDelay):
+		case <-next.done:
+			return errors.Wrap(next.err, "new PgBouncer exited before becoming ready")
+		case <-ctx.Done():
+			return errors.New("timed out waiting for new PgBouncer to become ready")
+		}
+
+		return syscall.Kill(old.pid, syscall.SIGINT)
+	}
+
+	return errors.Errorf("unsupported upgrade mode: %s", c.opt.UpgradeMode)
+}
+
+// start must be called with the mutex held
+func (c *Child) start(args ...string) (*childProcess, error) {
+	binary, err := exec.LookPath(c.opt.Binary)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find PgBouncer binary")
+	}
+
+	cmd := exec.CommandContext(c.ctx, binary, append(args, c.opt.ConfigFile)...)
+	cmd.Stderr = c.opt.Stderr
+
+	if c.opt.Adopt {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to start PgBouncer")
+	}
+
+	c.logger.Log("event", "process_start", "binary", binary, "pid", cmd.Process.Pid)
+
+	proc := &childProcess{pid: cmd.Process.Pid, done: make(chan struct{})}
+	go func() {
+		proc.err = cmd.Wait()
+		close(proc.done)
+	}()
+
+	return proc, nil
+}
+
+// adopt looks for a running PgBouncer identified by our PidFile, returning a process we
+// can manage if it is alive and healthy. Like the processes we start, adopted processes
+// are killed when our context expires, so we confirm the pid belongs to PgBouncer and
+// not some unrelated process that has recycled it. Must be called with the mutex held.
+func (c *Child) adopt() *childProcess {
+	logger := kitlog.With(c.logger, "event", "adopt", "pid_file", c.opt.PidFile)
+
+	contents, err := ioutil.ReadFile(c.opt.PidFile)
+	if err != nil {
+		logger.Log("error", err, "msg", "no PgBouncer to adopt")
+		return nil
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		logger.Log("error", err, "msg", "failed to parse pid file")
+		return nil
+	}
+
+	logger = kitlog.With(logger, "pid", pid)
+	if err := syscall.Kill(pid, 0); err != nil {
+		logger.Log("error", err, "msg", "PgBouncer from pid file is not running")
+		return nil
+	}
+
+	name, err := processName(pid)
+	if err != nil {
+		logger.Log("error", err, "msg", "failed to identify process from pid file, refusing to adopt")
+		return nil
+	}
+
+	if expected := commName(c.opt.Binary); name != expected {
+		logger.Log("process", name, "expected", expected, "msg", "pid file references another process, refusing to adopt")
+		return nil
+	}
+
+	if c.opt.HealthCheck != nil {
+		if err := c.opt.HealthCheck(c.ctx); err != nil {
+			logger.Log("error", err, "msg", "PgBouncer is running but not healthy, refusing to adopt")
+			return nil
+		}
+	}
+
+	logger.Log("msg", "adopted running PgBouncer")
+
+	proc := &childProcess{pid: pid, done: make(chan struct{})}
+	go func() {
+		defer close(proc.done)
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				syscall.Kill(pid, syscall.SIGKILL)
+				proc.err = c.ctx.Err()
+				return
+			case <-time.After(adoptedPollInterval):
+			}
+
+			if err := syscall.Kill(pid, 0); err != nil {
+				proc.err = errors.Errorf("adopted PgBouncer (pid %d) is no longer running", pid)
+				return
+			}
+		}
+	}()
+
+	return proc
+}
+
+// processName returns the executable name of the process, as reported by procfs
+func processName(pid int) (string, error) {
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read process name")
+	}
+
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// commName is the name procfs reports for processes of the binary, which the kernel
+// truncates to 15 characters
+func commName(binary string) string {
+	name := filepath.Base(binary)
+	if len(name) > 15 {
+		name = name[:15]
+	}
+
+	return name
+}
@@ -0,0 +1,260 @@
+package pgbouncer_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakePgBouncer emulates the process lifecycle of PgBouncer: it records its pid, and
+// when asked to takeover (-R) it terminates the process that came before it. Creating a
+// file called fail in the same directory causes takeovers to fail. We sleep through a
+// link named pgbouncer, so the process is reported with the name of the real binary.
+const fakePgBouncer = `#!/bin/sh
+dir=$(dirname "$0")
+if [ "$1" = "-R" ]; then
+  [ -f "$dir/fail" ] && exit 1
+  kill $(cat "$dir/pid")
+fi
+echo $$ > "$dir/pid"
+exec "$dir/bin/pgbouncer" 1000
+`
+
+var _ = Describe("Child", func() {
+	var (
+		ctx       context.Context
+		cancel    func()
+		workspace string
+		opt       pgbouncer.ChildOptions
+		child     *pgbouncer.Child
+		exited    chan error
+		stopped   chan struct{}
+		err       error
+	)
+
+	readPid := func() int {
+		contents, err := ioutil.ReadFile(filepath.Join(workspace, "pid"))
+		if err != nil {
+			return 0
+		}
+
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(contents)))
+		return pid
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+
+		workspace, err = ioutil.TempDir("", "pgbouncer-child-")
+		Expect(err).NotTo(HaveOccurred())
+
+		binary := filepath.Join(workspace, "pgbouncer")
+		Expect(ioutil.WriteFile(binary, []byte(fakePgBouncer), 0755)).To(Succeed())
+
+		sleep, err := exec.LookPath("sleep")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Mkdir(filepath.Join(workspace, "bin"), 0755)).To(Succeed())
+		Expect(os.Symlink(sleep, filepath.Join(workspace, "bin", "pgbouncer"))).To(Succeed())
+
+		opt = pgbouncer.ChildOptions{
+			Binary:         binary,
+			ConfigFile:     filepath.Join(workspace, "pgbouncer.ini"),
+			Stderr:         GinkgoWriter,
+			UpgradeMode:    pgbouncer.UpgradeTakeover,
+			UpgradeTimeout: 5 * time.Second,
+			ReadyDelay:     100 * time.Millisecond,
+		}
+	})
+
+	JustBeforeEach(func() {
+		child = pgbouncer.NewChild(kitlog.NewLogfmtLogger(GinkgoWriter), opt)
+		exited, stopped = make(chan error, 1), make(chan struct{})
+		go func() { exited <- child.Run(ctx); close(stopped) }()
+
+		Eventually(readPid).ShouldNot(BeZero())
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+		os.RemoveAll(workspace)
+	})
+
+	It("Returns when the process exits", func() {
+		Expect(syscall.Kill(readPid(), syscall.SIGKILL)).To(Succeed())
+		Eventually(exited).Should(Receive(HaveOccurred()))
+	})
+
+	Context("With restarts enabled", func() {
+		var restarts chan error
+
+		BeforeEach(func() {
+			restarts = make(chan error, 10)
+
+			opt.MaxRestarts = 2
+			opt.RestartWindow = time.Minute
+			opt.RestartBackoff = 10 * time.Millisecond
+			opt.MaxRestartBackoff = 50 * time.Millisecond
+			opt.OnRestart = func(err error) { restarts <- err }
+		})
+
+		It("Restarts the process when it exits", func() {
+			oldPid := readPid()
+			Expect(syscall.Kill(oldPid, syscall.SIGKILL)).To(Succeed())
+
+			Eventually(restarts).Should(Receive(MatchError("signal: killed")))
+			Eventually(readPid).ShouldNot(Equal(oldPid))
+			Consistently(exited, 200*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("Gives up when exceeding the maximum restart rate", func() {
+			for attempt := 0; attempt <= opt.MaxRestarts; attempt++ {
+				pid := readPid()
+				Expect(syscall.Kill(pid, syscall.SIGKILL)).To(Succeed())
+				Eventually(func() bool { return readPid() != pid || len(exited) > 0 }).Should(BeTrue())
+			}
+
+			Eventually(exited).Should(Receive(MatchError(MatchRegexp("exceeded maximum restart rate"))))
+			Expect(restarts).To(HaveLen(opt.MaxRestarts))
+		})
+	})
+
+	Describe("Detach", func() {
+		It("Returns without terminating the process", func() {
+			child.Detach()
+
+			Eventually(exited).Should(Receive(BeNil()))
+			Expect(syscall.Kill(readPid(), 0)).To(Succeed())
+
+			syscall.Kill(readPid(), syscall.SIGKILL)
+		})
+	})
+
+	Context("With adopt", func() {
+		var existing *exec.Cmd
+
+		BeforeEach(func() {
+			opt.Adopt = true
+			opt.PidFile = filepath.Join(workspace, "pid")
+		})
+
+		AfterEach(func() {
+			if existing != nil {
+				existing.Process.Kill()
+			}
+		})
+
+		Context("When PgBouncer is already running", func() {
+			var existingExited chan struct{}
+
+			BeforeEach(func() {
+				existing = exec.Command(opt.Binary, opt.ConfigFile)
+				Expect(existing.Start()).To(Succeed())
+
+				// Reap the process once it exits, as the init process would for a PgBouncer
+				// orphaned by a previous supervise.
+				existingExited = make(chan struct{})
+				go func() { existing.Wait(); close(existingExited) }()
+
+				Eventually(readPid).Should(Equal(existing.Process.Pid))
+			})
+
+			It("Adopts the running process", func() {
+				Consistently(readPid, 200*time.Millisecond).Should(Equal(existing.Process.Pid))
+
+				Expect(existing.Process.Kill()).To(Succeed())
+				Eventually(existingExited).Should(BeClosed())
+				Eventually(exited, 5*time.Second).Should(Receive(MatchError(MatchRegexp("no longer running"))))
+			})
+
+			Context("When unhealthy", func() {
+				BeforeEach(func() {
+					opt.HealthCheck = func(context.Context) error { return fmt.Errorf("unhealthy") }
+				})
+
+				It("Starts a new process", func() {
+					Eventually(readPid).ShouldNot(Equal(existing.Process.Pid))
+				})
+			})
+		})
+
+		Context("When pid file references a process that isn't PgBouncer", func() {
+			BeforeEach(func() {
+				existing = exec.Command("sleep", "1000")
+				Expect(existing.Start()).To(Succeed())
+				go existing.Wait()
+
+				Expect(ioutil.WriteFile(opt.PidFile, []byte(strconv.Itoa(existing.Process.Pid)), 0644)).To(Succeed())
+			})
+
+			It("Starts a new process, leaving the other alone", func() {
+				Eventually(readPid).ShouldNot(Equal(existing.Process.Pid))
+				Expect(syscall.Kill(existing.Process.Pid, 0)).To(Succeed())
+			})
+		})
+
+		Context("When pid file references a dead process", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(opt.PidFile, []byte("999999\n"), 0644)).To(Succeed())
+			})
+
+			It("Starts a new process", func() {
+				Eventually(readPid).ShouldNot(Equal(999999))
+			})
+		})
+	})
+
+	Describe("Upgrade", func() {
+		Context("With takeover", func() {
+			It("Continues running with the new process", func() {
+				oldPid := readPid()
+
+				Expect(child.Upgrade(ctx)).To(Succeed())
+				Expect(readPid()).NotTo(Equal(oldPid))
+				Consistently(exited, 200*time.Millisecond).ShouldNot(Receive())
+
+				Expect(syscall.Kill(readPid(), syscall.SIGKILL)).To(Succeed())
+				Eventually(exited).Should(Receive(HaveOccurred()))
+			})
+
+			Context("When the new process fails", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(filepath.Join(workspace, "fail"), []byte{}, 0644)).To(Succeed())
+				})
+
+				It("Returns error and keeps the old process", func() {
+					oldPid := readPid()
+
+					Expect(child.Upgrade(ctx)).To(MatchError(MatchRegexp("exited before taking over")))
+					Expect(syscall.Kill(oldPid, 0)).To(Succeed())
+					Consistently(exited, 200*time.Millisecond).ShouldNot(Receive())
+				})
+			})
+		})
+
+		Context("With reuseport", func() {
+			BeforeEach(func() { opt.UpgradeMode = pgbouncer.UpgradeReusePort })
+
+			It("Interrupts the old process once the new is ready", func() {
+				oldPid := readPid()
+
+				Expect(child.Upgrade(ctx)).To(Succeed())
+				Eventually(func() error { return syscall.Kill(oldPid, 0) }).Should(HaveOccurred())
+				Consistently(exited, 200*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+})
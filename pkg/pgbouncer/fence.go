@@ -0,0 +1,167 @@
+package pgbouncer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// FenceMode determines how we stop PgBouncer routing traffic when fenced
+type FenceMode string
+
+const (
+	// FencePause issues a PAUSE, buffering client queries until we resume
+	FencePause FenceMode = "pause"
+	// FenceDisable issues DISABLE for every database, rejecting new client connections
+	// while allowing existing ones to continue
+	FenceDisable FenceMode = "disable"
+)
+
+type fenceTarget interface {
+	Pause(context.Context) error
+	Resume(context.Context, ...string) error
+	Disable(context.Context, ...string) error
+	Enable(context.Context, ...string) error
+}
+
+// FenceOptions configures a Fence
+type FenceOptions struct {
+	Mode FenceMode
+	// Timeout is how long we can go without a confirmed master before fencing
+	Timeout time.Duration
+	// Interval at which we check whether to fence, and retry failed operations
+	Interval time.Duration
+	// OperationTimeout is applied to each PgBouncer operation
+	OperationTimeout time.Duration
+	// OnTransition is called whenever we successfully fence or unfence PgBouncer
+	OnTransition func(fenced bool)
+}
+
+// Fence stops PgBouncer routing traffic once we've gone too long without confirming a
+// healthy master, either because the clusterdata has no master or because we've lost
+// contact with the store. Without this, a partitioned proxy would continue routing
+// writes to a primary that may have since been demoted.
+type Fence struct {
+	logger  kitlog.Logger
+	bouncer fenceTarget
+	opt     FenceOptions
+	wake    chan struct{}
+
+	mu            sync.Mutex
+	hasMaster     bool
+	lastConfirmed time.Time
+
+	// Only accessed from the Run goroutine
+	fenced, applied bool
+}
+
+// NewFence constructs a Fence that considers the master confirmed at the time it is
+// created, giving us the fence timeout to receive our first clusterdata.
+func NewFence(logger kitlog.Logger, bouncer fenceTarget, opt FenceOptions) *Fence {
+	return &Fence{
+		logger:        logger,
+		bouncer:       bouncer,
+		opt:           opt,
+		wake:          make(chan struct{}, 1),
+		lastConfirmed: time.Now(),
+		applied:       true,
+	}
+}
+
+// Contact records that we've heard from the store. This confirms the master only if the
+// last clusterdata we observed had a healthy master.
+func (f *Fence) Contact() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.hasMaster {
+		f.confirm()
+	}
+}
+
+// Observe records whether PgBouncer is routing to a healthy master, according to the
+// latest clusterdata.
+func (f *Fence) Observe(healthyMaster bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.hasMaster = healthyMaster
+	if healthyMaster {
+		f.confirm()
+	}
+}
+
+// confirm must be called with the lock held. We wake the Run loop so that we unfence
+// as soon as possible.
+func (f *Fence) confirm() {
+	f.lastConfirmed = time.Now()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run periodically checks whether we should fence or unfence PgBouncer, returning once
+// the context is cancelled.
+func (f *Fence) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-f.wake:
+		case <-time.After(f.opt.Interval):
+		}
+
+		f.reconcile(ctx)
+	}
+}
+
+func (f *Fence) reconcile(ctx context.Context) {
+	f.mu.Lock()
+	unconfirmed := time.Since(f.lastConfirmed)
+	f.mu.Unlock()
+
+	fence := unconfirmed > f.opt.Timeout
+	if fence == f.fenced && f.applied {
+		return
+	}
+
+	// We consider ourselves fenced from the moment we first attempt it, as a PAUSE that
+	// times out may still take effect and must be reversed once we unfence.
+	f.fenced, f.applied = fence, false
+
+	ctx, cancel := context.WithTimeout(ctx, f.opt.OperationTimeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case fence && f.opt.Mode == FenceDisable:
+		err = f.bouncer.Disable(ctx)
+	case fence:
+		err = f.bouncer.Pause(ctx)
+	case f.opt.Mode == FenceDisable:
+		err = f.bouncer.Enable(ctx)
+	default:
+		err = f.bouncer.Resume(ctx)
+	}
+
+	if err != nil {
+		f.logger.Log("error", err, "fenced", fence, "msg", "failed to apply fence, will retry")
+		return
+	}
+
+	f.applied = true
+	if fence {
+		f.logger.Log("event", "fence", "mode", f.opt.Mode, "unconfirmed", unconfirmed.Seconds(),
+			"msg", "no confirmed master, fencing PgBouncer")
+	} else {
+		f.logger.Log("event", "unfence", "mode", f.opt.Mode, "msg", "master confirmed, unfencing PgBouncer")
+	}
+
+	if f.opt.OnTransition != nil {
+		f.opt.OnTransition(fence)
+	}
+}
@@ -0,0 +1,159 @@
+package pgbouncer_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeFenceTarget struct {
+	sync.Mutex
+	calls []string
+	err   error
+}
+
+func (f *fakeFenceTarget) record(call string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, call)
+
+	return f.err
+}
+
+func (f *fakeFenceTarget) Calls() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	return append([]string{}, f.calls...)
+}
+
+func (f *fakeFenceTarget) Pause(context.Context) error              { return f.record("pause") }
+func (f *fakeFenceTarget) Resume(context.Context, ...string) error  { return f.record("resume") }
+func (f *fakeFenceTarget) Disable(context.Context, ...string) error { return f.record("disable") }
+func (f *fakeFenceTarget) Enable(context.Context, ...string) error  { return f.record("enable") }
+
+var _ = Describe("Fence", func() {
+	var (
+		ctx         context.Context
+		cancel      func()
+		target      *fakeFenceTarget
+		opt         pgbouncer.FenceOptions
+		fence       *pgbouncer.Fence
+		transitions chan bool
+		stopped     chan struct{}
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		target = &fakeFenceTarget{}
+		transitions = make(chan bool, 10)
+		opt = pgbouncer.FenceOptions{
+			Mode:             pgbouncer.FencePause,
+			Timeout:          100 * time.Millisecond,
+			Interval:         10 * time.Millisecond,
+			OperationTimeout: time.Second,
+			OnTransition:     func(fenced bool) { transitions <- fenced },
+		}
+	})
+
+	JustBeforeEach(func() {
+		fence = pgbouncer.NewFence(kitlog.NewNopLogger(), target, opt)
+		stopped = make(chan struct{})
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(stopped)
+			Expect(fence.Run(ctx)).To(Succeed())
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("Does not fence while the master is confirmed by store contact", func() {
+		fence.Observe(true)
+		for i := 0; i < 20; i++ {
+			fence.Contact()
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		Expect(target.Calls()).To(BeEmpty())
+	})
+
+	It("Pauses without a master, and resumes once a healthy master returns", func() {
+		fence.Observe(false)
+		Eventually(transitions).Should(Receive(BeTrue()))
+		Expect(target.Calls()).To(Equal([]string{"pause"}))
+
+		// Store contact alone shouldn't unfence us, as we still have no master
+		fence.Contact()
+		Consistently(transitions, 50*time.Millisecond).ShouldNot(Receive())
+
+		fence.Observe(true)
+		Eventually(transitions).Should(Receive(BeFalse()))
+		Expect(target.Calls()).To(Equal([]string{"pause", "resume"}))
+	})
+
+	It("Fences once a new master we can't confirm replaces the old, despite store contact", func() {
+		fence.Observe(true)
+		fence.Contact()
+
+		// supervise observes no healthy master whenever it keeps routing to the old master,
+		// such as when it fails to confirm the new one
+		start := time.Now()
+		fence.Observe(false)
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(10 * time.Millisecond):
+					fence.Contact()
+				}
+			}
+		}()
+
+		Eventually(transitions).Should(Receive(BeTrue()))
+		Expect(time.Since(start)).To(BeNumerically(">=", opt.Timeout))
+		Expect(target.Calls()).To(Equal([]string{"pause"}))
+	})
+
+	Context("With disable mode", func() {
+		BeforeEach(func() { opt.Mode = pgbouncer.FenceDisable })
+
+		It("Disables and enables databases", func() {
+			Eventually(transitions).Should(Receive(BeTrue()))
+			fence.Observe(true)
+			Eventually(transitions).Should(Receive(BeFalse()))
+
+			Expect(target.Calls()).To(Equal([]string{"disable", "enable"}))
+		})
+	})
+
+	Context("When PgBouncer operations fail", func() {
+		BeforeEach(func() { target.err = errors.New("pgbouncer unavailable") })
+
+		It("Retries until successful", func() {
+			Eventually(func() int { return len(target.Calls()) }).Should(BeNumerically(">=", 3))
+			Expect(transitions).NotTo(Receive())
+
+			target.Lock()
+			target.err = nil
+			target.Unlock()
+
+			Eventually(transitions).Should(Receive(BeTrue()))
+		})
+	})
+})
@@ -11,6 +11,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
 )
 
 var _ = Describe("PgBouncer", func() {
@@ -95,6 +96,81 @@ var _ = Describe("PgBouncer", func() {
 			})
 		})
 
+		Describe("ShowPools", func() {
+			It("Reports active clients of the session pool", func() {
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+
+				Expect(bouncer.ShowPools(ctx)).To(ContainElement(
+					pgbouncer.Pool{Database: database, User: user, PoolMode: "session", ActiveClients: 1},
+				))
+			})
+		})
+
+		Describe("ShowClients", func() {
+			It("Reports connected clients", func() {
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+
+				Expect(conn.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
+
+				clients, err := bouncer.ShowClients(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				var found bool
+				for _, client := range clients {
+					if client.Database == database && client.User == user {
+						found = true
+						Expect(client.ConnectTime).To(BeTemporally("~", time.Now(), 5*time.Second))
+						Expect(client.Linked).To(BeTrue(), "session clients hold their server")
+					}
+				}
+
+				Expect(found).To(BeTrue(), "expected to find our client")
+			})
+		})
+
+		Describe("ShowServers", func() {
+			It("Reports the backend pid of active servers", func() {
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+
+				var pid int64
+				Expect(conn.QueryRowEx(ctx, "select pg_backend_pid()::bigint", nil).Scan(&pid)).To(Succeed())
+
+				servers, err := bouncer.ShowServers(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(servers).To(ContainElement(MatchFields(IgnoreExtras, Fields{
+					"Database":  Equal(database),
+					"User":      Equal(user),
+					"State":     Equal("active"),
+					"RemotePID": Equal(pid),
+				})))
+			})
+
+			It("Identifies the server each client is linked to", func() {
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+
+				var pid int64
+				Expect(conn.QueryRowEx(ctx, "select pg_backend_pid()::bigint", nil).Scan(&pid)).To(Succeed())
+
+				servers, err := bouncer.ShowServers(ctx)
+				Expect(err).NotTo(HaveOccurred())
+
+				links := map[string]int64{}
+				for _, server := range servers {
+					links[server.Ptr] = server.RemotePID
+				}
+
+				clients, err := bouncer.ShowClients(ctx)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(clients).To(ContainElement(WithTransform(
+					func(client pgbouncer.Client) int64 { return links[client.Link] }, Equal(pid),
+				)))
+			})
+		})
+
 		Describe("Disable", func() {
 			It("Prevents new client connections", func() {
 				// Create a connection prior to the disable so we can check the bahviour
@@ -118,6 +194,36 @@ var _ = Describe("PgBouncer", func() {
 				Expect(original.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
 			})
 		})
+
+		Describe("Enable", func() {
+			It("Allows new client connections after disable", func() {
+				Expect(bouncer.Disable(ctx)).To(Succeed())
+				Expect(bouncer.Enable(ctx)).To(Succeed())
+
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+			})
+		})
+
+		Describe("Kill", func() {
+			It("Drops existing connections until the database is resumed", func() {
+				conn := mustConnectToDatabase()
+				defer conn.Close()
+
+				Expect(conn.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
+				Expect(bouncer.Kill(ctx, database)).To(Succeed())
+
+				_, err := conn.ExecEx(ctx, "select now()", nil)
+				Expect(err).To(HaveOccurred())
+
+				Expect(bouncer.Resume(ctx, database)).To(Succeed())
+
+				anotherConn := mustConnectToDatabase()
+				defer anotherConn.Close()
+
+				Expect(anotherConn.ExecEx(ctx, "select now()", nil)).NotTo(BeNil())
+			})
+		})
 	})
 
 	Describe("Reload", func() {
@@ -211,4 +317,19 @@ var _ = Describe("PgBouncer", func() {
 			})
 		})
 	})
+
+	Describe("Version", func() {
+		It("Returns the PgBouncer version", func() {
+			Expect(bouncer.Version(ctx)).To(HavePrefix("PgBouncer"))
+		})
+	})
+
+	Describe("Suspend", func() {
+		It("Succeeds when already suspended", func() {
+			Expect(bouncer.Suspend(ctx)).To(Succeed())
+			Expect(bouncer.Suspend(ctx)).To(Succeed())
+
+			Eventually(readlogs).Should(ContainSubstring("LOG SUSPEND command issued"))
+		})
+	})
 })
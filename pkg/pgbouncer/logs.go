@@ -0,0 +1,161 @@
+package pgbouncer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// LogEntry is a parsed line of PgBouncer log output, such as:
+//
+//	2020-06-01 10:00:00.123 UTC [123] LOG C-0x5581: app/alice@10.0.0.1:51234 login attempt: db=app user=alice tls=no
+type LogEntry struct {
+	Timestamp  string
+	Pid        string
+	Level      string // one of FATAL, ERROR, WARNING, LOG, DEBUG or NOISE
+	Connection string // client (C-0x...) or server (S-0x...) connection identifier
+	Database   string
+	User       string
+	Address    string
+	Message    string
+}
+
+var (
+	// Older PgBouncers log the pid without brackets, and may omit the timezone
+	logLinePattern = regexp.MustCompile(
+		`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?(?: [A-Z]+)?) \[?(\d+)\]? (FATAL|ERROR|WARNING|LOG|DEBUG|NOISE) (.*)$`,
+	)
+	logConnectionPattern = regexp.MustCompile(
+		`^([CS]-0x[0-9a-f]+): (?:(\S*)/(\S*)@(\S+?):? )?(.*)$`,
+	)
+	logAgePattern = regexp.MustCompile(`\s*\(age=[^)]*\)$`)
+)
+
+// ParseLogLine parses a single line of PgBouncer log output, returning false if the line
+// isn't in the expected format.
+func ParseLogLine(line string) (LogEntry, bool) {
+	match := logLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		return LogEntry{}, false
+	}
+
+	entry := LogEntry{Timestamp: match[1], Pid: match[2], Level: match[3], Message: match[4]}
+	if conn := logConnectionPattern.FindStringSubmatch(entry.Message); conn != nil {
+		entry.Connection, entry.Database, entry.User, entry.Address = conn[1], conn[2], conn[3], conn[4]
+		entry.Message = conn[5]
+	}
+
+	return entry, true
+}
+
+// ClosingReason returns the reason PgBouncer gave for closing a connection, with any
+// details specific to the connection removed so the reason can be aggregated.
+func (e LogEntry) ClosingReason() (string, bool) {
+	reason := trimPrefix(e.Message, "closing because: ")
+	if reason == "" {
+		return "", false
+	}
+
+	return summarise(logAgePattern.ReplaceAllString(reason, "")), true
+}
+
+// PoolerError returns the error PgBouncer reported to the client, summarised so that it
+// can be aggregated.
+func (e LogEntry) PoolerError() (string, bool) {
+	reason := trimPrefix(e.Message, "pooler error: ")
+	if reason == "" {
+		return "", false
+	}
+
+	return summarise(reason), true
+}
+
+// IsLoginFailure returns true if the entry records a client failing to authenticate
+func (e LogEntry) IsLoginFailure() bool {
+	for _, substr := range []string{"password authentication failed", "login failed", "no such user"} {
+		if strings.Contains(e.Message, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func trimPrefix(str, prefix string) string {
+	if !strings.HasPrefix(str, prefix) {
+		return ""
+	}
+
+	return strings.TrimPrefix(str, prefix)
+}
+
+// summarise removes everything after the first colon, which is where PgBouncer places
+// details such as user or database names.
+func summarise(reason string) string {
+	return strings.TrimSpace(strings.SplitN(reason, ":", 2)[0])
+}
+
+// NewLogWriter returns a writer that parses PgBouncer log output line by line, logging
+// each entry via the given logger at a level matching PgBouncer's. Each parsed entry is
+// passed to observe, enabling callers to count notable events. Closing the writer stops
+// the parser.
+func NewLogWriter(logger kitlog.Logger, observe func(LogEntry)) io.WriteCloser {
+	reader, writer := io.Pipe()
+
+	// We read lines without any length limit, as failing to consume the output would
+	// eventually block PgBouncer on writing its logs.
+	go func() {
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if line = strings.TrimRight(line, "\r\n"); line != "" {
+				if entry, ok := ParseLogLine(line); ok {
+					logEntry(logger, entry)
+					if observe != nil {
+						observe(entry)
+					}
+				} else {
+					level.Info(logger).Log("event", "unparsed_log", "msg", line)
+				}
+			}
+
+			if err != nil {
+				reader.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return writer
+}
+
+func logEntry(logger kitlog.Logger, entry LogEntry) {
+	switch entry.Level {
+	case "FATAL", "ERROR":
+		logger = level.Error(logger)
+	case "WARNING":
+		logger = level.Warn(logger)
+	case "LOG":
+		logger = level.Info(logger)
+	default:
+		logger = level.Debug(logger)
+	}
+
+	keyvals := []interface{}{"event", "log", "pgbouncer_pid", entry.Pid}
+	for _, field := range []struct{ key, value string }{
+		{"connection", entry.Connection},
+		{"database", entry.Database},
+		{"user", entry.User},
+		{"address", entry.Address},
+	} {
+		if field.value != "" {
+			keyvals = append(keyvals, field.key, field.value)
+		}
+	}
+
+	logger.Log(append(keyvals, "msg", entry.Message)...)
+}
@@ -0,0 +1,113 @@
+package pgbouncer_test
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Logs", func() {
+	Describe("ParseLogLine", func() {
+		It("Parses client connection entries", func() {
+			entry, ok := pgbouncer.ParseLogLine(
+				"2020-06-01 10:00:00.123 UTC [123] LOG C-0x5581f0: app/alice@10.0.0.1:51234 login attempt: db=app user=alice tls=no",
+			)
+
+			Expect(ok).To(BeTrue())
+			Expect(entry).To(Equal(pgbouncer.LogEntry{
+				Timestamp:  "2020-06-01 10:00:00.123 UTC",
+				Pid:        "123",
+				Level:      "LOG",
+				Connection: "C-0x5581f0",
+				Database:   "app",
+				User:       "alice",
+				Address:    "10.0.0.1:51234",
+				Message:    "login attempt: db=app user=alice tls=no",
+			}))
+		})
+
+		It("Parses entries without connections, from older PgBouncers", func() {
+			entry, ok := pgbouncer.ParseLogLine("2020-06-01 10:00:00.123 123 WARNING process up: PgBouncer 1.9.0")
+
+			Expect(ok).To(BeTrue())
+			Expect(entry.Level).To(Equal("WARNING"))
+			Expect(entry.Pid).To(Equal("123"))
+			Expect(entry.Connection).To(BeEmpty())
+			Expect(entry.Message).To(Equal("process up: PgBouncer 1.9.0"))
+		})
+
+		It("Rejects unknown formats", func() {
+			_, ok := pgbouncer.ParseLogLine("something unexpected")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("LogEntry", func() {
+		parse := func(message string) pgbouncer.LogEntry {
+			entry, ok := pgbouncer.ParseLogLine(fmt.Sprintf("2020-06-01 10:00:00.123 UTC [1] LOG C-0x1: app/alice@10.0.0.1:5000 %s", message))
+			Expect(ok).To(BeTrue())
+
+			return entry
+		}
+
+		It("Extracts closing reasons without connection details", func() {
+			reason, ok := parse("closing because: client close request (age=12s)").ClosingReason()
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal("client close request"))
+
+			reason, ok = parse(`closing because: login failed: FATAL: no such user "bob" (age=0s)`).ClosingReason()
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal("login failed"))
+		})
+
+		It("Extracts pooler errors", func() {
+			reason, ok := parse("pooler error: no such database: missing").PoolerError()
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal("no such database"))
+
+			_, ok = parse("login attempt: db=app").PoolerError()
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Detects login failures", func() {
+			Expect(parse("pooler error: password authentication failed").IsLoginFailure()).To(BeTrue())
+			Expect(parse("login attempt: db=app user=alice").IsLoginFailure()).To(BeFalse())
+		})
+	})
+
+	Describe("NewLogWriter", func() {
+		It("Logs with levels and observes each entry", func() {
+			var (
+				output   = gbytes.NewBuffer()
+				mu       sync.Mutex
+				observed []pgbouncer.LogEntry
+			)
+
+			writer := pgbouncer.NewLogWriter(
+				kitlog.NewLogfmtLogger(output),
+				func(entry pgbouncer.LogEntry) {
+					mu.Lock()
+					defer mu.Unlock()
+					observed = append(observed, entry)
+				},
+			)
+
+			fmt.Fprintln(writer, "2020-06-01 10:00:00.123 UTC [1] ERROR C-0x1: app/alice@10.0.0.1:5000 pooler error: no such database: app")
+			fmt.Fprintln(writer, "not a pgbouncer line")
+			writer.Close()
+
+			Eventually(func() int { mu.Lock(); defer mu.Unlock(); return len(observed) }).Should(Equal(1))
+			Eventually(output).Should(gbytes.Say(regexp.QuoteMeta(
+				`level=error event=log pgbouncer_pid=1 connection=C-0x1 database=app user=alice address=10.0.0.1:5000 msg="pooler error: no such database: app"`,
+			)))
+			Eventually(output).Should(gbytes.Say(regexp.QuoteMeta(`level=info event=unparsed_log msg="not a pgbouncer line"`)))
+		})
+	})
+})
@@ -6,10 +6,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"text/template"
 
 	"github.com/jackc/pgx"
 	"github.com/pkg/errors"
@@ -79,7 +80,17 @@ func (b *PgBouncer) createTemplate() (*template.Template, error) {
 		)
 	}
 
-	return template.Must(template.New("PgBouncerConfig").Parse(string(configTemplate))), err
+	// Name the template after the file so parse errors identify the file and line number,
+	// such as "template: pgbouncer.ini.template:3: function "foo" not defined".
+	tmpl, err := template.New(filepath.Base(b.ConfigTemplateFile)).
+		Funcs(TemplateFuncs).
+		Parse(string(configTemplate))
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse PgBouncer config template")
+	}
+
+	return tmpl, nil
 }
 
 type Database struct {
@@ -169,11 +180,39 @@ func (b *PgBouncer) Pause(ctx context.Context) error {
 	return nil
 }
 
-// Resume will remove any applied pauses to PgBouncer
-func (b *PgBouncer) Resume(ctx context.Context) error {
-	if err := b.Executor.Execute(ctx, `RESUME;`); err != nil {
+// Resume will remove any applied pauses to PgBouncer. If databases are supplied then
+// only those databases are resumed, such as after a Kill.
+func (b *PgBouncer) Resume(ctx context.Context, databases ...string) error {
+	commands := []string{`RESUME;`}
+	if len(databases) > 0 {
+		commands = []string{}
+		for _, database := range databases {
+			commands = append(commands, fmt.Sprintf(`RESUME %s;`, database))
+		}
+	}
+
+	for _, command := range commands {
+		if err := b.Executor.Execute(ctx, command); err != nil {
+			if err, ok := err.(pgx.PgError); ok {
+				if string(err.Code) == PoolerError && err.Message == AlreadyResumedError {
+					continue
+				}
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Suspend flushes all socket buffers and stops PgBouncer listening for data on them,
+// preparing for an online restart. Like Pause, it is safe to call when already
+// suspended.
+func (b *PgBouncer) Suspend(ctx context.Context) error {
+	if err := b.Executor.Execute(ctx, `SUSPEND;`); err != nil {
 		if err, ok := err.(pgx.PgError); ok {
-			if string(err.Code) == PoolerError && err.Message == AlreadyResumedError {
+			if string(err.Code) == PoolerError && err.Message == AlreadyPausedError {
 				return nil
 			}
 		}
@@ -184,9 +223,43 @@ func (b *PgBouncer) Resume(ctx context.Context) error {
 	return nil
 }
 
+// Reconnect closes each server connection to the given databases as soon as it is
+// released, causing PgBouncer to open new connections to the currently configured host.
+// If no databases are supplied then this operation will apply to all PgBouncer
+// databases. Requires PgBouncer 1.15 or later.
+func (b *PgBouncer) Reconnect(ctx context.Context, databases ...string) error {
+	return b.executeForDatabases(ctx, `RECONNECT %s;`, databases...)
+}
+
+// Kill immediately drops all client and server connections to the given databases. New
+// clients will wait until the databases are resumed. If no databases are supplied then
+// this operation will apply to all PgBouncer databases.
+func (b *PgBouncer) Kill(ctx context.Context, databases ...string) error {
+	return b.executeForDatabases(ctx, `KILL %s;`, databases...)
+}
+
+// WaitClose blocks until all server connections to the given databases, as affected by
+// Reconnect or Reload, have been closed. If no databases are supplied then this
+// operation will wait for all PgBouncer databases.
+func (b *PgBouncer) WaitClose(ctx context.Context, databases ...string) error {
+	return b.executeForDatabases(ctx, `WAIT_CLOSE %s;`, databases...)
+}
+
 // Disable causes PgBouncer to reject all new client connections on the given databases.
 // If no databases are supplied then this operation will apply to all PgBouncer databases.
 func (b *PgBouncer) Disable(ctx context.Context, databases ...string) error {
+	return b.executeForDatabases(ctx, `DISABLE %s;`, databases...)
+}
+
+// Enable reverses Disable, allowing new client connections to the given databases. If no
+// databases are supplied then this operation will apply to all PgBouncer databases.
+func (b *PgBouncer) Enable(ctx context.Context, databases ...string) error {
+	return b.executeForDatabases(ctx, `ENABLE %s;`, databases...)
+}
+
+// executeForDatabases runs the command for each database, defaulting to every database
+// except PgBouncer's own admin database.
+func (b *PgBouncer) executeForDatabases(ctx context.Context, command string, databases ...string) error {
 	if len(databases) == 0 {
 		dbs, err := b.ShowDatabases(ctx)
 		if err != nil {
@@ -201,7 +274,7 @@ func (b *PgBouncer) Disable(ctx context.Context, databases ...string) error {
 	}
 
 	for _, database := range databases {
-		if err := b.Executor.Execute(ctx, fmt.Sprintf(`DISABLE %s;`, database)); err != nil {
+		if err := b.Executor.Execute(ctx, fmt.Sprintf(command, database)); err != nil {
 			return err
 		}
 	}
@@ -214,6 +287,27 @@ func (b *PgBouncer) Reload(ctx context.Context) error {
 	return b.Executor.Execute(ctx, `RELOAD;`)
 }
 
+// Version returns the version reported by SHOW VERSION, such as "PgBouncer 1.12.0".
+// Older PgBouncers report their version as a notice rather than a row, in which case we
+// return an empty string.
+func (b *PgBouncer) Version(ctx context.Context) (string, error) {
+	rows, err := b.Executor.Query(ctx, `SHOW VERSION;`)
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	var version sql.NullString
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return "", err
+		}
+	}
+
+	return version.String, rows.Err()
+}
+
 // Connect runs the most basic of commands (SHOW VERSION) against PgBouncer to ensure the
 // connection is alive.
 func (b *PgBouncer) Connect(ctx context.Context) error {
@@ -0,0 +1,175 @@
+package pgbouncer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pool is a single row from SHOW POOLS, with one pool per database and user pair
+type Pool struct {
+	Database, User, PoolMode      string
+	ActiveClients, WaitingClients int64
+}
+
+// Client is a single row from SHOW CLIENTS. Linked is true whenever the client holds a
+// server connection, which in transaction pools means it is mid-transaction. Link is the
+// Ptr of that server connection.
+type Client struct {
+	Database, User, State    string
+	Linked                   bool
+	Link                     string
+	ConnectTime, RequestTime time.Time
+}
+
+// Server is a single row from SHOW SERVERS. RemotePID identifies the Postgres backend
+// behind the server connection, and is reported from PgBouncer 1.8 onwards. Ptr is the
+// address PgBouncer uses to identify the connection, which linked clients refer to.
+type Server struct {
+	Database, User, State, Ptr string
+	RemotePID                  int64
+}
+
+// ShowPools extracts information from the SHOW POOLS PgBouncer command. PgBouncers older
+// than 1.9 don't report pool_mode, leaving PoolMode empty.
+func (b *PgBouncer) ShowPools(ctx context.Context) ([]Pool, error) {
+	pools := make([]Pool, 0)
+
+	var database, user, poolMode sql.NullString
+	var activeClients, waitingClients sql.NullInt64
+
+	err := b.queryColumns(
+		ctx, `SHOW POOLS;`,
+		map[string]interface{}{
+			"database":   &database,
+			"user":       &user,
+			"pool_mode":  &poolMode,
+			"cl_active":  &activeClients,
+			"cl_waiting": &waitingClients,
+		},
+		func() error {
+			pools = append(pools, Pool{
+				database.String, user.String, poolMode.String, activeClients.Int64, waitingClients.Int64,
+			})
+
+			return nil
+		},
+	)
+
+	return pools, err
+}
+
+// ShowClients extracts information from the SHOW CLIENTS PgBouncer command
+func (b *PgBouncer) ShowClients(ctx context.Context) ([]Client, error) {
+	clients := make([]Client, 0)
+
+	var database, user, state, link, connectTime, requestTime sql.NullString
+
+	err := b.queryColumns(
+		ctx, `SHOW CLIENTS;`,
+		map[string]interface{}{
+			"database":     &database,
+			"user":         &user,
+			"state":        &state,
+			"link":         &link,
+			"connect_time": &connectTime,
+			"request_time": &requestTime,
+		},
+		func() error {
+			client := Client{
+				Database: database.String,
+				User:     user.String,
+				State:    state.String,
+				Linked:   link.String != "",
+				Link:     link.String,
+			}
+
+			var err error
+			if client.ConnectTime, err = parseTime(connectTime.String); err != nil {
+				return err
+			}
+
+			if client.RequestTime, err = parseTime(requestTime.String); err != nil {
+				return err
+			}
+
+			clients = append(clients, client)
+			return nil
+		},
+	)
+
+	return clients, err
+}
+
+// ShowServers extracts information from the SHOW SERVERS PgBouncer command
+func (b *PgBouncer) ShowServers(ctx context.Context) ([]Server, error) {
+	servers := make([]Server, 0)
+
+	var database, user, state, ptr sql.NullString
+	var remotePID sql.NullInt64
+
+	err := b.queryColumns(
+		ctx, `SHOW SERVERS;`,
+		map[string]interface{}{
+			"database":   &database,
+			"user":       &user,
+			"state":      &state,
+			"ptr":        &ptr,
+			"remote_pid": &remotePID,
+		},
+		func() error {
+			servers = append(servers, Server{database.String, user.String, state.String, ptr.String, remotePID.Int64})
+			return nil
+		},
+	)
+
+	return servers, err
+}
+
+// queryColumns runs a SHOW command, scanning the named columns of each row into the
+// given destinations before calling handle. As with ShowDatabases, we can't rely on the
+// ordering of columns, so we match them by name and discard those we don't want.
+func (b *PgBouncer) queryColumns(ctx context.Context, query string, columns map[string]interface{}, handle func() error) error {
+	rows, err := b.Executor.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	fields := rows.FieldDescriptions()
+	columnPointers := make([]interface{}, len(fields))
+	for idx, field := range fields {
+		columnPointers[idx] = columns[field.Name]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(columnPointers...); err != nil {
+			return err
+		}
+
+		if err := handle(); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// PgBouncer renders timestamps in the server timezone, appending the zone name from
+// version 1.9 onwards. Clients that have yet to make a request report an empty time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("failed to parse PgBouncer time '%s'", value)
+}
@@ -0,0 +1,103 @@
+package pgbouncer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// TemplateFuncs is the function library available to PgBouncer config templates. Templates
+// are rendered as plain text, so values such as passwords are written verbatim.
+//
+//	env "NAME"                   value of the environment variable NAME, or empty string
+//	include "/path/to/file"      contents of the file, with trailing newlines removed
+//	default "fallback" VALUE     VALUE, unless it is empty, in which case "fallback"
+//	list "a" "b" ...             builds a list of strings from the arguments
+//	split "," "a,b"              splits a string into a list on the separator
+//	join "," LIST                joins a list of strings with the separator
+//	connstring "host" .Host ...  renders key/value pairs as a libpq connection string,
+//	                             quoting values where required and skipping empty ones
+//
+// Functions compose with pipelines, for example:
+//
+//	postgres = {{ connstring "host" .Host "port" (env "PGPORT" | default "6432") }}
+var TemplateFuncs = template.FuncMap{
+	"env":        os.Getenv,
+	"include":    templateInclude,
+	"default":    templateDefault,
+	"list":       templateList,
+	"split":      templateSplit,
+	"join":       templateJoin,
+	"connstring": templateConnstring,
+}
+
+func templateInclude(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to include file")
+	}
+
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+func templateDefault(fallback string, value interface{}) string {
+	if value == nil {
+		return fallback
+	}
+
+	if str := fmt.Sprintf("%v", value); str != "" {
+		return str
+	}
+
+	return fallback
+}
+
+func templateList(elems ...string) []string {
+	return elems
+}
+
+func templateSplit(sep, str string) []string {
+	if str == "" {
+		return []string{}
+	}
+
+	return strings.Split(str, sep)
+}
+
+func templateJoin(sep string, elems []string) string {
+	return strings.Join(elems, sep)
+}
+
+// templateConnstring renders pairs of keys and values as a libpq style connection string.
+// Values that are empty are omitted, while values containing whitespace, quotes or
+// backslashes are single quoted and escaped.
+func templateConnstring(pairs ...interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("connstring requires an even number of key/value arguments")
+	}
+
+	params := []string{}
+	for idx := 0; idx < len(pairs); idx += 2 {
+		key, value := fmt.Sprintf("%v", pairs[idx]), fmt.Sprintf("%v", pairs[idx+1])
+		if value == "" {
+			continue
+		}
+
+		params = append(params, fmt.Sprintf("%s=%s", key, quoteConnstringValue(value)))
+	}
+
+	return strings.Join(params, " "), nil
+}
+
+func quoteConnstringValue(value string) string {
+	if !strings.ContainsAny(value, " \t\n'\\") {
+		return value
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return fmt.Sprintf("'%s'", escaped)
+}
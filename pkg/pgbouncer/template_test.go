@@ -0,0 +1,135 @@
+package pgbouncer_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config templates", func() {
+	var (
+		bouncer   *pgbouncer.PgBouncer
+		workspace string
+		template  string
+		rendered  string
+		err       error
+	)
+
+	BeforeEach(func() {
+		workspace, err = ioutil.TempDir("", "pgbouncer-template-")
+		Expect(err).NotTo(HaveOccurred())
+
+		bouncer = &pgbouncer.PgBouncer{
+			ConfigFile:         filepath.Join(workspace, "pgbouncer.ini"),
+			ConfigTemplateFile: filepath.Join(workspace, "pgbouncer.ini.template"),
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(workspace)
+	})
+
+	JustBeforeEach(func() {
+		contents := fmt.Sprintf("[databases]\n%s\n\n[pgbouncer]\nignore_startup_parameters = extra_float_digits\n", template)
+		Expect(ioutil.WriteFile(bouncer.ConfigTemplateFile, []byte(contents), 0644)).To(Succeed())
+
+		rendered = ""
+		if err = bouncer.GenerateConfig("10.0.0.1"); err == nil {
+			renderedBytes, readErr := ioutil.ReadFile(bouncer.ConfigFile)
+			Expect(readErr).NotTo(HaveOccurred())
+			rendered = string(renderedBytes)
+		}
+	})
+
+	Context("With values that HTML would escape", func() {
+		BeforeEach(func() { template = `postgres = host={{.Host}} password={{ "p&ss<word>" }}` })
+
+		It("Renders them verbatim", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rendered).To(ContainSubstring("host=10.0.0.1 password=p&ss<word>"))
+		})
+	})
+
+	Context("With env", func() {
+		BeforeEach(func() { os.Setenv("STOLON_PGBOUNCER_TEST_PORT", "6433") })
+		AfterEach(func() { os.Unsetenv("STOLON_PGBOUNCER_TEST_PORT") })
+		BeforeEach(func() { template = `postgres = port={{ env "STOLON_PGBOUNCER_TEST_PORT" }}` })
+
+		It("Renders the environment value", func() {
+			Expect(rendered).To(ContainSubstring("postgres = port=6433"))
+		})
+	})
+
+	Context("With default", func() {
+		BeforeEach(func() {
+			template = `postgres = port={{ env "STOLON_PGBOUNCER_TEST_UNSET" | default "6432" }} host={{ .Host | default "localhost" }}`
+		})
+
+		It("Falls back only for empty values", func() {
+			Expect(rendered).To(ContainSubstring("postgres = port=6432 host=10.0.0.1"))
+		})
+	})
+
+	Context("With include", func() {
+		BeforeEach(func() {
+			includePath := filepath.Join(workspace, "databases.ini")
+			Expect(ioutil.WriteFile(includePath, []byte("other = host=other.db\n"), 0644)).To(Succeed())
+
+			template = fmt.Sprintf(`{{ include "%s" }}`, includePath)
+		})
+
+		It("Renders the file contents", func() {
+			Expect(rendered).To(ContainSubstring("[databases]\nother = host=other.db\n"))
+		})
+
+		Context("When file does not exist", func() {
+			BeforeEach(func() { template = `{{ include "/file/does/not/exist" }}` })
+
+			It("Returns error", func() {
+				Expect(err).To(MatchError(MatchRegexp("failed to include file")))
+			})
+		})
+	})
+
+	Context("With list, split and join", func() {
+		BeforeEach(func() {
+			template = `postgres = host={{ join "," (list .Host "10.0.0.2") }} other={{ split ";" "a;b" | join "," }}`
+		})
+
+		It("Joins the hosts", func() {
+			Expect(rendered).To(ContainSubstring("postgres = host=10.0.0.1,10.0.0.2 other=a,b"))
+		})
+	})
+
+	Context("With connstring", func() {
+		BeforeEach(func() {
+			template = `postgres = {{ connstring "host" .Host "port" 6432 "user" "" "password" "it's secret" }}`
+		})
+
+		It("Renders a quoted connection string, omitting empty values", func() {
+			Expect(rendered).To(ContainSubstring(`postgres = host=10.0.0.1 port=6432 password='it\'s secret'`))
+		})
+
+		Context("With odd number of arguments", func() {
+			BeforeEach(func() { template = `postgres = {{ connstring "host" }}` })
+
+			It("Returns error", func() {
+				Expect(err).To(MatchError(MatchRegexp("even number of key/value arguments")))
+			})
+		})
+	})
+
+	Context("With invalid template", func() {
+		BeforeEach(func() { template = "postgres = host={{.Host}}\nbroken = {{ unknown_function }}" })
+
+		It("Returns error identifying the line", func() {
+			Expect(err).To(MatchError(MatchRegexp(`failed to parse PgBouncer config template: .*pgbouncer.ini.template:3:`)))
+		})
+	})
+})
@@ -0,0 +1,193 @@
+package pgbouncer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PgBouncer uses this port for databases that don't specify one
+const defaultDatabasePort = "5432"
+
+// TargetMismatch describes a managed database that PgBouncer isn't routing to where we
+// expect it to.
+type TargetMismatch struct {
+	Database                 string
+	ExpectedHost, ActualHost string
+	ExpectedPort, ActualPort string
+}
+
+func (m TargetMismatch) String() string {
+	return fmt.Sprintf(
+		"%s: expected %s:%s, found %s:%s",
+		m.Database, m.ExpectedHost, m.ExpectedPort, m.ActualHost, m.ActualPort,
+	)
+}
+
+// VerifyTargets confirms that PgBouncer has applied our rendered config, checking every
+// database that the [databases] section of our config file points at the given host.
+// We return the databases PgBouncer doesn't yet route there, such as when it failed to
+// reload. Databases the config points elsewhere, such as static hosts or other clusters,
+// aren't rendered from the master and so aren't checked, nor are those named in ignore.
+func (b *PgBouncer) VerifyTargets(ctx context.Context, host string, ignore ...string) ([]TargetMismatch, error) {
+	configured, err := b.ConfiguredDatabases()
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := b.ShowDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareTargets(host, configured, actual, ignore...), nil
+}
+
+// CompareTargets checks each configured database that targets the given host is present
+// in the actual databases reported by PgBouncer, with that host and the port we
+// configured.
+func CompareTargets(host string, configured, actual []Database, ignore ...string) []TargetMismatch {
+	actualByName := map[string]Database{}
+	for _, db := range actual {
+		actualByName[db.Name] = db
+	}
+
+	mismatches := []TargetMismatch{}
+Configured:
+	for _, db := range configured {
+		if db.Host != host {
+			continue
+		}
+
+		for _, ignored := range ignore {
+			if db.Name == ignored {
+				continue Configured
+			}
+		}
+
+		found := actualByName[db.Name]
+		if found.Host != host || found.Port != db.Port {
+			mismatches = append(mismatches, TargetMismatch{
+				Database:     db.Name,
+				ExpectedHost: host,
+				ActualHost:   found.Host,
+				ExpectedPort: db.Port,
+				ActualPort:   found.Port,
+			})
+		}
+	}
+
+	return mismatches
+}
+
+var (
+	sectionPattern  = regexp.MustCompile(`^\[(\S+)\]$`)
+	databasePattern = regexp.MustCompile(`^([^=\s]+)\s*=\s*(.*)$`)
+)
+
+// ConfiguredDatabases parses the [databases] section of our rendered config file,
+// returning the host and port of each database. The wildcard (*) fallback database is
+// excluded, as it does not appear in SHOW DATABASES until a client uses it.
+func (b *PgBouncer) ConfiguredDatabases() ([]Database, error) {
+	configFile, err := os.Open(b.ConfigFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read PgBouncer config file")
+	}
+
+	defer configFile.Close()
+
+	databases := []Database{}
+	section := ""
+
+	scanner := bufio.NewScanner(configFile)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if match := sectionPattern.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			continue
+		}
+
+		if section != "databases" {
+			continue
+		}
+
+		match := databasePattern.FindStringSubmatch(line)
+		if match == nil || match[1] == "*" {
+			continue
+		}
+
+		params := ParseConnstring(match[2])
+		port := params["port"]
+		if port == "" {
+			port = defaultDatabasePort
+		}
+
+		databases = append(databases, Database{Name: match[1], Host: params["host"], Port: port})
+	}
+
+	return databases, scanner.Err()
+}
+
+// ParseConnstring parses a libpq style connection string of space separated key=value
+// pairs, where values may be single quoted with backslash escapes. It is the inverse of
+// the connstring template function.
+func ParseConnstring(connstring string) map[string]string {
+	params := map[string]string{}
+
+	var key, value strings.Builder
+	var inValue, valueStarted, quoted, escaped bool
+
+	flush := func() {
+		if key.Len() > 0 {
+			params[key.String()] = value.String()
+		}
+
+		key.Reset()
+		value.Reset()
+		inValue, valueStarted, quoted, escaped = false, false, false, false
+	}
+
+	for _, char := range connstring {
+		isSpace := char == ' ' || char == '\t'
+
+		switch {
+		case escaped:
+			value.WriteRune(char)
+			escaped = false
+		case quoted && char == '\\':
+			escaped = true
+		case quoted && char == '\'':
+			quoted = false
+		case quoted:
+			value.WriteRune(char)
+		case !inValue && char == '=':
+			inValue = true
+		case !inValue && !isSpace:
+			key.WriteRune(char)
+		case !inValue:
+			// whitespace between the key and =
+		case !valueStarted && isSpace:
+			// whitespace between = and the value
+		case !valueStarted && char == '\'':
+			valueStarted, quoted = true, true
+		case isSpace:
+			flush()
+		default:
+			valueStarted = true
+			value.WriteRune(char)
+		}
+	}
+
+	flush()
+
+	return params
+}
@@ -0,0 +1,122 @@
+package pgbouncer_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/pgbouncer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verify", func() {
+	Describe("ParseConnstring", func() {
+		It("Parses plain and quoted values", func() {
+			Expect(pgbouncer.ParseConnstring(`host=10.0.0.1 port = 6432  password='it\'s secret' dbname=app`)).To(
+				Equal(map[string]string{
+					"host":     "10.0.0.1",
+					"port":     "6432",
+					"password": "it's secret",
+					"dbname":   "app",
+				}),
+			)
+		})
+	})
+
+	Describe("ConfiguredDatabases", func() {
+		var (
+			bouncer    *pgbouncer.PgBouncer
+			configFile *os.File
+			err        error
+		)
+
+		BeforeEach(func() {
+			configFile, err = ioutil.TempFile("", "pgbouncer-config-")
+			Expect(err).NotTo(HaveOccurred())
+
+			configFile.WriteString(`[databases]
+; comments are ignored
+postgres = host=10.0.0.1 port=6432 pool_size=6
+reporting = host=10.0.0.1
+* = host=fallback
+
+[pgbouncer]
+listen_port = 6432
+`)
+			configFile.Close()
+
+			bouncer = &pgbouncer.PgBouncer{ConfigFile: configFile.Name()}
+		})
+
+		AfterEach(func() {
+			os.Remove(configFile.Name())
+		})
+
+		It("Returns databases with their host and port, excluding the wildcard", func() {
+			Expect(bouncer.ConfiguredDatabases()).To(
+				Equal([]pgbouncer.Database{
+					{Name: "postgres", Host: "10.0.0.1", Port: "6432"},
+					{Name: "reporting", Host: "10.0.0.1", Port: "5432"},
+				}),
+			)
+		})
+	})
+
+	Describe("CompareTargets", func() {
+		var (
+			configured = []pgbouncer.Database{
+				{Name: "postgres", Host: "10.0.0.1", Port: "6432"},
+				{Name: "reporting", Host: "10.0.0.9", Port: "6432"},
+			}
+		)
+
+		It("Reports databases rendered for the host that PgBouncer doesn't point at it", func() {
+			Expect(
+				pgbouncer.CompareTargets("10.0.0.1", configured, []pgbouncer.Database{
+					{Name: "pgbouncer", Port: "6432"},
+					{Name: "postgres", Host: "10.0.0.5", Port: "6432"},
+					{Name: "reporting", Host: "10.0.0.9", Port: "6432"},
+				}),
+			).To(
+				Equal([]pgbouncer.TargetMismatch{
+					{
+						Database:     "postgres",
+						ExpectedHost: "10.0.0.1",
+						ActualHost:   "10.0.0.5",
+						ExpectedPort: "6432",
+						ActualPort:   "6432",
+					},
+				}),
+			)
+		})
+
+		It("Ignores databases the config points at other hosts", func() {
+			Expect(
+				pgbouncer.CompareTargets("10.0.0.1", configured[1:], []pgbouncer.Database{
+					{Name: "reporting", Host: "10.0.0.9", Port: "6432"},
+				}),
+			).To(
+				BeEmpty(),
+			)
+		})
+
+		It("Reports databases missing from PgBouncer", func() {
+			Expect(
+				pgbouncer.CompareTargets("10.0.0.1", configured[:1], []pgbouncer.Database{}),
+			).To(
+				HaveLen(1),
+			)
+		})
+
+		It("Skips ignored databases", func() {
+			Expect(
+				pgbouncer.CompareTargets("10.0.0.1", configured, []pgbouncer.Database{
+					{Name: "postgres", Host: "10.0.0.5", Port: "6432"},
+				}, "postgres"),
+			).To(
+				BeEmpty(),
+			)
+		})
+	})
+})
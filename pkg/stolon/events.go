@@ -0,0 +1,281 @@
+package stolon
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// EventType identifies a change between two consecutive clusterdata values
+type EventType string
+
+const (
+	EventMasterChanged       EventType = "master_changed"
+	EventStandbyAdded        EventType = "standby_added"
+	EventStandbyRemoved      EventType = "standby_removed"
+	EventDBHealthChanged     EventType = "db_health_changed"
+	EventSyncStandbysChanged EventType = "sync_standbys_changed"
+	EventSpecChanged         EventType = "spec_changed"
+)
+
+// EventTypes lists every event the watcher can emit, in the order they are generated for
+// a single clusterdata change
+var EventTypes = []EventType{
+	EventMasterChanged,
+	EventStandbyAdded,
+	EventStandbyRemoved,
+	EventDBHealthChanged,
+	EventSyncStandbysChanged,
+	EventSpecChanged,
+}
+
+// Event describes a single change to clusterdata. Only the fields relevant to the Type
+// are set:
+//
+//	master_changed:        DB is the new master, Previous the old
+//	standby_added/removed: DB is the standby
+//	db_health_changed:     DB is the current state, with Previous holding the old
+//	sync_standbys_changed: SyncStandbys and PreviousSyncStandbys are keeper UIDs
+//	spec_changed:          Spec and PreviousSpec
+//
+// Every event carries the Clusterdata it was generated from, allowing subscribers to
+// inspect the rest of the cluster.
+type Event struct {
+	Type        EventType    `json:"type"`
+	Revision    int64        `json:"revision"`
+	Clusterdata *Clusterdata `json:"-"`
+
+	DB       *DB `json:"db,omitempty"`
+	Previous *DB `json:"previous,omitempty"`
+
+	SyncStandbys         []string `json:"syncStandbys,omitempty"`
+	PreviousSyncStandbys []string `json:"previousSyncStandbys,omitempty"`
+
+	Spec         *ClusterSpec `json:"spec,omitempty"`
+	PreviousSpec *ClusterSpec `json:"previousSpec,omitempty"`
+}
+
+func (e Event) String() string {
+	switch e.Type {
+	case EventMasterChanged:
+		return fmt.Sprintf("%s -> %s", e.Previous, e.DB)
+	case EventStandbyAdded, EventStandbyRemoved:
+		return e.DB.String()
+	case EventDBHealthChanged:
+		return fmt.Sprintf("%s healthy=%v -> healthy=%v", e.DB, e.Previous.Status.Healthy, e.DB.Status.Healthy)
+	case EventSyncStandbysChanged:
+		return fmt.Sprintf("[%s] -> [%s]", strings.Join(e.PreviousSyncStandbys, ","), strings.Join(e.SyncStandbys, ","))
+	case EventSpecChanged:
+		return fmt.Sprintf("%+v -> %+v", *e.PreviousSpec, *e.Spec)
+	}
+
+	return string(e.Type)
+}
+
+// DiffClusterdata generates the events that take us from the previous clusterdata to
+// the current. DBs are identified by their keeper, as stolon assigns a new DB UID
+// whenever a keeper is re-initialised. A nil previous value is treated as an empty
+// cluster, so the first clusterdata produces events describing its initial state.
+func DiffClusterdata(revision int64, previous, current *Clusterdata) []Event {
+	if previous == nil {
+		previous = &Clusterdata{}
+	}
+
+	events := []Event{}
+	add := func(event Event) {
+		event.Revision = revision
+		event.Clusterdata = current
+		events = append(events, event)
+	}
+
+	previousMaster, currentMaster := previous.Master(), current.Master()
+	if previousMaster.Spec.KeeperUID != currentMaster.Spec.KeeperUID {
+		add(Event{Type: EventMasterChanged, DB: &currentMaster, Previous: &previousMaster})
+	}
+
+	previousStandbys := standbysByKeeper(previous)
+	currentStandbys := standbysByKeeper(current)
+	for _, keeperUID := range sortedKeys(currentStandbys) {
+		if _, ok := previousStandbys[keeperUID]; !ok {
+			db := currentStandbys[keeperUID]
+			add(Event{Type: EventStandbyAdded, DB: &db})
+		}
+	}
+
+	for _, keeperUID := range sortedKeys(previousStandbys) {
+		if _, ok := currentStandbys[keeperUID]; !ok {
+			db := previousStandbys[keeperUID]
+			add(Event{Type: EventStandbyRemoved, DB: &db})
+		}
+	}
+
+	previousDBs, currentDBs := dbsByKeeper(previous), dbsByKeeper(current)
+	for _, keeperUID := range sortedKeys(currentDBs) {
+		previousDB, ok := previousDBs[keeperUID]
+		if currentDB := currentDBs[keeperUID]; ok && previousDB.Status.Healthy != currentDB.Status.Healthy {
+			add(Event{Type: EventDBHealthChanged, DB: &currentDB, Previous: &previousDB})
+		}
+	}
+
+	previousSyncs, currentSyncs := syncStandbyKeepers(previous), syncStandbyKeepers(current)
+	if !reflect.DeepEqual(previousSyncs, currentSyncs) {
+		add(Event{Type: EventSyncStandbysChanged, SyncStandbys: currentSyncs, PreviousSyncStandbys: previousSyncs})
+	}
+
+	previousSpec, currentSpec := previous.Cluster.Spec, current.Cluster.Spec
+	if !reflect.DeepEqual(previousSpec, currentSpec) {
+		add(Event{Type: EventSpecChanged, Spec: &currentSpec, PreviousSpec: &previousSpec})
+	}
+
+	return events
+}
+
+func dbsByKeeper(c *Clusterdata) map[string]DB {
+	dbs := map[string]DB{}
+	for _, db := range c.Dbs {
+		dbs[db.Spec.KeeperUID] = db
+	}
+
+	return dbs
+}
+
+func standbysByKeeper(c *Clusterdata) map[string]DB {
+	dbs := dbsByKeeper(c)
+	delete(dbs, c.Master().Spec.KeeperUID)
+
+	return dbs
+}
+
+// syncStandbyKeepers returns the sorted keeper UIDs of the synchronous standbys,
+// excluding any dummy sync replica
+func syncStandbyKeepers(c *Clusterdata) []string {
+	keepers := []string{}
+	for _, db := range c.SynchronousStandbys() {
+		if db.Spec.KeeperUID != "" {
+			keepers = append(keepers, db.Spec.KeeperUID)
+		}
+	}
+
+	sort.Strings(keepers)
+	return keepers
+}
+
+func sortedKeys(dbs map[string]DB) []string {
+	keys := []string{}
+	for key := range dbs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// subscriberBuffer is how many events a subscriber can fall behind by before we start
+// dropping its oldest events
+const subscriberBuffer = 64
+
+// ClusterdataWatcher diffs a stream of clusterdata, delivering the resulting events to
+// each subscriber that asked for them. Subscribers receive events in order, each from
+// its own buffer so that a slow subscriber can't delay the others. Should a subscriber
+// fall too far behind, we drop its oldest events: each carries the clusterdata it came
+// from, so subscribers needing the latest cluster state can rely on the newest event.
+type ClusterdataWatcher struct {
+	logger      kitlog.Logger
+	initial     *Clusterdata
+	mu          sync.Mutex
+	subscribers []subscriber
+}
+
+type subscriber struct {
+	types map[EventType]bool
+	out   chan Event
+}
+
+// NewClusterdataWatcher diffs the first clusterdata it receives against initial, which
+// should be the clusterdata the caller already knows about. If nil, the first
+// clusterdata produces events describing its initial state.
+func NewClusterdataWatcher(logger kitlog.Logger, initial *Clusterdata) *ClusterdataWatcher {
+	return &ClusterdataWatcher{logger: logger, initial: initial}
+}
+
+// Subscribe returns a channel of the given event types, or all events if none are
+// given. Subscriptions must be made before calling Run, and are closed once it returns.
+func (w *ClusterdataWatcher) Subscribe(types ...EventType) <-chan Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(types) == 0 {
+		types = EventTypes
+	}
+
+	sub := subscriber{types: map[EventType]bool{}, out: make(chan Event, subscriberBuffer)}
+	for _, eventType := range types {
+		sub.types[eventType] = true
+	}
+
+	w.subscribers = append(w.subscribers, sub)
+	return sub.out
+}
+
+// Run consumes clusterdata updates until the input closes or the context is cancelled,
+// publishing events to subscribers.
+func (w *ClusterdataWatcher) Run(ctx context.Context, updates <-chan ClusterdataUpdate) error {
+	w.mu.Lock()
+	subscribers := w.subscribers
+	w.mu.Unlock()
+
+	defer func() {
+		for _, sub := range subscribers {
+			close(sub.out)
+		}
+	}()
+
+	previous := w.initial
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+
+			for _, event := range DiffClusterdata(update.Kv.ModRevision, previous, update.Clusterdata) {
+				level.Debug(w.logger).Log("event", "clusterdata_event", "type", event.Type, "revision", event.Revision,
+					"change", event.String())
+				for _, sub := range subscribers {
+					if sub.types[event.Type] {
+						w.publish(sub, event)
+					}
+				}
+			}
+
+			previous = update.Clusterdata
+		}
+	}
+}
+
+// publish sends the event without blocking, dropping the subscriber's oldest event if
+// its buffer is full. We're the only sender, so once we've made space our send succeeds.
+func (w *ClusterdataWatcher) publish(sub subscriber, event Event) {
+	for {
+		select {
+		case sub.out <- event:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-sub.out:
+			level.Warn(w.logger).Log("event", "clusterdata_event_dropped", "type", dropped.Type,
+				"revision", dropped.Revision, "msg", "subscriber is too slow, dropping oldest event")
+		default:
+		}
+	}
+}
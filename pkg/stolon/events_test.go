@@ -0,0 +1,250 @@
+package stolon
+
+import (
+	"context"
+	"fmt"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clusterdata events", func() {
+	// makeClusterdata builds a cluster where the first keeper is master and the second its
+	// sync standby. DB UIDs are prefixed, so they differ from the keeper UIDs.
+	makeClusterdata := func(keepers ...string) *Clusterdata {
+		clusterdata := &Clusterdata{
+			Cluster: Cluster{Spec: ClusterSpec{SynchronousReplication: true, MinSynchronousStandbys: 1}},
+			Proxy:   Proxy{Spec: ProxySpec{MasterDbUID: "db-" + keepers[0]}},
+			Dbs:     map[string]DB{},
+		}
+
+		for _, keeper := range keepers {
+			clusterdata.Dbs["db-"+keeper] = DB{
+				Spec:   DBSpec{KeeperUID: keeper},
+				Status: DBStatus{Healthy: true, ListenAddress: keeper + ".local"},
+			}
+		}
+
+		if len(keepers) > 1 {
+			master := clusterdata.Dbs["db-"+keepers[0]]
+			master.Status.SynchronousStandbys = []string{"db-" + keepers[1]}
+			clusterdata.Dbs["db-"+keepers[0]] = master
+		}
+
+		return clusterdata
+	}
+
+	types := func(events []Event) []EventType {
+		result := []EventType{}
+		for _, event := range events {
+			result = append(result, event.Type)
+		}
+
+		return result
+	}
+
+	Describe("DiffClusterdata", func() {
+		var (
+			previous, current *Clusterdata
+			events            []Event
+		)
+
+		BeforeEach(func() {
+			previous = makeClusterdata("keeper0", "keeper1", "keeper2")
+			current = makeClusterdata("keeper0", "keeper1", "keeper2")
+		})
+
+		JustBeforeEach(func() {
+			events = DiffClusterdata(5, previous, current)
+		})
+
+		Context("When nothing has changed", func() {
+			It("Emits no events", func() {
+				Expect(events).To(BeEmpty())
+			})
+		})
+
+		Context("With no previous clusterdata", func() {
+			BeforeEach(func() { previous = nil })
+
+			It("Describes the initial state", func() {
+				Expect(types(events)).To(Equal([]EventType{
+					EventMasterChanged, EventStandbyAdded, EventStandbyAdded, EventSyncStandbysChanged, EventSpecChanged,
+				}))
+				Expect(events[0].DB.Spec.KeeperUID).To(Equal("keeper0"))
+				Expect(events[0].Revision).To(BeEquivalentTo(5))
+				Expect(events[0].Clusterdata).To(Equal(current))
+			})
+		})
+
+		Context("When the master fails over", func() {
+			BeforeEach(func() { current = makeClusterdata("keeper1", "keeper2", "keeper0") })
+
+			It("Emits master, standby and sync standby changes", func() {
+				Expect(types(events)).To(Equal([]EventType{
+					EventMasterChanged, EventStandbyAdded, EventStandbyRemoved, EventSyncStandbysChanged,
+				}))
+
+				Expect(events[0].Previous.Spec.KeeperUID).To(Equal("keeper0"))
+				Expect(events[0].DB.Spec.KeeperUID).To(Equal("keeper1"))
+				Expect(events[1].DB.Spec.KeeperUID).To(Equal("keeper0"))
+				Expect(events[2].DB.Spec.KeeperUID).To(Equal("keeper1"))
+				Expect(events[3].PreviousSyncStandbys).To(Equal([]string{"keeper1"}))
+				Expect(events[3].SyncStandbys).To(Equal([]string{"keeper2"}))
+			})
+		})
+
+		Context("When a DB becomes unhealthy", func() {
+			BeforeEach(func() {
+				db := current.Dbs["db-keeper2"]
+				db.Status.Healthy = false
+				current.Dbs["db-keeper2"] = db
+			})
+
+			It("Emits a health change", func() {
+				Expect(types(events)).To(Equal([]EventType{EventDBHealthChanged}))
+				Expect(events[0].Previous.Status.Healthy).To(BeTrue())
+				Expect(events[0].DB.Status.Healthy).To(BeFalse())
+			})
+		})
+
+		Context("When a keeper is re-initialised with a new DB UID", func() {
+			BeforeEach(func() {
+				current.Dbs["db-keeper2-new"] = current.Dbs["db-keeper2"]
+				delete(current.Dbs, "db-keeper2")
+			})
+
+			It("Emits no events", func() {
+				Expect(events).To(BeEmpty())
+			})
+		})
+
+		Context("When the cluster spec changes", func() {
+			BeforeEach(func() { current.Cluster.Spec.MinSynchronousStandbys = 2 })
+
+			It("Emits a spec change", func() {
+				Expect(types(events)).To(Equal([]EventType{EventSpecChanged}))
+				Expect(events[0].PreviousSpec.MinSynchronousStandbys).To(Equal(1))
+				Expect(events[0].Spec.MinSynchronousStandbys).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("ClusterdataWatcher", func() {
+		var (
+			ctx     context.Context
+			cancel  func()
+			updates chan ClusterdataUpdate
+			watcher *ClusterdataWatcher
+			initial *Clusterdata
+		)
+
+		update := func(revision int64, clusterdata *Clusterdata) ClusterdataUpdate {
+			return ClusterdataUpdate{Kv: &store.KeyValue{ModRevision: revision}, Clusterdata: clusterdata}
+		}
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			updates = make(chan ClusterdataUpdate)
+			initial = nil
+		})
+
+		JustBeforeEach(func() {
+			watcher = NewClusterdataWatcher(kitlog.NewLogfmtLogger(GinkgoWriter), initial)
+		})
+
+		AfterEach(func() {
+			cancel()
+		})
+
+		It("Delivers only the subscribed event types", func() {
+			masters := watcher.Subscribe(EventMasterChanged)
+			all := watcher.Subscribe()
+			go watcher.Run(ctx, updates)
+
+			go func() {
+				updates <- update(1, makeClusterdata("keeper0", "keeper1"))
+				updates <- update(2, makeClusterdata("keeper1", "keeper0"))
+				close(updates)
+			}()
+
+			var received []Event
+			for i := 0; i < 5; i++ {
+				var event Event
+				Eventually(all).Should(Receive(&event))
+				received = append(received, event)
+			}
+
+			Expect(types(received)).To(Equal([]EventType{
+				EventMasterChanged, EventStandbyAdded, EventSyncStandbysChanged, EventSpecChanged, EventMasterChanged,
+			}))
+
+			var event Event
+			Eventually(masters).Should(Receive(&event))
+			Expect(event.DB.Spec.KeeperUID).To(Equal("keeper0"))
+			Eventually(masters).Should(Receive(&event))
+			Expect(event.DB.Spec.KeeperUID).To(Equal("keeper1"))
+			Expect(event.Revision).To(BeEquivalentTo(2))
+		})
+
+		It("Doesn't hold up other subscribers when one isn't reading", func() {
+			watcher.Subscribe()
+			all := watcher.Subscribe()
+			go watcher.Run(ctx, updates)
+
+			go func() {
+				for revision := int64(1); revision <= 2*subscriberBuffer; revision++ {
+					keeper := fmt.Sprintf("keeper%d", revision%2)
+					updates <- update(revision, makeClusterdata(keeper, "standby"))
+				}
+			}()
+
+			// Each update changes master, so we must eventually see the final revision
+			var revision int64
+			Eventually(func() int64 {
+				for {
+					select {
+					case event := <-all:
+						revision = event.Revision
+					default:
+						return revision
+					}
+				}
+			}).Should(BeEquivalentTo(2 * subscriberBuffer))
+		})
+
+		Context("When seeded with the current clusterdata", func() {
+			BeforeEach(func() {
+				initial = makeClusterdata("keeper0", "keeper1")
+			})
+
+			It("Reports only changes from the seed", func() {
+				events := watcher.Subscribe()
+				go watcher.Run(ctx, updates)
+
+				go func() {
+					updates <- update(1, makeClusterdata("keeper0", "keeper1"))
+					updates <- update(2, makeClusterdata("keeper1", "keeper0"))
+				}()
+
+				var event Event
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(EventMasterChanged))
+				Expect(event.Revision).To(BeEquivalentTo(2))
+				Expect(event.Previous.Spec.KeeperUID).To(Equal("keeper0"))
+			})
+		})
+
+		It("Closes subscriptions once the updates close", func() {
+			events := watcher.Subscribe()
+			go watcher.Run(ctx, updates)
+
+			close(updates)
+			Eventually(events).Should(BeClosed())
+		})
+	})
+})
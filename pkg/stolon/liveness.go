@@ -0,0 +1,123 @@
+package stolon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/pkg/errors"
+)
+
+// Liveness describes which stolon components are alive according to the store. Stolon
+// components publish their info to keys that expire shortly after they stop, and the
+// sentinels elect a leader using a lease-backed key. Unlike DBStatus.Healthy, which the
+// sentinel leader computes, this tells us if anyone is left to act on a failover.
+type Liveness struct {
+	SentinelLeader string
+	Sentinels      []string
+	Keepers        []string
+}
+
+type componentInfo struct {
+	UID string `json:"uid"`
+}
+
+// GetLiveness reads the sentinel and keeper info keys, along with the current sentinel
+// leader, from alongside the clusterdata key.
+func GetLiveness(ctx context.Context, client store.Store, clusterdataKey string) (Liveness, error) {
+	var liveness Liveness
+	var err error
+
+	clusterPath := path.Dir(clusterdataKey)
+	if liveness.Sentinels, err = getInfoUIDs(ctx, client, path.Join(clusterPath, "sentinels", "info")+"/"); err != nil {
+		return liveness, err
+	}
+
+	if liveness.Keepers, err = getInfoUIDs(ctx, client, path.Join(clusterPath, "keepers", "info")+"/"); err != nil {
+		return liveness, err
+	}
+
+	if liveness.SentinelLeader, err = client.SentinelLeader(ctx, clusterPath); err != nil {
+		return liveness, errors.Wrap(err, "failed to get sentinel leader")
+	}
+
+	return liveness, nil
+}
+
+func getInfoUIDs(ctx context.Context, client store.Store, prefix string) ([]string, error) {
+	kvs, err := client.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", prefix)
+	}
+
+	uids := []string{}
+	for _, kv := range kvs {
+		var info componentInfo
+		if err := json.Unmarshal(kv.Value, &info); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", kv.Key)
+		}
+
+		uids = append(uids, info.UID)
+	}
+
+	sort.Strings(uids)
+
+	return uids, nil
+}
+
+// StaleComponents returns the names of components that should be alive but aren't: the
+// sentinel leader, should none be elected, and any keeper in the clusterdata that has
+// stopped publishing its info.
+//
+// Info keys are only removed when they expire, which never happens for the pod
+// annotations used in Kubernetes, so we also require the sentinel to have seen each
+// keeper healthy within maxHeartbeatAge of its last clusterdata update. Both times are
+// written by the sentinel, so we don't rely on our clock agreeing with its. A zero
+// maxHeartbeatAge disables this check.
+func (c Clusterdata) StaleComponents(liveness Liveness, maxHeartbeatAge time.Duration) []string {
+	stale := []string{}
+	if liveness.SentinelLeader == "" {
+		stale = append(stale, "sentinel leader")
+	}
+
+	live := map[string]bool{}
+	for _, keeperUID := range liveness.Keepers {
+		live[keeperUID] = true
+	}
+
+	keepers := []string{}
+	for _, db := range c.Dbs {
+		if !live[db.Spec.KeeperUID] {
+			keepers = append(keepers, fmt.Sprintf("keeper %s", db.Spec.KeeperUID))
+			continue
+		}
+
+		if maxHeartbeatAge == 0 {
+			continue
+		}
+
+		lastHealthy := c.Keepers[db.Spec.KeeperUID].Status.LastHealthyTime
+		if age := c.ChangeTime.Sub(lastHealthy); age > maxHeartbeatAge {
+			keepers = append(keepers, fmt.Sprintf("keeper %s (last heartbeat %s before clusterdata update)",
+				db.Spec.KeeperUID, age.Round(time.Second)))
+		}
+	}
+
+	sort.Strings(keepers)
+
+	return append(stale, keepers...)
+}
+
+// CheckLiveness returns an error naming any stale components
+func (c Clusterdata) CheckLiveness(liveness Liveness, maxHeartbeatAge time.Duration) error {
+	if stale := c.StaleComponents(liveness, maxHeartbeatAge); len(stale) > 0 {
+		return fmt.Errorf("stale components: %s", strings.Join(stale, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package stolon
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Liveness", func() {
+	var (
+		changeTime  = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		clusterdata = Clusterdata{
+			ChangeTime: changeTime,
+			Keepers: map[string]Keeper{
+				"keeper0": {Status: KeeperStatus{LastHealthyTime: changeTime.Add(-time.Second)}},
+				"keeper1": {Status: KeeperStatus{LastHealthyTime: changeTime.Add(-time.Minute)}},
+				"keeper2": {Status: KeeperStatus{LastHealthyTime: changeTime}},
+			},
+			Dbs: map[string]DB{
+				"db0": {Spec: DBSpec{KeeperUID: "keeper0"}},
+				"db1": {Spec: DBSpec{KeeperUID: "keeper1"}},
+				"db2": {Spec: DBSpec{KeeperUID: "keeper2"}},
+			},
+		}
+	)
+
+	Describe("Clusterdata.CheckLiveness()", func() {
+		It("Succeeds when every component is alive", func() {
+			Expect(clusterdata.CheckLiveness(Liveness{
+				SentinelLeader: "sentinel0",
+				Keepers:        []string{"keeper0", "keeper1", "keeper2"},
+			}, 2*time.Minute)).To(Succeed())
+		})
+
+		It("Names the stale components", func() {
+			Expect(clusterdata.CheckLiveness(Liveness{
+				Keepers: []string{"keeper1"},
+			}, 0)).To(
+				MatchError("stale components: sentinel leader, keeper keeper0, keeper keeper2"),
+			)
+		})
+
+		It("Names keepers whose info remains but that the sentinel hasn't seen recently", func() {
+			Expect(clusterdata.CheckLiveness(Liveness{
+				SentinelLeader: "sentinel0",
+				Keepers:        []string{"keeper0", "keeper1", "keeper2"},
+			}, 30*time.Second)).To(
+				MatchError("stale components: keeper keeper1 (last heartbeat 1m0s before clusterdata update)"),
+			)
+		})
+	})
+})
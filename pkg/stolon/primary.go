@@ -0,0 +1,68 @@
+package stolon
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// ErrInRecovery is returned when a database we expected to be primary is still in
+// recovery, and therefore unable to accept writes.
+var ErrInRecovery = errors.New("database is in recovery")
+
+// PrimaryCheck confirms a database is really a primary by connecting to it and asking
+// pg_is_in_recovery(). Clusterdata can be stale or half-written, and this check prevents
+// us routing writes to a read-only standby.
+type PrimaryCheck struct {
+	// ConnConfig provides the user, database, password and TLS settings used to connect.
+	// Host and port are taken from the database being checked.
+	ConnConfig pgx.ConnConfig
+	// Port overrides the port we connect to, such as when connecting via the PgBouncer
+	// that runs alongside each keeper. When empty, we use the port Postgres listens on.
+	Port    string
+	Timeout time.Duration
+}
+
+// Confirm returns nil only if the database is accepting writes
+func (c PrimaryCheck) Confirm(ctx context.Context, db DB) error {
+	port := c.Port
+	if port == "" {
+		port = db.Status.Port
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse valid port number")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	cfg := c.ConnConfig
+	cfg.Host, cfg.Port = db.Status.ListenAddress, uint16(portNumber)
+	// PgBouncer doesn't support prepared statements, so we always use the simple protocol
+	cfg.PreferSimpleProtocol = true
+	cfg.Dial = (&net.Dialer{Timeout: c.Timeout, KeepAlive: 5 * time.Minute}).Dial
+
+	conn, err := pgx.Connect(cfg)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", db)
+	}
+
+	defer conn.Close()
+
+	var inRecovery bool
+	if err := conn.QueryRowEx(ctx, "select pg_is_in_recovery();", nil).Scan(&inRecovery); err != nil {
+		return errors.Wrapf(err, "failed to query recovery status of %s", db)
+	}
+
+	if inRecovery {
+		return errors.Wrapf(ErrInRecovery, "%s", db)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package stolon
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrimaryCheck", func() {
+	var (
+		check PrimaryCheck
+		db    DB
+	)
+
+	BeforeEach(func() {
+		check = PrimaryCheck{Timeout: time.Second}
+		db = DB{
+			Spec:   DBSpec{KeeperUID: "keeper0"},
+			Status: DBStatus{ListenAddress: "127.0.0.1", Port: "5432"},
+		}
+	})
+
+	Describe(".Confirm()", func() {
+		It("Fails when the port is invalid", func() {
+			check.Port = "not-a-port"
+			Expect(check.Confirm(context.Background(), db)).To(
+				MatchError(ContainSubstring("failed to parse valid port number")),
+			)
+		})
+
+		It("Fails when it can't connect, naming the database", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			_, port, _ := net.SplitHostPort(listener.Addr().String())
+			listener.Close()
+
+			db.Status.Port = port
+			Expect(check.Confirm(context.Background(), db)).To(
+				MatchError(ContainSubstring("failed to connect to keeper0 (127.0.0.1)")),
+			)
+		})
+	})
+})
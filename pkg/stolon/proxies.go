@@ -0,0 +1,58 @@
+package stolon
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/pkg/errors"
+)
+
+// ProxyInfo is published by each stolon proxy, recording the generation of the
+// clusterdata proxy spec it has applied. Stolon expires these keys shortly after a proxy
+// stops, so every key present represents a live proxy.
+type ProxyInfo struct {
+	UID        string `json:"uid"`
+	Generation int64  `json:"generation"`
+}
+
+// ProxiesInfoPrefix returns the prefix under which stolon proxies publish their info,
+// which sits alongside the clusterdata key.
+func ProxiesInfoPrefix(clusterdataKey string) string {
+	return path.Join(path.Dir(clusterdataKey), "proxies", "info") + "/"
+}
+
+// GetProxiesInfo fetches the info of every live stolon proxy in the cluster
+func GetProxiesInfo(ctx context.Context, client store.Store, clusterdataKey string) ([]ProxyInfo, error) {
+	kvs, err := client.List(ctx, ProxiesInfoPrefix(clusterdataKey))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []ProxyInfo{}
+	for _, kv := range kvs {
+		var info ProxyInfo
+		if err := json.Unmarshal(kv.Value, &info); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse proxy info %s", kv.Key)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// LaggingProxies returns the proxies that have yet to apply the current proxy
+// generation. Until they have, they may be fencing connections or routing to an old
+// master.
+func (c Clusterdata) LaggingProxies(infos []ProxyInfo) []ProxyInfo {
+	lagging := []ProxyInfo{}
+	for _, info := range infos {
+		if info.Generation < c.Proxy.Generation {
+			lagging = append(lagging, info)
+		}
+	}
+
+	return lagging
+}
@@ -0,0 +1,31 @@
+package stolon
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxies", func() {
+	Describe("ProxiesInfoPrefix", func() {
+		It("Sits alongside the clusterdata key", func() {
+			Expect(ProxiesInfoPrefix("stolon/cluster/main/clusterdata")).To(
+				Equal("stolon/cluster/main/proxies/info/"),
+			)
+		})
+	})
+
+	Describe("Clusterdata.LaggingProxies()", func() {
+		It("Returns proxies behind the current generation", func() {
+			clusterdata := Clusterdata{Proxy: Proxy{Generation: 5}}
+			infos := []ProxyInfo{
+				{UID: "ahead", Generation: 6},
+				{UID: "current", Generation: 5},
+				{UID: "behind", Generation: 4},
+			}
+
+			Expect(clusterdata.LaggingProxies(infos)).To(Equal([]ProxyInfo{
+				{UID: "behind", Generation: 4},
+			}))
+		})
+	})
+})
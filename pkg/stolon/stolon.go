@@ -6,80 +6,280 @@ import (
 	"fmt"
 	"os/exec"
 	"reflect"
+	"sort"
+	"time"
 
-	"github.com/coreos/etcd/clientv3"
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
 	"github.com/pkg/errors"
 )
 
-// GetClusterdata fetches and parses from etcd using the given key
-func GetClusterdata(ctx context.Context, client *clientv3.Client, key string) (*Clusterdata, error) {
+// GetClusterdata fetches and parses from the store using the given key
+func GetClusterdata(ctx context.Context, client store.Store, key string) (*Clusterdata, error) {
 	clusterdataBytes, err := GetClusterdataBytes(ctx, client, key)
 	if err != nil {
 		return nil, err
 	}
 
-	var clusterdata = &Clusterdata{}
-	if err := json.Unmarshal(clusterdataBytes, clusterdata); err != nil {
-		return nil, errors.Wrap(err, "failed to parse clusterdata")
-	}
-
-	return clusterdata, nil
+	return UnmarshalClusterdata(clusterdataBytes)
 }
 
 // GetClusterdataBytes returns a byte slice for dynamic manipulation.
-func GetClusterdataBytes(ctx context.Context, client *clientv3.Client, key string) ([]byte, error) {
-	resp, err := client.Get(ctx, key)
+func GetClusterdataBytes(ctx context.Context, client store.Store, key string) ([]byte, error) {
+	kv, err := client.Get(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Kvs) == 0 {
+	if kv == nil {
 		return nil, errors.New("no clusterdata found")
 	}
 
-	return resp.Kvs[0].Value, nil
+	return kv.Value, nil
 }
 
-// Clusterdata is a minimal extraction that we need from stolon. Whenever we upgrade
-// stolon, we should verify that this definition is compatible.
+// SupportedFormatVersion is the clusterdata formatVersion that this model describes.
+// Stolon bumps the version whenever it makes an incompatible change to clusterdata, at
+// which point we should review this model before supporting the new version.
+const SupportedFormatVersion = 1
+
+// Clusterdata models the clusterdata stolon writes to the store, as of stolon v0.17. We
+// omit the cluster spec's initialisation configs (newConfig, pitrConfig and
+// existingConfig), as they don't apply to a running cluster.
+//
+// Stolon omits spec values left at their defaults, which will be zero-valued here.
 type Clusterdata struct {
-	Cluster `json:"cluster"`
-	Proxy   `json:"proxy"`
-	Dbs     map[string]DB `json:"dbs"`
+	FormatVersion uint64    `json:"formatVersion"`
+	ChangeTime    time.Time `json:"changeTime"`
+	Cluster       `json:"cluster"`
+	Keepers       map[string]Keeper `json:"keepers"`
+	Dbs           map[string]DB     `json:"dbs"`
+	Proxy         `json:"proxy"`
 }
 
 type Cluster struct {
-	Spec ClusterSpec `json:"spec"`
+	UID        string        `json:"uid"`
+	Generation int64         `json:"generation"`
+	ChangeTime time.Time     `json:"changeTime"`
+	Spec       ClusterSpec   `json:"spec"`
+	Status     ClusterStatus `json:"status"`
 }
 
 type ClusterSpec struct {
-	SynchronousReplication bool `json:"synchronousReplication"`
-	MinSynchronousStandbys int  `json:"minSynchronousStandbys"`
+	SleepInterval                    Duration          `json:"sleepInterval"`
+	RequestTimeout                   Duration          `json:"requestTimeout"`
+	ConvergenceTimeout               Duration          `json:"convergenceTimeout"`
+	InitTimeout                      Duration          `json:"initTimeout"`
+	SyncTimeout                      Duration          `json:"syncTimeout"`
+	DBWaitReadyTimeout               Duration          `json:"dbWaitReadyTimeout"`
+	FailInterval                     Duration          `json:"failInterval"`
+	DeadKeeperRemovalInterval        Duration          `json:"deadKeeperRemovalInterval"`
+	ProxyCheckInterval               Duration          `json:"proxyCheckInterval"`
+	ProxyTimeout                     Duration          `json:"proxyTimeout"`
+	MaxStandbys                      int               `json:"maxStandbys"`
+	MaxStandbysPerSender             int               `json:"maxStandbysPerSender"`
+	MaxStandbyLag                    int               `json:"maxStandbyLag"`
+	SynchronousReplication           bool              `json:"synchronousReplication"`
+	MinSynchronousStandbys           int               `json:"minSynchronousStandbys"`
+	MaxSynchronousStandbys           int               `json:"maxSynchronousStandbys"`
+	AdditionalWalSenders             int               `json:"additionalWalSenders"`
+	AdditionalMasterReplicationSlots []string          `json:"additionalMasterReplicationSlots"`
+	UsePgrewind                      bool              `json:"usePgrewind"`
+	InitMode                         string            `json:"initMode"`
+	MergePgParameters                *bool             `json:"mergePgParameters"`
+	Role                             string            `json:"role"`
+	StandbyConfig                    *StandbyConfig    `json:"standbyConfig"`
+	DefaultSUReplAccessMode          string            `json:"defaultSUReplAccessMode"`
+	PGParameters                     map[string]string `json:"pgParameters"`
+	PGHBA                            []string          `json:"pgHBA"`
+	AutomaticPgRestart               bool              `json:"automaticPgRestart"`
+}
+
+// StandbyConfig is set when the whole cluster replicates from another
+type StandbyConfig struct {
+	StandbySettings *StandbySettings `json:"standbySettings"`
+}
+
+type StandbySettings struct {
+	PrimaryConninfo       string `json:"primaryConninfo"`
+	PrimarySlotName       string `json:"primarySlotName"`
+	RecoveryMinApplyDelay string `json:"recoveryMinApplyDelay"`
+}
+
+type ClusterStatus struct {
+	CurrentGeneration int64  `json:"currentGeneration"`
+	Phase             string `json:"phase"`
+	Master            string `json:"master"` // UID of the master DB
+}
+
+type Keeper struct {
+	UID        string       `json:"uid"`
+	Generation int64        `json:"generation"`
+	ChangeTime time.Time    `json:"changeTime"`
+	Status     KeeperStatus `json:"status"`
+}
+
+type KeeperStatus struct {
+	Healthy                 bool                  `json:"healthy"`
+	LastHealthyTime         time.Time             `json:"lastHealthyTime"`
+	BootUUID                string                `json:"bootUUID"`
+	PostgresBinaryVersion   PostgresBinaryVersion `json:"postgresBinaryVersion"`
+	ForceFail               bool                  `json:"forceFail"`
+	CanBeMaster             *bool                 `json:"canBeMaster"`
+	CanBeSynchronousReplica *bool                 `json:"canBeSynchronousReplica"`
+}
+
+type PostgresBinaryVersion struct {
+	Maj int `json:"maj"`
+	Min int `json:"min"`
 }
 
 type Proxy struct {
-	Spec ProxySpec `json:"spec"`
+	UID        string    `json:"uid"`
+	Generation int64     `json:"generation"`
+	ChangeTime time.Time `json:"changeTime"`
+	Spec       ProxySpec `json:"spec"`
 }
 
 type ProxySpec struct {
-	MasterDbUID string `json:"masterDbUid"`
+	MasterDbUID    string   `json:"masterDbUid"`
+	EnabledProxies []string `json:"enabledProxies"`
 }
 
 type DB struct {
-	Spec   DBSpec   `json:"spec"`
-	Status DBStatus `json:"status"`
+	UID        string    `json:"uid"`
+	Generation int64     `json:"generation"`
+	ChangeTime time.Time `json:"changeTime"`
+	Spec       DBSpec    `json:"spec"`
+	Status     DBStatus  `json:"status"`
 }
 
 type DBSpec struct {
-	KeeperUID                   string   `json:"keeperUID"`
-	ExternalSynchronousStandbys []string `json:"externalSynchronousStandbys"`
+	KeeperUID                   string            `json:"keeperUID"`
+	RequestTimeout              Duration          `json:"requestTimeout"`
+	MaxStandbys                 int               `json:"maxStandbys"`
+	SynchronousReplication      bool              `json:"synchronousReplication"`
+	UsePgrewind                 bool              `json:"usePgrewind"`
+	AdditionalWalSenders        int               `json:"additionalWalSenders"`
+	AdditionalReplicationSlots  []string          `json:"additionalReplicationSlots"`
+	InitMode                    string            `json:"initMode"`
+	PGParameters                map[string]string `json:"pgParameters"`
+	PGHBA                       []string          `json:"pgHBA"`
+	Role                        string            `json:"role"`
+	FollowConfig                *FollowConfig     `json:"followConfig"`
+	IncludeConfig               bool              `json:"includeConfig"`
+	SynchronousStandbys         []string          `json:"synchronousStandbys"`
+	ExternalSynchronousStandbys []string          `json:"externalSynchronousStandbys"`
+}
+
+// FollowConfig describes what a standby replicates from, either another DB in the
+// cluster (internal) or, in a standby cluster, an external primary
+type FollowConfig struct {
+	Type            string           `json:"type"`
+	DBUID           string           `json:"dbuid"`
+	StandbySettings *StandbySettings `json:"standbySettings"`
 }
 
 type DBStatus struct {
-	Healthy             bool     `json:"healthy"`
-	ListenAddress       string   `json:"listenAddress"`
-	Port                string   `json:"port"`
-	SynchronousStandbys []string `json:"synchronousStandbys"`
+	Healthy             bool              `json:"healthy"`
+	CurrentGeneration   int64             `json:"currentGeneration"`
+	ListenAddress       string            `json:"listenAddress"`
+	Port                string            `json:"port"`
+	SystemID            string            `json:"systemdID"` // sic, as named by stolon
+	TimelineID          uint64            `json:"timelineID"`
+	XLogPos             uint64            `json:"xLogPos"`
+	TimelinesHistory    []TimelineHistory `json:"timelinesHistory"`
+	PGParameters        map[string]string `json:"pgParameters"`
+	SynchronousStandbys []string          `json:"synchronousStandbys"`
+	OlderWalFile        string            `json:"olderWalFile"`
+}
+
+type TimelineHistory struct {
+	TimelineID  uint64 `json:"timelineID"`
+	SwitchPoint uint64 `json:"switchPoint"`
+	Reason      string `json:"reason"`
+}
+
+// Duration is a time.Duration that stolon encodes as a string, such as "5s"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+// IncompatibleError is returned for clusterdata that doesn't match our model, which
+// usually means we're running against a version of stolon we don't support
+type IncompatibleError struct {
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("incompatible clusterdata, check stolon-pgbouncer supports this version of stolon: %s", e.Reason)
+}
+
+// UnmarshalClusterdata parses clusterdata, returning an IncompatibleError if it is of a
+// format we don't understand
+func UnmarshalClusterdata(data []byte) (*Clusterdata, error) {
+	var clusterdata = &Clusterdata{}
+	if err := json.Unmarshal(data, clusterdata); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, &IncompatibleError{Reason: typeErr.Error()}
+		}
+
+		return nil, errors.Wrap(err, "failed to parse clusterdata")
+	}
+
+	if err := clusterdata.Validate(); err != nil {
+		return nil, err
+	}
+
+	return clusterdata, nil
+}
+
+// Validate checks the format version and that every section we rely on is present,
+// ensuring we never act on zero-values that stand in for missing data
+func (c Clusterdata) Validate() error {
+	if c.FormatVersion != SupportedFormatVersion {
+		return &IncompatibleError{
+			Reason: fmt.Sprintf("formatVersion is %d, but we support %d", c.FormatVersion, SupportedFormatVersion),
+		}
+	}
+
+	if c.Cluster.UID == "" {
+		return &IncompatibleError{Reason: "missing cluster"}
+	}
+
+	if c.Keepers == nil {
+		return &IncompatibleError{Reason: "missing keepers"}
+	}
+
+	if c.Dbs == nil {
+		return &IncompatibleError{Reason: "missing dbs"}
+	}
+
+	for uid, db := range c.Dbs {
+		if db.Spec.KeeperUID == "" {
+			return &IncompatibleError{Reason: fmt.Sprintf("db %s has no keeperUID", uid)}
+		}
+	}
+
+	return nil
 }
 
 func (d DB) String() string {
@@ -103,36 +303,116 @@ func (c Clusterdata) Master() DB {
 //   The master keeper is healthy, and
 //   The minimum number of synchronous standby keepers are healthy, and
 //   The number of healthy standbys (sync and async) - minimum number of synchronous standbys < the failure tolerance
+//
+// Only the first failed rule is returned: use HealthReport for the full picture.
 func (c Clusterdata) CheckHealthy(tolerateFailures int) error {
-	if reflect.DeepEqual(c.Master(), &DB{}) {
-		return errors.New("no master")
+	report := c.HealthReport(tolerateFailures)
+	if len(report.Failures) > 0 {
+		return errors.New(report.Failures[0].Error)
 	}
-	if !c.Master().Status.Healthy {
-		return errors.New("master unhealthy")
+
+	return nil
+}
+
+// DBRole describes the part a DB plays in replicating from the master
+type DBRole string
+
+const (
+	RoleMaster DBRole = "master"
+	RoleSync   DBRole = "sync"
+	RoleAsync  DBRole = "async"
+)
+
+// DBHealth is the health of a single DB, as reported by the sentinels
+type DBHealth struct {
+	KeeperUID     string `json:"keeperUid"`
+	ListenAddress string `json:"listenAddress"`
+	Role          DBRole `json:"role"`
+	Healthy       bool   `json:"healthy"`
+}
+
+// HealthFailure is a health rule that the cluster has failed. Error is the short form
+// returned by CheckHealthy, while Reason explains why the rule failed.
+type HealthFailure struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+}
+
+// HealthReport lists every DB in the cluster along with each health rule that failed,
+// in the order CheckHealthy evaluates them.
+type HealthReport struct {
+	TolerateFailures int             `json:"tolerateFailures"`
+	DBs              []DBHealth      `json:"dbs"`
+	Failures         []HealthFailure `json:"failures"`
+}
+
+// Healthy returns true if no health rules failed
+func (r HealthReport) Healthy() bool {
+	return len(r.Failures) == 0
+}
+
+// HealthReport evaluates every rule of CheckHealthy, explaining each that fails
+func (c Clusterdata) HealthReport(tolerateFailures int) HealthReport {
+	report := HealthReport{TolerateFailures: tolerateFailures, DBs: []DBHealth{}, Failures: []HealthFailure{}}
+	fail := func(err, reason string, args ...interface{}) {
+		report.Failures = append(report.Failures, HealthFailure{err, fmt.Sprintf(reason, args...)})
 	}
 
-	healthyStandbys := 0
-	for _, standby := range c.SynchronousStandbys() {
-		if standby.Status.Healthy {
-			healthyStandbys++
+	master, syncs, asyncs := c.Master(), c.SynchronousStandbys(), c.AsynchronousStandbys()
+	for _, group := range []struct {
+		role DBRole
+		dbs  []DB
+	}{
+		{RoleMaster, []DB{master}},
+		{RoleSync, syncs},
+		{RoleAsync, asyncs},
+	} {
+		sort.Slice(group.dbs, func(i, j int) bool { return group.dbs[i].Spec.KeeperUID < group.dbs[j].Spec.KeeperUID })
+		for _, db := range group.dbs {
+			if db.Spec.KeeperUID == "" {
+				continue // the master is missing, or a dummy sync replica
+			}
+
+			report.DBs = append(report.DBs, DBHealth{
+				KeeperUID: db.Spec.KeeperUID, ListenAddress: db.Status.ListenAddress, Role: group.role, Healthy: db.Status.Healthy,
+			})
 		}
 	}
 
-	if healthyStandbys < c.Cluster.Spec.MinSynchronousStandbys {
-		return errors.New("insufficient standbys")
+	if master.Spec.KeeperUID == "" {
+		fail("no master", "clusterdata has no master DB (masterDbUid=%q)", c.Proxy.Spec.MasterDbUID)
+		return report
 	}
 
-	for _, standby := range c.AsynchronousStandbys() {
-		if standby.Status.Healthy {
-			healthyStandbys++
-		}
+	if !master.Status.Healthy {
+		fail("master unhealthy", "master %s is unhealthy", master)
 	}
 
-	if healthyStandbys-c.Cluster.Spec.MinSynchronousStandbys < tolerateFailures {
-		return errors.New("insufficient standbys for failure")
+	healthySyncs, healthyAsyncs := countHealthy(syncs), countHealthy(asyncs)
+	minSyncs := c.Cluster.Spec.MinSynchronousStandbys
+	if healthySyncs < minSyncs {
+		fail("insufficient standbys", "%d of %d sync standbys are healthy, require %d",
+			healthySyncs, len(syncs), minSyncs)
 	}
 
-	return nil
+	if spare := healthySyncs + healthyAsyncs - minSyncs; spare < tolerateFailures {
+		fail("insufficient standbys for failure",
+			"%d healthy standbys beyond the %d required sync, cannot tolerate %d failures",
+			spare, minSyncs, tolerateFailures)
+	}
+
+	return report
+}
+
+func countHealthy(dbs []DB) int {
+	healthy := 0
+	for _, db := range dbs {
+		if db.Status.Healthy {
+			healthy++
+		}
+	}
+
+	return healthy
 }
 
 // SynchronousStandbys returns all the DBs that are configured as sync replicas to our
@@ -186,6 +466,8 @@ func (c Clusterdata) ListenAddresses() []string {
 
 type Stolonctl struct {
 	ClusterName, Backend, Prefix, Endpoints string
+	// Only required for the kubernetes backend
+	Kubeconfig, KubeContext, KubeNamespace string
 }
 
 func (s Stolonctl) CommandContext(ctx context.Context, args ...string) *exec.Cmd {
@@ -196,5 +478,17 @@ func (s Stolonctl) CommandContext(ctx context.Context, args ...string) *exec.Cmd
 		"--store-endpoints", s.Endpoints,
 	}
 
+	// stolon stores clusterdata in a ConfigMap, which we also assume
+	if s.Backend == "kubernetes" {
+		flags = append(flags, "--kube-resource-kind", "configmap")
+		for _, kubeFlag := range [][]string{
+			{"--kubeconfig", s.Kubeconfig}, {"--kube-context", s.KubeContext}, {"--kube-namespace", s.KubeNamespace},
+		} {
+			if kubeFlag[1] != "" {
+				flags = append(flags, kubeFlag...)
+			}
+		}
+	}
+
 	return exec.CommandContext(ctx, "stolonctl", append(args, flags...)...)
 }
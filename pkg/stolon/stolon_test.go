@@ -1,6 +1,9 @@
 package stolon
 
 import (
+	"io/ioutil"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -152,6 +155,31 @@ var _ = Describe("Clusterdata", func() {
 				})
 			})
 
+			Context("HealthReport", func() {
+				BeforeEach(func() { keeper1.Status.Healthy = false })
+
+				It("Lists every DB with its role, and every failed rule", func() {
+					report := clusterdata.HealthReport(failures)
+
+					Expect(report.Healthy()).To(BeFalse())
+					Expect(report.DBs).To(Equal([]DBHealth{
+						{KeeperUID: "keeper0", Role: RoleMaster, Healthy: true},
+						{KeeperUID: "keeper1", Role: RoleSync, Healthy: false},
+						{KeeperUID: "keeper2", Role: RoleAsync, Healthy: true},
+					}))
+					Expect(report.Failures).To(Equal([]HealthFailure{
+						{
+							Error:  "insufficient standbys",
+							Reason: "0 of 1 sync standbys are healthy, require 1",
+						},
+						{
+							Error:  "insufficient standbys for failure",
+							Reason: "0 healthy standbys beyond the 1 required sync, cannot tolerate 1 failures",
+						},
+					}))
+				})
+			})
+
 			Context("With higher desired failures", func() {
 				BeforeEach(func() { failures = 2 })
 
@@ -167,6 +195,73 @@ var _ = Describe("Clusterdata", func() {
 	})
 })
 
+var _ = Describe("UnmarshalClusterdata", func() {
+	var (
+		data        []byte
+		clusterdata *Clusterdata
+		err         error
+	)
+
+	BeforeEach(func() {
+		data, err = ioutil.ReadFile("./testdata/clusterdata.json")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		clusterdata, err = UnmarshalClusterdata(data)
+	})
+
+	It("Parses stolon clusterdata", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(clusterdata.Cluster.Spec.FailInterval.Duration).To(Equal(20 * time.Second))
+		Expect(clusterdata.Cluster.Status.Master).To(Equal("db0"))
+		Expect(clusterdata.Keepers["keeper1"].Status.PostgresBinaryVersion).To(Equal(PostgresBinaryVersion{Maj: 11, Min: 5}))
+		Expect(clusterdata.Proxy.Generation).To(BeEquivalentTo(4))
+
+		master := clusterdata.Master()
+		Expect(master.Spec.KeeperUID).To(Equal("keeper0"))
+		Expect(master.Status.TimelineID).To(BeEquivalentTo(2))
+		Expect(master.Status.XLogPos).To(BeEquivalentTo(50331896))
+		Expect(master.Status.PGParameters).To(HaveKeyWithValue("max_connections", "100"))
+		Expect(clusterdata.Dbs["db1"].Spec.FollowConfig.DBUID).To(Equal("db0"))
+	})
+
+	Context("With an unsupported formatVersion", func() {
+		BeforeEach(func() { data = []byte(`{"formatVersion":2,"cluster":{"uid":"a"},"keepers":{},"dbs":{}}`) })
+
+		It("Returns an IncompatibleError", func() {
+			Expect(err).To(BeAssignableToTypeOf(&IncompatibleError{}))
+			Expect(err.Error()).To(ContainSubstring("formatVersion is 2, but we support 1"))
+		})
+	})
+
+	Context("With missing sections", func() {
+		BeforeEach(func() { data = []byte(`{"formatVersion":1,"cluster":{"uid":"a"}}`) })
+
+		It("Returns an IncompatibleError", func() {
+			Expect(err).To(BeAssignableToTypeOf(&IncompatibleError{}))
+			Expect(err.Error()).To(ContainSubstring("missing keepers"))
+		})
+	})
+
+	Context("With fields of an unexpected type", func() {
+		BeforeEach(func() { data = []byte(`{"formatVersion":1,"dbs":[]}`) })
+
+		It("Returns an IncompatibleError", func() {
+			Expect(err).To(BeAssignableToTypeOf(&IncompatibleError{}))
+		})
+	})
+
+	Context("With invalid JSON", func() {
+		BeforeEach(func() { data = []byte(`not-json`) })
+
+		It("Returns a parse error", func() {
+			Expect(err).To(MatchError(ContainSubstring("failed to parse clusterdata")))
+		})
+	})
+})
+
 func createKeeper(uid string, healthy bool, synchronousStandbys []string) *DB {
 	return &DB{
 		Spec: DBSpec{
@@ -0,0 +1,48 @@
+package stolon
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
+)
+
+// ClusterdataUpdate pairs clusterdata with the store value it was parsed from
+type ClusterdataUpdate struct {
+	Kv          *store.KeyValue
+	Clusterdata *Clusterdata
+}
+
+// KeyValue exposes the source value, allowing stream operators to log its revision
+func (u ClusterdataUpdate) KeyValue() *store.KeyValue {
+	return u.Kv
+}
+
+// ParseClusterdata is the stage shared by every consumer of clusterdata, parsing each
+// value once so later operators can work with the Clusterdata directly. Values that fail
+// to parse, such as those from a deleted key, are logged and dropped, with incompatible
+// clusterdata logged as an error.
+func ParseClusterdata(ctx context.Context, logger kitlog.Logger, in <-chan *store.KeyValue) <-chan ClusterdataUpdate {
+	updates := streams.Map(ctx, in, func(kv *store.KeyValue) ClusterdataUpdate {
+		clusterdata, err := UnmarshalClusterdata(kv.Value)
+		if err != nil {
+			logger := logger
+			if _, ok := err.(*IncompatibleError); ok {
+				logger = level.Error(logger)
+			}
+
+			logger.Log("event", "clusterdata.parse_error", "error", err,
+				"key", string(kv.Key), "revision", kv.ModRevision)
+			return ClusterdataUpdate{Kv: kv}
+		}
+
+		return ClusterdataUpdate{Kv: kv, Clusterdata: clusterdata}
+	})
+
+	return streams.Filter(ctx, updates, func(update ClusterdataUpdate) bool {
+		return update.Clusterdata != nil
+	})
+}
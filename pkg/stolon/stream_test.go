@@ -0,0 +1,60 @@
+package stolon
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/store"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseClusterdata", func() {
+	var (
+		ctx     context.Context
+		cancel  func()
+		in      chan *store.KeyValue
+		updates <-chan ClusterdataUpdate
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		in = make(chan *store.KeyValue)
+		updates = ParseClusterdata(ctx, kitlog.NewLogfmtLogger(GinkgoWriter), in)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Parses each value into clusterdata", func() {
+		kv := &store.KeyValue{Key: []byte("clusterdata"), ModRevision: 1, Value: []byte(
+			`{"formatVersion":1,"cluster":{"uid":"a","spec":{"minSynchronousStandbys":1}},"keepers":{},"dbs":{}}`,
+		)}
+		in <- kv
+
+		var update ClusterdataUpdate
+		Eventually(updates).Should(Receive(&update))
+		Expect(update.KeyValue()).To(Equal(kv))
+		Expect(update.Clusterdata.Cluster.Spec.MinSynchronousStandbys).To(Equal(1))
+	})
+
+	It("Drops values that fail to parse or are incompatible", func() {
+		in <- &store.KeyValue{Key: []byte("clusterdata"), ModRevision: 1, Value: []byte(`not-json`)}
+		in <- &store.KeyValue{Key: []byte("clusterdata"), ModRevision: 2, Value: []byte(`{"formatVersion":2}`)}
+		in <- &store.KeyValue{Key: []byte("clusterdata"), ModRevision: 3, Value: []byte(
+			`{"formatVersion":1,"cluster":{"uid":"a"},"keepers":{},"dbs":{}}`,
+		)}
+
+		var update ClusterdataUpdate
+		Eventually(updates).Should(Receive(&update))
+		Expect(update.Kv.ModRevision).To(BeEquivalentTo(3))
+	})
+
+	It("Closes when the input closes", func() {
+		close(in)
+		Eventually(updates).Should(BeClosed())
+	})
+})
@@ -0,0 +1,421 @@
+package store
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Memory is an in-memory Store, intended for tests. It mimics etcd: every change
+// increments a global revision, deleted keys are emitted to watchers with an empty
+// value, and we keep a history of changes for watchers to resume from until compacted.
+type Memory struct {
+	mu        sync.Mutex
+	revision  int64
+	compacted int64
+	history   []*KeyValue
+	kvs       map[string]*KeyValue
+	leases    map[int64]*memoryLease
+	nextLease int64
+	watchers  map[*memoryWatcher]struct{}
+	locks     map[string]chan struct{}
+}
+
+var _ Store = &Memory{}
+
+// NewMemory returns an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		kvs:      map[string]*KeyValue{},
+		leases:   map[int64]*memoryLease{},
+		watchers: map[*memoryWatcher]struct{}{},
+		locks:    map[string]chan struct{}{},
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (*KeyValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if kv, ok := m.kvs[key]; ok {
+		return copyKv(kv), nil
+	}
+
+	return nil, nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]*KeyValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kvs := []*KeyValue{}
+	for key, kv := range m.kvs {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, copyKv(kv))
+		}
+	}
+
+	sort.Slice(kvs, func(i, j int) bool { return string(kvs[i].Key) < string(kvs[j].Key) })
+
+	return kvs, nil
+}
+
+// SentinelLeader mimics etcd, where sentinels campaign under the sentinel-leader prefix
+func (m *Memory) SentinelLeader(ctx context.Context, clusterPath string) (string, error) {
+	return ElectionLeader(ctx, m, path.Join(clusterPath, "sentinel-leader")+"/")
+}
+
+func (m *Memory) Put(ctx context.Context, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, 0)
+	return nil
+}
+
+func (m *Memory) CompareAndSwap(ctx context.Context, key string, prev *KeyValue, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, exists := m.kvs[key]
+	if prev == nil && exists || prev != nil && (!exists || current.ModRevision != prev.ModRevision) {
+		return ErrCompareFailed
+	}
+
+	m.put(key, value, 0)
+	return nil
+}
+
+// put must be called with the lock held
+func (m *Memory) put(key, value string, lease int64) {
+	m.revision++
+
+	kv := &KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: m.revision, Version: 1, Lease: lease}
+	if current, ok := m.kvs[key]; ok {
+		kv.CreateRevision, kv.Version = current.CreateRevision, current.Version+1
+		if current.Lease != lease && m.leases[current.Lease] != nil {
+			delete(m.leases[current.Lease].keys, key)
+		}
+	} else {
+		kv.CreateRevision = m.revision
+	}
+
+	if lease != 0 {
+		m.leases[lease].keys[key] = struct{}{}
+	}
+
+	m.kvs[key] = kv
+	m.history = append(m.history, copyKv(kv))
+	m.notify(kv)
+}
+
+// delete must be called with the lock held
+func (m *Memory) delete(key string) {
+	if _, ok := m.kvs[key]; !ok {
+		return
+	}
+
+	m.revision++
+	delete(m.kvs, key)
+
+	kv := &KeyValue{Key: []byte(key), ModRevision: m.revision}
+	m.history = append(m.history, kv)
+	m.notify(kv)
+}
+
+// notify must be called with the lock held
+func (m *Memory) notify(kv *KeyValue) {
+	for watcher := range m.watchers {
+		if watcher.matches(kv) {
+			watcher.push(copyKv(kv))
+		}
+	}
+}
+
+// Compact discards history before the given revision, after which watchers can no
+// longer resume from an earlier revision
+func (m *Memory) Compact(revision int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history := []*KeyValue{}
+	for _, kv := range m.history {
+		if kv.ModRevision >= revision {
+			history = append(history, kv)
+		}
+	}
+
+	m.history, m.compacted = history, revision
+}
+
+// Disconnect ends every active watch, simulating a loss of contact with the store
+func (m *Memory) Disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for watcher := range m.watchers {
+		close(watcher.disconnect)
+		delete(m.watchers, watcher)
+	}
+}
+
+func (m *Memory) Watch(ctx context.Context, key string, opt WatchOptions) <-chan WatchResponse {
+	watcher := &memoryWatcher{key: key, prefix: opt.Prefix, wake: make(chan struct{}, 1), disconnect: make(chan struct{})}
+	out := make(chan WatchResponse)
+
+	m.mu.Lock()
+	if opt.Revision > 0 && opt.Revision < m.compacted {
+		compacted := m.compacted
+		m.mu.Unlock()
+
+		go func() {
+			defer close(out)
+			select {
+			case <-ctx.Done():
+			case out <- WatchResponse{Err: ErrCompacted, CompactRevision: compacted}:
+			}
+		}()
+
+		return out
+	}
+
+	// Replay history while holding the lock, so we neither miss nor duplicate changes
+	if opt.Revision > 0 {
+		for _, kv := range m.history {
+			if kv.ModRevision >= opt.Revision && watcher.matches(kv) {
+				watcher.push(copyKv(kv))
+			}
+		}
+	}
+
+	m.watchers[watcher] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		defer close(out)
+		defer func() {
+			m.mu.Lock()
+			delete(m.watchers, watcher)
+			m.mu.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-watcher.disconnect:
+				return
+			case <-watcher.wake:
+			}
+
+			if kvs := watcher.drain(); len(kvs) > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- WatchResponse{Kvs: kvs}:
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// memoryWatcher buffers changes so that we never block writers on slow consumers
+type memoryWatcher struct {
+	key    string
+	prefix bool
+	wake   chan struct{}
+	// disconnect is closed to end the watch, as if we'd lost contact with the store
+	disconnect chan struct{}
+
+	mu      sync.Mutex
+	pending []*KeyValue
+}
+
+func (w *memoryWatcher) matches(kv *KeyValue) bool {
+	if w.prefix {
+		return strings.HasPrefix(string(kv.Key), w.key)
+	}
+
+	return string(kv.Key) == w.key
+}
+
+func (w *memoryWatcher) push(kv *KeyValue) {
+	w.mu.Lock()
+	w.pending = append(w.pending, kv)
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) drain() []*KeyValue {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	kvs := w.pending
+	w.pending = nil
+
+	return kvs
+}
+
+func (m *Memory) Grant(ctx context.Context, ttl time.Duration) (Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextLease++
+	lease := &memoryLease{memory: m, id: m.nextLease, ttl: ttl, keys: map[string]struct{}{}, done: make(chan struct{})}
+	lease.timer = time.AfterFunc(ttl, lease.expire)
+	m.leases[lease.id] = lease
+
+	return lease, nil
+}
+
+// Expire immediately expires every lease, simulating a loss of contact with the store
+func (m *Memory) Expire() {
+	m.mu.Lock()
+	leases := []*memoryLease{}
+	for _, lease := range m.leases {
+		leases = append(leases, lease)
+	}
+	m.mu.Unlock()
+
+	for _, lease := range leases {
+		lease.expire()
+	}
+}
+
+type memoryLease struct {
+	memory *Memory
+	id     int64
+	ttl    time.Duration
+	timer  *time.Timer
+	keys   map[string]struct{}
+	done   chan struct{}
+}
+
+func (l *memoryLease) Put(ctx context.Context, key, value string) error {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+
+	if l.memory.leases[l.id] == nil {
+		return errors.New("lease not found")
+	}
+
+	l.memory.put(key, value, l.id)
+	return nil
+}
+
+func (l *memoryLease) KeepAlive(ctx context.Context) (<-chan struct{}, error) {
+	lost := make(chan struct{})
+
+	go func() {
+		defer close(lost)
+		for {
+			l.memory.mu.Lock()
+			alive := l.memory.leases[l.id] != nil
+			if alive {
+				l.timer.Reset(l.ttl)
+			}
+			l.memory.mu.Unlock()
+
+			if !alive {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			case <-time.After(l.ttl / 3):
+			}
+		}
+	}()
+
+	return lost, nil
+}
+
+func (l *memoryLease) Revoke(ctx context.Context) error {
+	l.expire()
+	return nil
+}
+
+func (l *memoryLease) expire() {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+
+	if l.memory.leases[l.id] == nil {
+		return
+	}
+
+	l.timer.Stop()
+	delete(l.memory.leases, l.id)
+	close(l.done)
+
+	for key := range l.keys {
+		l.memory.delete(key)
+	}
+}
+
+func (m *Memory) Locker(key string) Locker {
+	return &memoryLock{memory: m, key: key}
+}
+
+type memoryLock struct {
+	memory *Memory
+	key    string
+	held   chan struct{}
+}
+
+func (l *memoryLock) Lock(ctx context.Context) error {
+	for {
+		l.memory.mu.Lock()
+		current, locked := l.memory.locks[l.key]
+		if !locked {
+			l.held = make(chan struct{})
+			l.memory.locks[l.key] = l.held
+			l.memory.mu.Unlock()
+
+			return nil
+		}
+		l.memory.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-current:
+		}
+	}
+}
+
+func (l *memoryLock) Unlock(ctx context.Context) error {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+
+	if l.held == nil || l.memory.locks[l.key] != l.held {
+		return errors.New("lock not held")
+	}
+
+	delete(l.memory.locks, l.key)
+	close(l.held)
+	l.held = nil
+
+	return nil
+}
+
+func copyKv(kv *KeyValue) *KeyValue {
+	copied := *kv
+	copied.Key = append([]byte{}, kv.Key...)
+	copied.Value = append([]byte{}, kv.Value...)
+
+	return &copied
+}
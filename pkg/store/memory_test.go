@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/onsi/gomega/types"
+)
+
+func matchKv(key, value string) types.GomegaMatcher {
+	return PointTo(
+		MatchFields(
+			IgnoreExtras,
+			Fields{
+				"Key":   Equal([]byte(key)),
+				"Value": Equal([]byte(value)),
+			},
+		),
+	)
+}
+
+var _ = Describe("Memory", func() {
+	var (
+		ctx    context.Context
+		cancel func()
+		memory *Memory
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		memory = NewMemory()
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Returns nil for keys that don't exist", func() {
+		Expect(memory.Get(ctx, "/missing")).To(BeNil())
+	})
+
+	It("Lists keys by prefix in sorted order", func() {
+		Expect(memory.Put(ctx, "/b/2", "two")).To(Succeed())
+		Expect(memory.Put(ctx, "/b/1", "one")).To(Succeed())
+		Expect(memory.Put(ctx, "/a/1", "other")).To(Succeed())
+
+		Expect(memory.List(ctx, "/b/")).To(
+			ConsistOf(matchKv("/b/1", "one"), matchKv("/b/2", "two")),
+		)
+	})
+
+	It("Reports the earliest sentinel to campaign as leader", func() {
+		Expect(memory.SentinelLeader(ctx, "/stolon/cluster/main")).To(Equal(""))
+
+		Expect(memory.Put(ctx, "/stolon/cluster/main/sentinel-leader/b", "sentinel-b")).To(Succeed())
+		Expect(memory.Put(ctx, "/stolon/cluster/main/sentinel-leader/a", "sentinel-a")).To(Succeed())
+
+		Expect(memory.SentinelLeader(ctx, "/stolon/cluster/main")).To(Equal("sentinel-b"))
+	})
+
+	Describe("CompareAndSwap", func() {
+		It("Creates keys only when they don't exist", func() {
+			Expect(memory.CompareAndSwap(ctx, "/key", nil, "value")).To(Succeed())
+			Expect(memory.CompareAndSwap(ctx, "/key", nil, "value")).To(Equal(ErrCompareFailed))
+		})
+
+		It("Fails if the key has changed since it was read", func() {
+			Expect(memory.Put(ctx, "/key", "initial")).To(Succeed())
+			kv, _ := memory.Get(ctx, "/key")
+
+			Expect(memory.Put(ctx, "/key", "changed")).To(Succeed())
+			Expect(memory.CompareAndSwap(ctx, "/key", kv, "clobbered")).To(Equal(ErrCompareFailed))
+			Expect(memory.Get(ctx, "/key")).To(matchKv("/key", "changed"))
+		})
+	})
+
+	Describe("Update", func() {
+		It("Applies the mutation to the current value", func() {
+			Expect(memory.Put(ctx, "/key", "value")).To(Succeed())
+			Expect(
+				Update(ctx, memory, "/key", func(value []byte) ([]byte, error) {
+					return append(value, []byte("-updated")...), nil
+				}),
+			).To(Succeed())
+
+			Expect(memory.Get(ctx, "/key")).To(matchKv("/key", "value-updated"))
+		})
+
+		It("Doesn't write values the mutation leaves unchanged", func() {
+			Expect(memory.Put(ctx, "/key", "value")).To(Succeed())
+			initial, err := memory.Get(ctx, "/key")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(
+				Update(ctx, memory, "/key", func(value []byte) ([]byte, error) { return value, nil }),
+			).To(Succeed())
+
+			Expect(memory.Get(ctx, "/key")).To(Equal(initial))
+		})
+
+		It("Fails if the key does not exist", func() {
+			Expect(
+				Update(ctx, memory, "/missing", func(value []byte) ([]byte, error) { return value, nil }),
+			).To(MatchError("key /missing does not exist"))
+		})
+	})
+
+	Describe("Watch", func() {
+		It("Emits changes under the prefix, including deletions", func() {
+			watch := memory.Watch(ctx, "/watched/", WatchOptions{Prefix: true})
+			lease, err := memory.Grant(ctx, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(memory.Put(ctx, "/ignored", "value")).To(Succeed())
+			Expect(lease.Put(ctx, "/watched/key", "value")).To(Succeed())
+			Expect(lease.Revoke(ctx)).To(Succeed())
+
+			var kvs []*KeyValue
+			Eventually(func() []*KeyValue {
+				select {
+				case resp := <-watch:
+					kvs = append(kvs, resp.Kvs...)
+				default:
+				}
+
+				return kvs
+			}).Should(
+				ConsistOf(matchKv("/watched/key", "value"), matchKv("/watched/key", "")),
+			)
+		})
+
+		It("Watches only the key unless asked for a prefix", func() {
+			watch := memory.Watch(ctx, "/key", WatchOptions{})
+
+			Expect(memory.Put(ctx, "/key/child", "ignored")).To(Succeed())
+			Expect(memory.Put(ctx, "/key", "value")).To(Succeed())
+
+			var resp WatchResponse
+			Eventually(watch).Should(Receive(&resp))
+			Expect(resp.Kvs).To(ConsistOf(matchKv("/key", "value")))
+		})
+
+		It("Replays changes when resuming from a revision", func() {
+			Expect(memory.Put(ctx, "/key", "first")).To(Succeed())
+			Expect(memory.Put(ctx, "/key", "second")).To(Succeed())
+
+			var resp WatchResponse
+			Eventually(memory.Watch(ctx, "/key", WatchOptions{Revision: 2})).Should(Receive(&resp))
+			Expect(resp.Kvs).To(ConsistOf(matchKv("/key", "second")))
+		})
+
+		It("Reports compaction of the revision we resume from", func() {
+			for _, value := range []string{"first", "second", "third"} {
+				Expect(memory.Put(ctx, "/key", value)).To(Succeed())
+			}
+
+			memory.Compact(3)
+			watch := memory.Watch(ctx, "/key", WatchOptions{Revision: 2})
+
+			var resp WatchResponse
+			Eventually(watch).Should(Receive(&resp))
+			Expect(resp.Err).To(Equal(ErrCompacted))
+			Expect(resp.CompactRevision).To(BeEquivalentTo(3))
+			Eventually(watch).Should(BeClosed())
+		})
+
+		It("Closes the channel when the context ends", func() {
+			watch := memory.Watch(ctx, "/", WatchOptions{Prefix: true})
+			cancel()
+
+			Eventually(watch).Should(BeClosed())
+		})
+	})
+
+	Describe("Lease", func() {
+		It("Removes keys once expired", func() {
+			lease, err := memory.Grant(ctx, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			lost, err := lease.KeepAlive(ctx)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(lease.Put(ctx, "/key", "value")).To(Succeed())
+			memory.Expire()
+
+			Eventually(lost).Should(BeClosed())
+			Expect(memory.Get(ctx, "/key")).To(BeNil())
+			Expect(lease.Put(ctx, "/key", "value")).NotTo(Succeed())
+		})
+	})
+
+	Describe("Locker", func() {
+		It("Provides exclusive access until unlocked", func() {
+			first, second := memory.Locker("/lock"), memory.Locker("/lock")
+			Expect(first.Lock(ctx)).To(Succeed())
+
+			timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer timeoutCancel()
+
+			Expect(second.Lock(timeoutCtx)).To(Equal(context.DeadlineExceeded))
+
+			Expect(first.Unlock(ctx)).To(Succeed())
+			Expect(second.Lock(ctx)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Registration publishes a value to a key that is attached to a lease, which we keep
+// alive for as long as the registration's context. Should we stop, or lose contact with
+// the store for longer than the TTL, the key is removed.
+type Registration struct {
+	ctx   context.Context
+	store Store
+	key   string
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	lease Lease
+	value *string
+}
+
+// NewRegistration creates a registration for the given key. No lease is granted until
+// the first Put.
+func NewRegistration(ctx context.Context, store Store, key string, ttl time.Duration) *Registration {
+	return &Registration{ctx: ctx, store: store, key: key, ttl: ttl}
+}
+
+// Put sets the value of our key, granting a new lease if we don't have a live one. As
+// we remember what we last wrote under our lease, calling Put with an unchanged value is
+// cheap.
+func (r *Registration) Put(ctx context.Context, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lease != nil && r.value != nil && *r.value == value {
+		return nil
+	}
+
+	lease, err := r.ensureLease(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := lease.Put(ctx, r.key, value); err != nil {
+		return err
+	}
+
+	r.value = &value
+	return nil
+}
+
+// Revoke removes our key by revoking its lease, which is useful on shutdown to avoid
+// waiting for the TTL to expire.
+func (r *Registration) Revoke(ctx context.Context) error {
+	r.mu.Lock()
+	lease := r.lease
+	r.lease, r.value = nil, nil
+	r.mu.Unlock()
+
+	if lease == nil {
+		return nil
+	}
+
+	return lease.Revoke(ctx)
+}
+
+// ensureLease must be called with the lock held
+func (r *Registration) ensureLease(ctx context.Context) (Lease, error) {
+	if r.lease != nil {
+		return r.lease, nil
+	}
+
+	lease, err := r.store.Grant(ctx, r.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	lost, err := lease.KeepAlive(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keep alive channel closes once our lease expires or our context ends, at which
+	// point the next Put should grant a new lease.
+	go func() {
+		<-lost
+
+		r.mu.Lock()
+		if r.lease == lease {
+			r.lease, r.value = nil, nil
+		}
+		r.mu.Unlock()
+	}()
+
+	r.lease = lease
+	return r.lease, nil
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registration", func() {
+	var (
+		ctx          context.Context
+		cancel       func()
+		memory       *Memory
+		registration *Registration
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		memory = NewMemory()
+		registration = NewRegistration(ctx, memory, "/proxies/proxy", time.Minute)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Publishes the value attached to a lease", func() {
+		Expect(registration.Put(ctx, "initial")).To(Succeed())
+		Expect(memory.Get(ctx, "/proxies/proxy")).To(matchKv("/proxies/proxy", "initial"))
+
+		Expect(registration.Put(ctx, "changed")).To(Succeed())
+		Expect(memory.Get(ctx, "/proxies/proxy")).To(matchKv("/proxies/proxy", "changed"))
+	})
+
+	It("Removes the key when revoked", func() {
+		Expect(registration.Put(ctx, "initial")).To(Succeed())
+		Expect(registration.Revoke(ctx)).To(Succeed())
+		Expect(memory.Get(ctx, "/proxies/proxy")).To(BeNil())
+	})
+
+	It("Re-publishes under a new lease once the old one expires", func() {
+		Expect(registration.Put(ctx, "initial")).To(Succeed())
+		memory.Expire()
+
+		// Callers publish periodically, so we'll recover on the first Put after noticing
+		// our lease was lost
+		Eventually(func() (*KeyValue, error) {
+			Expect(registration.Put(ctx, "initial")).To(Succeed())
+			return memory.Get(ctx, "/proxies/proxy")
+		}).Should(
+			matchKv("/proxies/proxy", "initial"),
+		)
+	})
+})
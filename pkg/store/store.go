@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/pkg/errors"
+)
+
+// KeyValue is a key and its value, along with the revisions that allow us to order
+// changes. We reuse etcd's representation, which every backend can populate, so that
+// streams of values are independent of the backend that produced them. Deleted keys
+// are represented with an empty value.
+type KeyValue = mvccpb.KeyValue
+
+// ErrCompareFailed is returned by CompareAndSwap when the key has changed since it was
+// read
+var ErrCompareFailed = errors.New("key has changed since it was read")
+
+// ErrCompacted is reported by Watch when the revision we asked to resume from has been
+// compacted away, after which the watch ends. Callers should read the current value and
+// watch again from no earlier than the response's CompactRevision.
+var ErrCompacted = errors.New("required watch revision has been compacted")
+
+// Store is the subset of a key value store that we require, as implemented by each of
+// the stolon store backends.
+type Store interface {
+	// Get returns the value of the key, or nil if it does not exist
+	Get(ctx context.Context, key string) (*KeyValue, error)
+	// List returns every key with the given prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]*KeyValue, error)
+	// Put unconditionally sets the value of the key
+	Put(ctx context.Context, key, value string) error
+	// CompareAndSwap sets the value of the key only if it has not changed since prev was
+	// read, where a nil prev requires that the key does not exist. Returns
+	// ErrCompareFailed if the key has changed.
+	CompareAndSwap(ctx context.Context, key string, prev *KeyValue, value string) error
+	// Watch emits changes to the key, or every key with the given prefix, until the
+	// context is cancelled, at which point the channel is closed
+	Watch(ctx context.Context, key string, opt WatchOptions) <-chan WatchResponse
+	// Grant creates a lease that expires unless kept alive within the TTL
+	Grant(ctx context.Context, ttl time.Duration) (Lease, error)
+	// Locker returns a distributed lock held at the given key
+	Locker(key string) Locker
+	// SentinelLeader returns the UID of the sentinel that stolon has elected leader of the
+	// cluster stored at clusterPath, or an empty string if there is none. Stolon elects its
+	// leader differently on each backend.
+	SentinelLeader(ctx context.Context, clusterPath string) (string, error)
+}
+
+// WatchOptions configures Watch
+type WatchOptions struct {
+	// Prefix watches every key that begins with the key, rather than the key alone
+	Prefix bool
+	// Revision resumes watching from this revision, inclusive, replaying changes made
+	// since. Zero watches from the current revision.
+	Revision int64
+}
+
+// WatchResponse is a batch of changes emitted by Watch. Backends may report errors that
+// they retry internally, in which case the response has no changes. Should the revision
+// we resumed from have been compacted, Err is ErrCompacted and CompactRevision is the
+// earliest revision we can watch from.
+type WatchResponse struct {
+	Kvs             []*KeyValue
+	Err             error
+	CompactRevision int64
+}
+
+// Lease is granted with a TTL, and removes any keys put against it once it expires or
+// is revoked.
+type Lease interface {
+	// Put sets the value of the key, attaching it to the lease
+	Put(ctx context.Context, key, value string) error
+	// KeepAlive renews the lease until the context is cancelled. The returned channel is
+	// closed once we stop renewing, either because of the context or because the lease
+	// was lost.
+	KeepAlive(ctx context.Context) (<-chan struct{}, error)
+	// Revoke ends the lease, removing all its keys
+	Revoke(ctx context.Context) error
+}
+
+// Locker provides exclusive access to a resource across processes
+type Locker interface {
+	Lock(context.Context) error
+	Unlock(context.Context) error
+}
+
+// ElectionLeader returns the value of the earliest created key under the prefix, which
+// belongs to the leader of an etcd style election. Candidates campaign by creating a key
+// under the prefix attached to their lease.
+func ElectionLeader(ctx context.Context, s Store, prefix string) (string, error) {
+	kvs, err := s.List(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	var leader *KeyValue
+	for _, kv := range kvs {
+		if leader == nil || kv.CreateRevision < leader.CreateRevision {
+			leader = kv
+		}
+	}
+
+	if leader == nil {
+		return "", nil
+	}
+
+	return string(leader.Value), nil
+}
+
+// Update applies the mutation to the current value of the key, retrying should the key
+// change between our read and write. This prevents us clobbering writes from other
+// processes, such as the stolon sentinels that regularly update clusterdata. Mutations
+// that leave the value unchanged are not written.
+func Update(ctx context.Context, s Store, key string, mutate func([]byte) ([]byte, error)) error {
+	for {
+		kv, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if kv == nil {
+			return errors.Errorf("key %s does not exist", key)
+		}
+
+		value, err := mutate(kv.Value)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(value, kv.Value) {
+			return nil
+		}
+
+		if err := s.CompareAndSwap(ctx, key, kv, string(value)); err != ErrCompareFailed {
+			return err
+		}
+	}
+}
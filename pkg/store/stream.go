@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
+)
+
+// StreamOptions should be passed to NewStream to construct a new streaming channel
+type StreamOptions struct {
+	Ctx          context.Context
+	Keys         []string
+	PollInterval time.Duration
+	GetTimeout   time.Duration
+	// Watches that keep failing are retried with exponential backoff
+	WatchRetryInterval    time.Duration
+	WatchRetryMaxInterval time.Duration
+	WatchRetryJitter      float64
+	// OnPoll, if provided, is called with the latency and result of every poll
+	OnPoll func(time.Duration, error)
+	// OnWatchEvent, if provided, is called for every change received from a watch
+	OnWatchEvent func()
+	// OnWatchRestart, if provided, is called each time we restart a watch, with the
+	// reason it ended: error, compacted or closed
+	OnWatchRestart func(reason string)
+}
+
+// NewStream accepts a store with which we watch for changes to our selected keys and
+// push them down the output channel. The advantages to using this interface over what
+// the store already provides is the polling interval, which ensures on boot that we
+// receive the initial value, along with at polling intervals.
+//
+// We watch each key individually, so we only receive the changes we care about. Should
+// a watch end, we resume it from the revision after the last change we saw, and if that
+// revision has been compacted we re-read the key before watching from what we read.
+// Cancelling the context stops the stream even if nobody is reading from it.
+func NewStream(logger kitlog.Logger, client Store, opt StreamOptions) (<-chan *KeyValue, <-chan struct{}) {
+	logger = kitlog.With(logger, "keys", strings.Join(opt.Keys, ","))
+	out, done := make(chan *KeyValue), make(chan struct{})
+
+	ctx, cancel := context.WithCancel(opt.Ctx)
+	var wg sync.WaitGroup
+	wg.Add(len(opt.Keys) + 1)
+
+	backoff := streams.Backoff{
+		Interval: opt.WatchRetryInterval, MaxInterval: opt.WatchRetryMaxInterval, Jitter: opt.WatchRetryJitter,
+	}
+
+	get := func(key string) (*KeyValue, error) {
+		getCtx, getCtxCancel := context.WithTimeout(ctx, opt.GetTimeout)
+		defer getCtxCancel()
+
+		return client.Get(getCtx, key)
+	}
+
+	// Start watching the store, pushing each change into the out stream
+	for _, key := range opt.Keys {
+		go func(key string) {
+			defer cancel()
+			defer wg.Done()
+
+			logger := kitlog.With(logger, "key", key)
+
+			var revision int64
+			var failures int // consecutive watches that ended without delivering any changes
+		Watch:
+			for {
+				logger.Log("event", "watch_start", "revision", revision)
+
+				reason, delivered := "closed", false
+				for resp := range client.Watch(ctx, key, WatchOptions{Revision: revision}) {
+					if resp.Err == ErrCompacted {
+						logger.Log("event", "watch_compacted", "revision", revision, "compact_revision", resp.CompactRevision,
+							"msg", "watch revision has been compacted, re-reading key")
+
+						reason, revision = "compacted", resp.CompactRevision
+
+						// If we fail to read, we'll rely on polling to catch us up
+						kv, err := get(key)
+						if err != nil {
+							logger.Log("error", err, "msg", "failed to re-read key after compaction")
+							continue
+						}
+
+						if kv != nil {
+							select {
+							case out <- kv:
+							case <-ctx.Done():
+								return
+							}
+
+							if kv.ModRevision >= revision {
+								revision = kv.ModRevision + 1
+							}
+						}
+
+						continue
+					}
+
+					if resp.Err != nil {
+						reason = "error"
+						logger.Log("error", resp.Err, "msg", "received error from store watcher")
+					}
+
+					delivered = delivered || len(resp.Kvs) > 0
+
+					for _, kv := range resp.Kvs {
+						if opt.OnWatchEvent != nil {
+							opt.OnWatchEvent()
+						}
+
+						select {
+						case out <- kv:
+						case <-ctx.Done():
+							return
+						}
+
+						revision = kv.ModRevision + 1
+					}
+				}
+
+				if delivered {
+					failures = 0
+				}
+
+				delay := backoff.Delay(failures)
+				failures++
+
+				select {
+				case <-ctx.Done():
+					logger.Log("event", "watch_stop", "msg", "context expired, stopping stream")
+					break Watch
+				case <-time.After(delay):
+					logger.Log("event", "watch_restart", "reason", reason, "revision", revision, "failures", failures)
+					if opt.OnWatchRestart != nil {
+						opt.OnWatchRestart(reason)
+					}
+				}
+			}
+		}(key)
+	}
+
+	// Store watches retry indefinitely, but the abstraction hides errors. By manually
+	// polling for changes on a regular interval we ensure we'll at least see logs if the
+	// stream breaks down, as our manual get will fail.
+	go func() {
+		defer cancel()
+		defer wg.Done()
+
+	Poll:
+		for {
+			logger.Log("event", "poll_start")
+			for _, key := range opt.Keys {
+				startedAt := time.Now()
+				kv, err := get(key)
+				if opt.OnPoll != nil {
+					opt.OnPoll(time.Since(startedAt), err)
+				}
+
+				if err != nil {
+					logger.Log("error", err, "key", key, "msg", "failed to poll store")
+					continue
+				}
+
+				if kv == nil {
+					logger.Log("error", "poll_missing_store_value", "key", key,
+						"msg", "key has no value (is supervise running?)")
+					continue
+				}
+
+				select {
+				case out <- kv:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				logger.Log("event", "poll_stop", "msg", "context expired, stopping stream")
+				break Poll
+			case <-time.After(opt.PollInterval):
+				// poll again
+			}
+		}
+	}()
+
+	// Notify the done channel once the wait group completes
+	go func() {
+		wg.Wait()
+
+		close(out)
+		close(done)
+	}()
+
+	return out, done
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewStream", func() {
+	var (
+		ctx      context.Context
+		cancel   func()
+		memory   *Memory
+		stream   <-chan *KeyValue
+		done     <-chan struct{}
+		restarts chan string
+		polls    chan error
+		compact  bool
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		memory = NewMemory()
+		restarts, polls, compact = make(chan string, 10), make(chan error, 10), false
+	})
+
+	JustBeforeEach(func() {
+		stream, done = NewStream(
+			kitlog.NewLogfmtLogger(GinkgoWriter),
+			memory,
+			StreamOptions{
+				Ctx:                ctx,
+				Keys:               []string{"/key"},
+				PollInterval:       time.Minute,
+				WatchRetryInterval: 50 * time.Millisecond,
+				GetTimeout:         time.Second,
+				OnPoll:             func(_ time.Duration, err error) { polls <- err },
+				OnWatchRestart:     func(reason string) { restarts <- reason },
+			},
+		)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Closes channel when context terminates", func() {
+		cancel()
+		Eventually(stream).Should(BeClosed())
+	})
+
+	Context("When key exists", func() {
+		BeforeEach(func() {
+			Expect(memory.Put(ctx, "/key", "initial")).To(Succeed())
+		})
+
+		It("Stops when context terminates, even if nothing is reading", func() {
+			Eventually(polls).Should(Receive(BeNil()))
+			cancel()
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("Reports each poll", func() {
+			Eventually(stream).Should(Receive(matchKv("/key", "initial")))
+			Expect(polls).To(Receive(BeNil()))
+		})
+
+		It("Emits initial value and changes", func() {
+			Eventually(stream).Should(Receive(matchKv("/key", "initial")))
+
+			Expect(memory.Put(ctx, "/other", "ignored")).To(Succeed())
+			Expect(memory.Put(ctx, "/key", "changed")).To(Succeed())
+			Eventually(stream).Should(Receive(matchKv("/key", "changed")))
+		})
+
+		Context("When the watch is interrupted", func() {
+			JustBeforeEach(func() {
+				Eventually(stream).Should(Receive(matchKv("/key", "initial")))
+				Expect(memory.Put(ctx, "/key", "watched")).To(Succeed())
+				Eventually(stream).Should(Receive(matchKv("/key", "watched")))
+
+				memory.Disconnect()
+				Expect(memory.Put(ctx, "/key", "missed")).To(Succeed())
+				Expect(memory.Put(ctx, "/key", "latest")).To(Succeed())
+
+				// Compact before our watch retry interval elapses, so we resume from a
+				// compacted revision
+				if compact {
+					memory.Compact(5)
+				}
+			})
+
+			It("Resumes from the revision after the last change", func() {
+				Eventually(stream).Should(Receive(matchKv("/key", "missed")))
+				Eventually(stream).Should(Receive(matchKv("/key", "latest")))
+				Expect(restarts).To(Receive(Equal("closed")))
+			})
+
+			Context("And the revision was compacted", func() {
+				BeforeEach(func() {
+					compact = true
+				})
+
+				It("Re-reads the key", func() {
+					Eventually(stream).Should(Receive(matchKv("/key", "latest")))
+					Expect(restarts).To(Receive(Equal("closed")))
+					Eventually(restarts).Should(Receive(Equal("compacted")))
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,33 @@
+package streams
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff calculates how long to wait before each retry, doubling the wait after every
+// failure. Jitter spreads out the many processes that may be retrying the same failure,
+// such as every supervise losing contact with the store at once.
+type Backoff struct {
+	Interval    time.Duration // wait before the first retry
+	MaxInterval time.Duration // limit for our exponentially increasing wait, retrying at a fixed Interval if no greater
+	Jitter      float64       // fraction of each wait that is randomly removed, between 0 and 1
+}
+
+// Delay returns how long to wait before the given retry, counting from zero
+func (b Backoff) Delay(retry int) time.Duration {
+	delay := b.Interval
+	for i := 0; i < retry && delay < b.MaxInterval; i++ {
+		delay *= 2
+	}
+
+	if b.MaxInterval > b.Interval && delay > b.MaxInterval {
+		delay = b.MaxInterval
+	}
+
+	if b.Jitter > 0 {
+		delay -= time.Duration(b.Jitter * rand.Float64() * float64(delay))
+	}
+
+	return delay
+}
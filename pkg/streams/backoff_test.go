@@ -0,0 +1,39 @@
+package streams_test
+
+import (
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backoff", func() {
+	It("Doubles the interval up to the maximum", func() {
+		backoff := streams.Backoff{Interval: time.Second, MaxInterval: 5 * time.Second}
+
+		delays := []time.Duration{}
+		for retry := 0; retry < 5; retry++ {
+			delays = append(delays, backoff.Delay(retry))
+		}
+
+		Expect(delays).To(Equal([]time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second,
+		}))
+	})
+
+	It("Retries at a fixed interval without a larger maximum", func() {
+		backoff := streams.Backoff{Interval: time.Second}
+		Expect(backoff.Delay(10)).To(Equal(time.Second))
+	})
+
+	It("Removes up to the jitter fraction of each delay", func() {
+		backoff := streams.Backoff{Interval: time.Second, MaxInterval: time.Minute, Jitter: 0.5}
+		for i := 0; i < 100; i++ {
+			Expect(backoff.Delay(2)).To(
+				And(BeNumerically(">", 2*time.Second), BeNumerically("<=", 4*time.Second)),
+			)
+		}
+	})
+})
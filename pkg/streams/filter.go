@@ -2,16 +2,16 @@ package streams
 
 import (
 	"bytes"
+	"context"
+	"time"
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	kitlog "github.com/go-kit/kit/log"
 )
 
-type Filter func(kitlog.Logger, <-chan *mvccpb.KeyValue) <-chan *mvccpb.KeyValue
-
 // Tap intercepts the given channel and passes values into an operation function.
-func Tap(in <-chan *mvccpb.KeyValue, op func(*mvccpb.KeyValue)) <-chan *mvccpb.KeyValue {
-	out := make(chan *mvccpb.KeyValue)
+func Tap[T any](in <-chan T, op func(T)) <-chan T {
+	out := make(chan T)
 	go func() {
 		for kv := range in {
 			op(kv)
@@ -72,6 +72,93 @@ func RevisionFilter(logger kitlog.Logger, in <-chan *mvccpb.KeyValue) <-chan *mv
 	return out
 }
 
+// Debounce creates a new channel from `in` that holds back values until they've been
+// stable for the given window. Stability is judged by the key function, allowing callers
+// to debounce on whatever part of the value matters to them. While waiting, the latest
+// value with the pending key replaces any earlier one without restarting the window,
+// while a value with a different key restarts it.
+//
+// The first value, and any value whose key matches the last we emitted, is sent without
+// delay. A pending value is dropped if `in` closes or the context ends before the window
+// elapses.
+func Debounce[T any, K comparable](ctx context.Context, logger kitlog.Logger, in <-chan T, window time.Duration, key func(T) K) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		var (
+			emitted             bool
+			lastKey, pendingKey K
+			pending             T
+			isPending           bool
+			stable              <-chan time.Time
+		)
+
+		emit := func(value T, valueKey K) bool {
+			var zero T
+			emitted, lastKey = true, valueKey
+			pending, isPending, stable = zero, false, nil
+
+			return send(ctx, out, value)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-in:
+				if !ok {
+					logger.Log("event", "close", "msg", "in channel closed, closing out")
+					return
+				}
+
+				valueKey := key(value)
+				switch {
+				case !emitted || valueKey == lastKey:
+					if !emit(value, valueKey) {
+						return
+					}
+				case isPending && valueKey == pendingKey:
+					pending = value
+				default:
+					withValue(logger, value).Log("event", "debounce_start", "window", window.Seconds())
+					pending, pendingKey, isPending, stable = value, valueKey, true, time.After(window)
+				}
+			case <-stable:
+				withValue(logger, pending).Log("event", "debounce_stable")
+				if !emit(pending, pendingKey) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// keyed is implemented by values derived from a store kv, such as parsed clusterdata,
+// allowing generic operators to log which kv they're handling
+type keyed interface {
+	KeyValue() *mvccpb.KeyValue
+}
+
+// withValue adds the key and revision to our logger, if the value has them
+func withValue(logger kitlog.Logger, value interface{}) kitlog.Logger {
+	switch v := value.(type) {
+	case *mvccpb.KeyValue:
+		if v != nil {
+			return withKv(logger, v)
+		}
+	case keyed:
+		if kv := v.KeyValue(); kv != nil {
+			return withKv(logger, kv)
+		}
+	}
+
+	return logger
+}
+
 func withKv(logger kitlog.Logger, kv *mvccpb.KeyValue) kitlog.Logger {
 	return kitlog.With(logger, "key", string(kv.Key), "revision", kv.ModRevision)
 }
@@ -1,7 +1,9 @@
 package streams_test
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	kitlog "github.com/go-kit/kit/log"
@@ -91,6 +93,59 @@ var _ = Describe("Stream filters", func() {
 			})
 		})
 	})
+
+	Describe("Debounce", func() {
+		var (
+			in  chan *mvccpb.KeyValue
+			out <-chan *mvccpb.KeyValue
+		)
+
+		BeforeEach(func() {
+			in = make(chan *mvccpb.KeyValue)
+			out = streams.Debounce(
+				context.Background(), kitlog.NewLogfmtLogger(GinkgoWriter), in, 100*time.Millisecond,
+				func(kv *mvccpb.KeyValue) string { return string(kv.Value) },
+			)
+		})
+
+		AfterEach(func() {
+			close(in)
+			Eventually(out).Should(BeClosed())
+		})
+
+		It("Sends the first value immediately", func() {
+			in <- makeKv("/key", "a", 1)
+			Eventually(out, 50*time.Millisecond).Should(Receive(Equal(makeKv("/key", "a", 1))))
+		})
+
+		It("Sends values matching the last sent immediately", func() {
+			in <- makeKv("/key", "a", 1)
+			Eventually(out).Should(Receive())
+
+			in <- makeKv("/key", "a", 2)
+			Eventually(out, 50*time.Millisecond).Should(Receive(Equal(makeKv("/key", "a", 2))))
+		})
+
+		It("Holds back changes until stable, emitting the latest", func() {
+			in <- makeKv("/key", "a", 1)
+			Eventually(out).Should(Receive())
+
+			in <- makeKv("/key", "b", 2)
+			in <- makeKv("/key", "b", 3)
+			Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+			Eventually(out).Should(Receive(Equal(makeKv("/key", "b", 3))))
+		})
+
+		It("Drops flapping changes that revert before the window", func() {
+			in <- makeKv("/key", "a", 1)
+			Eventually(out).Should(Receive())
+
+			in <- makeKv("/key", "b", 2)
+			in <- makeKv("/key", "a", 3)
+			Eventually(out).Should(Receive(Equal(makeKv("/key", "a", 3))))
+			Consistently(out, 200*time.Millisecond).ShouldNot(Receive())
+		})
+	})
 })
 
 // collect creates an input channel and pipes the contents using the pipe function, then
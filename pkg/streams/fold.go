@@ -2,45 +2,97 @@ package streams
 
 import (
 	"context"
+	"fmt"
 	"time"
 
-	"github.com/coreos/etcd/mvcc/mvccpb"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
 
-type Operation func(context.Context, *mvccpb.KeyValue) error
+type Operation[T any] func(context.Context, T) error
 
 // RetryFoldOptions provides configuration to the RetryFold function
 type RetryFoldOptions struct {
-	Ctx      context.Context
-	Interval time.Duration
-	Timeout  time.Duration
+	Ctx         context.Context
+	Interval    time.Duration // wait before retrying a failed operation
+	MaxInterval time.Duration // limit for our exponentially increasing wait, defaulting to a fixed Interval
+	Jitter      float64       // fraction of each wait that is randomly removed
+	Timeout     time.Duration
+
+	// Once an operation has failed MaxAttempts times in a row, we send a
+	// RetryExhaustedError to Errors. We continue retrying regardless, but this allows
+	// callers to escalate. Zero never escalates.
+	MaxAttempts int
+	Errors      chan<- error
+
+	OnAttempt func(error)         // called with the result of every attempt
+	OnSuccess func(time.Duration) // called with the time taken to succeed, including any retries
+}
+
+// RetryExhaustedError is sent to RetryFoldOptions.Errors once we've failed MaxAttempts
+// times in a row
+type RetryExhaustedError struct {
+	Attempts int
+	Err      error
 }
 
-// RetryFold consumes all kvs from the `in` channel and attempts to run an operation on
-// them, retrying that operation ad-infinitum in case of errors.
-func RetryFold(logger kitlog.Logger, in <-chan *mvccpb.KeyValue, opt RetryFoldOptions, op Operation) error {
-	for kv := range in {
-		logger := level.Debug(withKv(logger, kv))
+func (e *RetryExhaustedError) Error() string {
+	return fmt.Sprintf("operation failed %d times in a row: %s", e.Attempts, e.Err)
+}
+
+// RetryFold consumes all values from the `in` channel and attempts to run an operation
+// on them, retrying that operation ad-infinitum in case of errors. Should a new value
+// arrive while we wait to retry, we apply that instead, as it supersedes the one that
+// failed.
+func RetryFold[T any](logger kitlog.Logger, in <-chan T, opt RetryFoldOptions, op Operation[T]) error {
+	backoff := Backoff{Interval: opt.Interval, MaxInterval: opt.MaxInterval, Jitter: opt.Jitter}
+
+	for value := range in {
+		logger := level.Debug(withValue(logger, value))
+
+		// Failures are counted across values, as a stream of new values that each fail is
+		// no less stuck than retrying one
+		var failures int
+		var firstAttemptAt = time.Now()
 
 	NextAttempt:
 		logger.Log("event", "operation.run")
 		ctx, cancel := context.WithTimeout(opt.Ctx, opt.Timeout)
-		err := op(ctx, kv)
+		err := op(ctx, value)
 		cancel()
 
+		if opt.OnAttempt != nil {
+			opt.OnAttempt(err)
+		}
+
 		if err != nil {
-			logger.Log("event", "operation.error", "error", err.Error())
+			failures++
+			logger.Log("event", "operation.error", "error", err.Error(), "failures", failures)
+
+			if failures == opt.MaxAttempts && opt.Errors != nil {
+				select {
+				case <-opt.Ctx.Done():
+				case opt.Errors <- &RetryExhaustedError{Attempts: failures, Err: err}:
+				}
+			}
+
 			select {
-			case newKv := <-in:
-				kv = newKv
-			case <-time.After(opt.Interval):
+			case newValue, ok := <-in:
+				if !ok {
+					return nil
+				}
+
+				value = newValue
+			case <-time.After(backoff.Delay(failures - 1)):
 				logger.Log("event", "operation.retry")
 			}
 
 			goto NextAttempt
 		}
+
+		if opt.OnSuccess != nil {
+			opt.OnSuccess(time.Since(firstAttemptAt))
+		}
 	}
 
 	return nil
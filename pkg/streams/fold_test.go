@@ -0,0 +1,94 @@
+package streams_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RetryFold", func() {
+	var (
+		ctx     context.Context
+		cancel  func()
+		in      chan *mvccpb.KeyValue
+		errs    chan error
+		opt     streams.RetryFoldOptions
+		applied chan string
+		fail    int
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		in, errs, applied, fail = make(chan *mvccpb.KeyValue), make(chan error, 1), make(chan string, 10), 0
+		opt = streams.RetryFoldOptions{
+			Ctx:         ctx,
+			Interval:    time.Millisecond,
+			MaxInterval: 10 * time.Millisecond,
+			Timeout:     time.Second,
+			MaxAttempts: 3,
+			Errors:      errs,
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	JustBeforeEach(func() {
+		go streams.RetryFold(
+			kitlog.NewLogfmtLogger(GinkgoWriter), in, opt,
+			func(ctx context.Context, kv *mvccpb.KeyValue) error {
+				if fail > 0 {
+					fail--
+					return errors.New("failed")
+				}
+
+				applied <- string(kv.Value)
+				return nil
+			},
+		)
+	})
+
+	Context("When the operation eventually succeeds", func() {
+		var attempts, successes chan interface{}
+
+		BeforeEach(func() {
+			fail = 2
+			attempts, successes = make(chan interface{}, 10), make(chan interface{}, 10)
+			opt.OnAttempt = func(err error) { attempts <- err }
+			opt.OnSuccess = func(elapsed time.Duration) { successes <- elapsed }
+		})
+
+		It("Retries until applied, without escalating", func() {
+			in <- makeKv("/key", "value", 1)
+
+			Eventually(applied).Should(Receive(Equal("value")))
+			Expect(attempts).To(HaveLen(3))
+			Expect(successes).To(HaveLen(1))
+			Consistently(errs).ShouldNot(Receive())
+		})
+	})
+
+	Context("When the operation keeps failing", func() {
+		BeforeEach(func() {
+			fail = 5
+		})
+
+		It("Escalates after MaxAttempts while continuing to retry", func() {
+			in <- makeKv("/key", "value", 1)
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError("operation failed 3 times in a row: failed"))
+
+			Eventually(applied).Should(Receive(Equal("value")))
+		})
+	})
+})
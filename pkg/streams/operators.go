@@ -0,0 +1,244 @@
+package streams
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The operators in this file work on channels of any type. Each runs until its input
+// closes or the context is cancelled, then closes its output. Values are sent with
+// respect to the context, so an operator will never block forever on a consumer that
+// has gone away.
+
+// send pushes the value to out unless the context ends first, returning false if so
+func send[T any](ctx context.Context, out chan<- T, value T) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case out <- value:
+		return true
+	}
+}
+
+// Map applies the function to every value
+func Map[T, U any](ctx context.Context, in <-chan T, fn func(T) U) <-chan U {
+	out := make(chan U)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-in:
+				if !ok || !send(ctx, out, fn(value)) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Filter emits only the values for which keep returns true
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+
+				if keep(value) && !send(ctx, out, value) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Throttle emits at most one value per interval. The first value is sent immediately,
+// after which we emit the latest value we received at the end of each interval, if
+// any, dropping those it replaced.
+func Throttle[T any](ctx context.Context, in <-chan T, interval time.Duration) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		var (
+			pending    T
+			hasPending bool
+			open       <-chan time.Time // fires once we may emit again
+		)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+
+				if open != nil {
+					pending, hasPending = value, true
+					continue
+				}
+
+				if !send(ctx, out, value) {
+					return
+				}
+
+				open = time.After(interval)
+			case <-open:
+				open = nil
+				if hasPending {
+					if !send(ctx, out, pending) {
+						return
+					}
+
+					var zero T
+					pending, hasPending, open = zero, false, time.After(interval)
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Merge emits the values from every input, closing once all inputs have closed
+func Merge[T any](ctx context.Context, ins ...<-chan T) <-chan T {
+	out := make(chan T)
+
+	var wg sync.WaitGroup
+	wg.Add(len(ins))
+	for _, in := range ins {
+		go func(in <-chan T) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case value, ok := <-in:
+					if !ok || !send(ctx, out, value) {
+						return
+					}
+				}
+			}
+		}(in)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// Latest never blocks its input, holding only the most recent value until the consumer
+// is ready to receive it. Slow consumers skip intermediate values, which suits streams
+// where only the current state matters, such as clusterdata.
+func Latest[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		var (
+			latest    T
+			hasLatest bool
+		)
+
+		for {
+			// A nil channel blocks forever, disabling the send until we have a value
+			var sendOut chan<- T
+			if hasLatest {
+				sendOut = out
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-in:
+				if !ok {
+					if hasLatest {
+						send(ctx, out, latest)
+					}
+
+					return
+				}
+
+				latest, hasLatest = value, true
+			case sendOut <- latest:
+				var zero T
+				latest, hasLatest = zero, false
+			}
+		}
+	}()
+
+	return out
+}
+
+// Buffer queues up to size values while the consumer is busy, only blocking the input
+// once the queue is full. Unlike Latest, no values are dropped.
+func Buffer[T any](ctx context.Context, in <-chan T, size int) <-chan T {
+	out := make(chan T)
+	if size < 1 {
+		size = 1
+	}
+
+	go func() {
+		defer close(out)
+
+		queue := make([]T, 0, size)
+		for {
+			var (
+				recvIn  <-chan T
+				sendOut chan<- T
+				next    T
+			)
+
+			if len(queue) < size {
+				recvIn = in
+			}
+
+			if len(queue) > 0 {
+				sendOut, next = out, queue[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case value, ok := <-recvIn:
+				if !ok {
+					for _, value := range queue {
+						if !send(ctx, out, value) {
+							return
+						}
+					}
+
+					return
+				}
+
+				queue = append(queue, value)
+			case sendOut <- next:
+				queue = queue[1:]
+			}
+		}
+	}()
+
+	return out
+}
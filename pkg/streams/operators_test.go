@@ -0,0 +1,159 @@
+package streams_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocardless/stolon-pgbouncer/pkg/streams"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream operators", func() {
+	var (
+		ctx    context.Context
+		cancel func()
+		in     chan int
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		in = make(chan int)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("Map", func() {
+		It("Applies the function to each value", func() {
+			out := streams.Map(ctx, in, func(i int) string { return string(rune('a' + i)) })
+
+			in <- 0
+			Eventually(out).Should(Receive(Equal("a")))
+			in <- 2
+			Eventually(out).Should(Receive(Equal("c")))
+		})
+
+		It("Closes when the input closes", func() {
+			out := streams.Map(ctx, in, func(i int) int { return i })
+			close(in)
+			Eventually(out).Should(BeClosed())
+		})
+
+		It("Closes when the context is cancelled", func() {
+			out := streams.Map(ctx, in, func(i int) int { return i })
+			cancel()
+			Eventually(out).Should(BeClosed())
+		})
+	})
+
+	Describe("Filter", func() {
+		It("Only sends values that are kept", func() {
+			out := streams.Filter(ctx, in, func(i int) bool { return i%2 == 0 })
+
+			go func() {
+				for i := 1; i <= 4; i++ {
+					in <- i
+				}
+				close(in)
+			}()
+
+			Eventually(out).Should(Receive(Equal(2)))
+			Eventually(out).Should(Receive(Equal(4)))
+			Eventually(out).Should(BeClosed())
+		})
+	})
+
+	Describe("Throttle", func() {
+		var out <-chan int
+
+		BeforeEach(func() {
+			out = streams.Throttle(ctx, in, 100*time.Millisecond)
+		})
+
+		It("Sends the first value immediately", func() {
+			in <- 1
+			Eventually(out, 50*time.Millisecond).Should(Receive(Equal(1)))
+		})
+
+		It("Sends only the latest value received within the interval", func() {
+			in <- 1
+			Eventually(out).Should(Receive(Equal(1)))
+
+			in <- 2
+			in <- 3
+			Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+			Eventually(out).Should(Receive(Equal(3)))
+		})
+
+		It("Closes when the context is cancelled", func() {
+			cancel()
+			Eventually(out).Should(BeClosed())
+		})
+	})
+
+	Describe("Merge", func() {
+		It("Sends values from all inputs, closing once they all close", func() {
+			other := make(chan int)
+			out := streams.Merge(ctx, in, other)
+
+			in <- 1
+			Eventually(out).Should(Receive(Equal(1)))
+			other <- 2
+			Eventually(out).Should(Receive(Equal(2)))
+
+			close(in)
+			Consistently(out, 50*time.Millisecond).ShouldNot(BeClosed())
+			close(other)
+			Eventually(out).Should(BeClosed())
+		})
+	})
+
+	Describe("Latest", func() {
+		It("Never blocks the input, sending only the most recent value", func() {
+			out := streams.Latest(ctx, in)
+
+			for i := 1; i <= 3; i++ {
+				in <- i
+			}
+
+			Eventually(out).Should(Receive(Equal(3)))
+			Consistently(out, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("Sends the pending value before closing", func() {
+			out := streams.Latest(ctx, in)
+
+			in <- 1
+			close(in)
+
+			Eventually(out).Should(Receive(Equal(1)))
+			Eventually(out).Should(BeClosed())
+		})
+	})
+
+	Describe("Buffer", func() {
+		It("Queues values up to the size, in order", func() {
+			out := streams.Buffer(ctx, in, 3)
+
+			for i := 1; i <= 3; i++ {
+				in <- i
+			}
+			close(in)
+
+			Eventually(out).Should(Receive(Equal(1)))
+			Eventually(out).Should(Receive(Equal(2)))
+			Eventually(out).Should(Receive(Equal(3)))
+			Eventually(out).Should(BeClosed())
+		})
+
+		It("Blocks the input once full", func() {
+			streams.Buffer(ctx, in, 1)
+
+			in <- 1
+			Consistently(in, 50*time.Millisecond).ShouldNot(BeSent(2))
+		})
+	})
+})